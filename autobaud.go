@@ -0,0 +1,41 @@
+package serial
+
+import "errors"
+
+// ErrBaudNotDetected is returned by AutodetectBaud if the probe failed at
+// every candidate rate.
+var ErrBaudNotDetected = errors.New("serial: baud rate not detected")
+
+// DefaultAutodetectBauds are the rates tried by AutodetectBaud when no
+// candidates are given.
+var DefaultAutodetectBauds = []int{9600, 19200, 38400, 57600, 115200}
+
+// AutodetectBaud opens the named port at each of the candidate rates in
+// turn and returns the first one at which probe reports success, for
+// example by sending a command and checking the reply. The port is
+// closed after each probe, so it has to be opened again at the rate
+// found. DefaultAutodetectBauds are tried if candidates is empty. An
+// error opening the port ends the search.
+func AutodetectBaud(name string, candidates []int, probe func(Port) bool) (int, error) {
+	if len(candidates) == 0 {
+		candidates = DefaultAutodetectBauds
+	}
+
+	for _, baud := range candidates {
+		p, err := OpenPort(Config{Name: name, Baud: baud})
+		if err != nil {
+			return 0, err
+		}
+
+		ok := probe(p)
+		if err = p.Close(); err != nil {
+			return 0, err
+		}
+
+		if ok {
+			return baud, nil
+		}
+	}
+
+	return 0, ErrBaudNotDetected
+}
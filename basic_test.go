@@ -34,7 +34,8 @@ func TestConnection(t *testing.T) {
 		for {
 			n, err := s2.Read(buf)
 			if err != nil {
-				t.Fatal(err)
+				t.Error(err)
+				return
 			}
 			readCount++
 			t.Logf("Read %v %v bytes: % 02x %s", readCount, n, buf[:n], buf[:n])
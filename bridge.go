@@ -0,0 +1,54 @@
+package serial
+
+import "context"
+
+// bridgeBuffer is the size of the buffer Bridge reads into, per direction.
+const bridgeBuffer = 4096
+
+// Bridge copies the data received by a to b and the data received by b
+// to a until either port fails or ctx is done, as a gateway between two
+// ports does. Each chunk read is passed on whole with WriteAll, so a
+// write bounded by the write deadline of its port either completes or
+// ends the bridge. Read timeouts do not end it, the ports' read deadlines
+// only bound each wait for data. Bridge returns once both directions have
+// stopped: with the first error, such as a wrapped ErrPortDisconnected,
+// or nil if ctx was done. It does not close the ports.
+func Bridge(ctx context.Context, a, b Port) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	errs := make(chan error, 2)
+	go func() { errs <- pump(ctx, b, a) }()
+	go func() { errs <- pump(ctx, a, b) }()
+
+	err := <-errs
+	cancel()
+	if err2 := <-errs; err == nil {
+		err = err2
+	}
+
+	return err
+}
+
+// pump copies from src to dst for Bridge until one of them fails, or
+// returns nil once ctx is done.
+func pump(ctx context.Context, dst, src Port) error {
+	buf := make([]byte, bridgeBuffer)
+
+	for {
+		n, err := src.ReadContext(ctx, buf)
+		if n > 0 {
+			if werr := dst.WriteAll(buf[:n]); werr != nil {
+				return werr
+			}
+		}
+
+		switch {
+		case err == nil || err == ErrTimeout:
+		case ctx.Err() != nil:
+			return nil
+		default:
+			return err
+		}
+	}
+}
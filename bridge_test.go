@@ -0,0 +1,42 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBridge(t *testing.T) {
+	a, b := NewMockPort(), NewMockPort()
+	require.NoError(t, a.SetReadDeadline(10*time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- Bridge(ctx, a, b) }()
+
+	a.Inject([]byte("to b"))
+	b.Inject([]byte("to a"))
+	require.Eventually(t, func() bool {
+		return string(b.Written()) == "to b" && string(a.Written()) == "to a"
+	}, time.Second, time.Millisecond)
+
+	// timeouts on a keep the bridge going until it is canceled
+	time.Sleep(30 * time.Millisecond)
+	cancel()
+	require.NoError(t, <-done)
+}
+
+func TestBridgeDisconnect(t *testing.T) {
+	a, b := NewMockPort(), NewMockPort()
+
+	gone := fmt.Errorf("%w: unplugged", ErrPortDisconnected)
+	b.FailWrite(gone)
+	a.Inject([]byte("lost"))
+
+	err := Bridge(context.Background(), a, b)
+	require.True(t, errors.Is(err, ErrPortDisconnected), "got %v", err)
+}
@@ -0,0 +1,159 @@
+package serial
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// defaultBufferedReaderSize is the buffer size of NewBufferedReader for 0.
+const defaultBufferedReaderSize = 4096
+
+// BufferedReader buffers the input of a Port like bufio.Reader, but
+// keeps what it has buffered when a read from the port fails, timeouts
+// included: ReadUntil and ReadString then return the error without
+// consuming the partial data, so that a later call completes it. Every
+// read from the port waits up to the port's read deadline.
+type BufferedReader struct {
+	p    Port
+	buf  []byte
+	r, w int
+}
+
+// NewBufferedReader returns a BufferedReader of p with a buffer of size
+// bytes, or 4096 if size is not positive. Lines longer than the buffer
+// cannot be read with ReadUntil.
+func NewBufferedReader(p Port, size int) *BufferedReader {
+	if size <= 0 {
+		size = defaultBufferedReaderSize
+	}
+
+	return &BufferedReader{p: p, buf: make([]byte, size)}
+}
+
+// fill reads once from the port into the free space of the buffer,
+// moving the buffered data to the front first.
+func (b *BufferedReader) fill() error {
+	if b.r > 0 {
+		copy(b.buf, b.buf[b.r:b.w])
+		b.w -= b.r
+		b.r = 0
+	}
+
+	n, err := b.p.Read(b.buf[b.w:])
+	b.w += n
+
+	if n == 0 && err == nil {
+		return io.ErrNoProgress
+	}
+
+	return err
+}
+
+// Read reads into p from the buffer, or with a single read from the port
+// if the buffer is empty. Reads of at least the buffer size bypass it.
+func (b *BufferedReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if b.r == b.w {
+		if len(p) >= len(b.buf) {
+			return b.p.Read(p)
+		}
+
+		if err := b.fill(); b.r == b.w {
+			return 0, err
+		}
+	}
+
+	n := copy(p, b.buf[b.r:b.w])
+	b.r += n
+
+	return n, nil
+}
+
+// ReadByte reads a single byte.
+func (b *BufferedReader) ReadByte() (byte, error) {
+	if b.r == b.w {
+		if err := b.fill(); b.r == b.w {
+			return 0, err
+		}
+	}
+
+	c := b.buf[b.r]
+	b.r++
+
+	return c, nil
+}
+
+// Peek returns the next n bytes without consuming them, reading from the
+// port until enough are buffered. If a read from the port fails first,
+// the bytes buffered so far are returned with the error. If n is larger
+// than the buffer, Peek returns what is buffered with bufio.ErrBufferFull.
+// The slice is only valid until the next read.
+func (b *BufferedReader) Peek(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("%w: peek count", ErrInvalidArg)
+	}
+
+	if n > len(b.buf) {
+		return b.buf[b.r:b.w], bufio.ErrBufferFull
+	}
+
+	for b.w-b.r < n {
+		if err := b.fill(); err != nil {
+			return b.buf[b.r:b.w], err
+		}
+	}
+
+	return b.buf[b.r : b.r+n], nil
+}
+
+// ReadUntil returns the data up to and including the first delim. If a
+// read from the port fails first, the error is returned and the data
+// read so far stays buffered for the next call. If the buffer fills up
+// without delim, its contents are returned with bufio.ErrBufferFull.
+func (b *BufferedReader) ReadUntil(delim byte) ([]byte, error) {
+	scanned := 0
+
+	for {
+		if i := bytes.IndexByte(b.buf[b.r+scanned:b.w], delim); i >= 0 {
+			end := b.r + scanned + i + 1
+			line := append([]byte(nil), b.buf[b.r:end]...)
+			b.r = end
+			return line, nil
+		}
+
+		if b.w-b.r == len(b.buf) {
+			line := append([]byte(nil), b.buf[b.r:b.w]...)
+			b.r, b.w = 0, 0
+			return line, bufio.ErrBufferFull
+		}
+
+		scanned = b.w - b.r
+		if err := b.fill(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// ReadString is ReadUntil returning a string.
+func (b *BufferedReader) ReadString(delim byte) (string, error) {
+	line, err := b.ReadUntil(delim)
+	return string(line), err
+}
+
+// Buffered returns the number of bytes that can be read from the
+// buffer without reading from the port.
+func (b *BufferedReader) Buffered() int {
+	return b.w - b.r
+}
+
+// Available returns the number of bytes that can be read without
+// waiting: those buffered plus those waiting in the port.
+func (b *BufferedReader) Available() (int, error) {
+	n, err := b.p.Available()
+	return b.w - b.r + n, err
+}
@@ -0,0 +1,61 @@
+package serial
+
+import (
+	"bufio"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBufferedReader(t *testing.T) {
+	m := NewMockPort()
+	require.NoError(t, m.SetReadDeadline(10*time.Millisecond))
+	r := NewBufferedReader(m, 8)
+
+	// a timeout keeps the partial line for the next call
+	m.Inject([]byte("hel"))
+	_, err := r.ReadUntil('\n')
+	require.Equal(t, ErrTimeout, err)
+	require.Equal(t, 3, r.Buffered())
+
+	m.Inject([]byte("lo\nwo"))
+	line, err := r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", line)
+
+	n, err := r.Available()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	c, err := r.ReadByte()
+	require.NoError(t, err)
+	require.Equal(t, byte('w'), c)
+
+	buf := make([]byte, 4)
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "o", string(buf[:n]))
+
+	m.Inject([]byte("0123456789\n"))
+	line, err = r.ReadString('\n')
+	require.Equal(t, bufio.ErrBufferFull, err)
+	require.Equal(t, "01234567", line)
+	line, err = r.ReadString('\n')
+	require.NoError(t, err)
+	require.Equal(t, "89\n", line)
+
+	m.Inject([]byte("T12"))
+	peek, err := r.Peek(4)
+	require.Equal(t, ErrTimeout, err)
+	require.Equal(t, "T12", string(peek))
+	m.Inject([]byte("3"))
+	peek, err = r.Peek(1)
+	require.NoError(t, err)
+	require.Equal(t, "T", string(peek))
+	_, err = r.Peek(9)
+	require.Equal(t, bufio.ErrBufferFull, err)
+	line, err = r.ReadString('3')
+	require.NoError(t, err)
+	require.Equal(t, "T123", line)
+}
@@ -2,6 +2,9 @@ package serial
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"gopkg.in/yaml.v3"
@@ -13,6 +16,11 @@ import (
 // future (patches welcome), so it is recommended that you create a
 // new config addressing the fields by name rather than by order.
 //
+// A Config can be stored as YAML or JSON, and read back unchanged, with
+// parity, stop bits and the other modes written as text such as "even",
+// "1.5" and "rtscts". Unset fields are left out of YAML, so that they
+// stay unset rather than reading back as their defaults.
+//
 // For example:
 //
 //    c0 := &serial.Config{Name: "COM45", Baud: 115200, ReadTimeout: time.Millisecond * 500}
@@ -23,33 +31,353 @@ import (
 //    c1.ReadTimeout = time.Millisecond * 500
 //
 type Config struct {
-	Name string `yaml:"name,omitempty"`
-	Baud int    `yaml:"baud,omitempty"`
-	// Size is the number of data bits. If 0, DefaultSize is used.
-	Size DataSize `yaml:"dataBits"`
+	Name string `yaml:"name,omitempty" json:"name,omitempty"`
+	Baud int    `yaml:"baud,omitempty" json:"baud,omitempty"`
+	// Mode opens the port for reading, writing or both, the default. A
+	// port opened ReadOnly or WriteOnly only needs the permission for
+	// that, and fails writes with ErrReadOnly or reads with ErrWriteOnly.
+	// On posix two processes may then share a device, one reading and one
+	// writing, unless Exclusive is set; Windows opens ports exclusively.
+	Mode AccessMode `yaml:"mode,omitempty" json:"mode,omitempty"`
+	// Size is the number of data bits, 5 to 8. If 0, DefaultSize is used.
+	Size DataSize `yaml:"dataBits,omitempty" json:"dataBits"`
 	// Parity is the bit to use and defaults to ParityNone (no parity bit).
-	Parity Parity `yaml:"parity"`
+	Parity Parity `yaml:"parity,omitempty" json:"parity"`
 	// StopBits number of stop bits to use. Default is 1 (1 stop bit).
-	StopBits StopBits     `yaml:"stopBits"`
-	DumpRx   func([]byte) `yaml:"-"`
-	DumpTx   func([]byte) `yaml:"-"`
-	timeout  time.Duration
+	StopBits StopBits `yaml:"stopBits,omitempty" json:"stopBits"`
+	// FlowControl selects the handshake used to pace data. Default is
+	// FlowNone (no flow control).
+	FlowControl FlowControl `yaml:"flowControl" json:"flowControl"`
+	// XonChar and XoffChar are the characters used by FlowSoftware, for
+	// protocols that need 0x11 and 0x13 as data. They have to differ. If
+	// 0, DefaultXonChar and DefaultXoffChar are used.
+	XonChar  byte `yaml:"xonChar,omitempty" json:"xonChar,omitempty"`
+	XoffChar byte `yaml:"xoffChar,omitempty" json:"xoffChar,omitempty"`
+	// DSRPollInterval is how often a write paused by FlowDSRDTR checks
+	// DSR on posix, where it is polled; 10ms if 0. Shorter intervals
+	// resume sooner at the cost of CPU. Ignored on Windows.
+	DSRPollInterval time.Duration `yaml:"dsrPollInterval,omitempty" json:"dsrPollInterval,omitempty"`
+	// RS485 enables the driver's RS-485 half-duplex mode at open.
+	RS485 RS485Config `yaml:"rs485,omitempty" json:"rs485,omitempty"`
+	// SuppressTxEcho is a software half-duplex mode for RS-485 adapters
+	// without automatic turnaround, whose own transmissions echo back
+	// into the input (posix only). Each Write waits for its data to be
+	// transmitted and then for TxEchoTail, while reads drop what they
+	// receive; the input received meanwhile is discarded at the end.
+	// With TxRTS, RTS is asserted for the duration to enable the line
+	// driver.
+	SuppressTxEcho bool          `yaml:"suppressTxEcho,omitempty" json:"suppressTxEcho,omitempty"`
+	TxEchoTail     time.Duration `yaml:"txEchoTail,omitempty" json:"txEchoTail,omitempty"`
+	TxRTS          bool          `yaml:"txRts,omitempty" json:"txRts,omitempty"`
+	// LowLatency asks the driver to push received data to readers
+	// immediately instead of batching it (Linux only).
+	LowLatency bool `yaml:"lowLatency,omitempty" json:"lowLatency,omitempty"`
+	// VMin and VTime give Read the termios non-canonical semantics
+	// (posix only): return once VMin bytes were received or, with VTime
+	// set, once the line was idle for VTime tenths of a second after the
+	// first byte. With VMin 0, Read returns as soon as any data arrives
+	// or VTime passes. The read deadline still bounds the whole read.
+	// If both are 0, Read returns as soon as any data is available.
+	VMin  uint8 `yaml:"vmin,omitempty" json:"vmin,omitempty"`
+	VTime uint8 `yaml:"vtime,omitempty" json:"vtime,omitempty"`
+	// ReadIntervalTimeout and ReadTotalTimeoutMultiplier set the fields
+	// of the same name in the COMMTIMEOUTS of the port (Windows only),
+	// with millisecond resolution. With ReadIntervalTimeout set, Read
+	// returns once the line was idle that long after the first byte, or
+	// once the buffer is full, much like VTime. The multiplier adds its
+	// value per byte requested to the time a Read may take. The read
+	// deadline supplies ReadTotalTimeoutConstant and still bounds the
+	// whole read; without one, Read waits for the first byte forever.
+	ReadIntervalTimeout        time.Duration `yaml:"readIntervalTimeout,omitempty" json:"readIntervalTimeout,omitempty"`
+	ReadTotalTimeoutMultiplier time.Duration `yaml:"readTotalTimeoutMultiplier,omitempty" json:"readTotalTimeoutMultiplier,omitempty"`
+	// Canonical makes each Read return one line, up to and including
+	// '\n', instead of whatever data is available. On posix this is the
+	// termios canonical mode with the editing characters disabled, so
+	// the data is passed through unchanged; VMin and VTime do not apply.
+	// Elsewhere it is emulated by buffering. Lines longer than the read
+	// buffer are returned over several reads.
+	Canonical bool `yaml:"canonical,omitempty" json:"canonical,omitempty"`
+	// RxBufferSize and TxBufferSize request driver queues of the given
+	// size in bytes (Windows only). If 0, the default size is used.
+	RxBufferSize int `yaml:"rxBufferSize,omitempty" json:"rxBufferSize,omitempty"`
+	TxBufferSize int `yaml:"txBufferSize,omitempty" json:"txBufferSize,omitempty"`
+	// InitialDTR and InitialRTS set the DTR and RTS lines as part of
+	// OpenPort, before any data is transferred.
+	InitialDTR LineState `yaml:"initialDtr,omitempty" json:"initialDtr,omitempty"`
+	InitialRTS LineState `yaml:"initialRts,omitempty" json:"initialRts,omitempty"`
+	// NoResetOnOpen avoids the DTR pulse that resets Arduino style boards
+	// when the port is opened. On posix HUPCL is cleared, so that DTR
+	// stays asserted when the port is closed and the next open does not
+	// toggle it; the first open after the device appears may still
+	// pulse DTR. On Windows DTR is left deasserted unless InitialDTR
+	// says otherwise.
+	NoResetOnOpen bool `yaml:"noResetOnOpen,omitempty" json:"noResetOnOpen,omitempty"`
+	// NoHangupOnClose leaves DTR and RTS asserted when the port is
+	// closed, instead of dropping them to hang up a modem, by clearing
+	// HUPCL (posix only). The lines then stay as they are after the
+	// program exits. NoResetOnOpen implies it. It is not supported on
+	// Windows, where the drivers drop the lines on close regardless.
+	NoHangupOnClose bool `yaml:"noHangupOnClose,omitempty" json:"noHangupOnClose,omitempty"`
+	// UseModemControl honors the modem control lines by clearing CLOCAL
+	// (posix only), for genuine modem connections. OpenPort then blocks
+	// until the modem asserts carrier (DCD), for up to ReadTimeout, which
+	// by default waits forever: do not set it on 3-wire connections. Once
+	// open, a loss of carrier hangs up the port and reads and writes
+	// fail. By default CLOCAL is set and the lines are ignored.
+	UseModemControl bool `yaml:"useModemControl,omitempty" json:"useModemControl,omitempty"`
+	// Exclusive prevents other processes from opening the port while it
+	// is open. Windows ports are always opened exclusively.
+	Exclusive bool `yaml:"exclusive,omitempty" json:"exclusive,omitempty"`
+	// UseLockFile makes the port honor and create UUCP lock files such
+	// as /var/lock/LCK..ttyUSB0, as used by minicom, gpsd and others. The
+	// lock holds the PID of the process and is removed on Close; a lock
+	// left by a process that has exited is taken over. Ignored on
+	// Windows, where ports are always opened exclusively.
+	UseLockFile bool `yaml:"useLockFile,omitempty" json:"useLockFile,omitempty"`
+	// InputCRLF and OutputCRLF translate line endings like the icrnl,
+	// inlcr, igncr, ocrnl and onlcr settings of stty, for devices whose
+	// line endings differ from the program's. By default the data passes
+	// unchanged. On posix the terminal driver translates; elsewhere it
+	// is emulated by Read and Write.
+	InputCRLF  CRLFMode `yaml:"inputCrlf,omitempty" json:"inputCrlf,omitempty"`
+	OutputCRLF CRLFMode `yaml:"outputCrlf,omitempty" json:"outputCrlf,omitempty"`
+	// ReportErrors enables parity checking of received characters and
+	// counts characters received with parity or framing errors, see
+	// Port.ErrorCounts. The characters themselves are still returned by
+	// Read.
+	ReportErrors bool `yaml:"reportErrors,omitempty" json:"reportErrors,omitempty"`
+	// ParityErrorMode selects what becomes of characters received with
+	// a parity error. By default parity is not checked and they are
+	// passed through, unless ReportErrors is set, which implies
+	// ParityErrorMark.
+	ParityErrorMode ParityErrorMode `yaml:"parityErrorMode,omitempty" json:"parityErrorMode,omitempty"`
+	// DetectBreak makes Read return ErrBreak where a break condition was
+	// received, instead of a zero byte.
+	DetectBreak bool `yaml:"detectBreak,omitempty" json:"detectBreak,omitempty"`
+	// ReadTimeout is the initial read timeout, as set later by
+	// SetReadDeadline. Zero or MaxTimeout blocks until data arrives, NoWait
+	// makes reads non-blocking.
+	ReadTimeout time.Duration `yaml:"readTimeout,omitempty" json:"readTimeout,omitempty"`
+	// MaxWriteChunk, if not zero, splits writes into system calls of at
+	// most that many bytes, with the write deadline checked in between.
+	// It also bounds the chunks of WriteProgress.
+	MaxWriteChunk int          `yaml:"maxWriteChunk,omitempty" json:"maxWriteChunk,omitempty"`
+	DumpRx        func([]byte) `yaml:"-" json:"-"`
+	DumpTx        func([]byte) `yaml:"-" json:"-"`
+
+	writeTimeout time.Duration
+}
+
+// RS485Config controls the RS-485 mode of the serial driver, in which the
+// kernel asserts RTS to enable the line driver while transmitting and
+// releases it once the last byte has left the shift register.
+//
+// It is currently only supported on Linux, by drivers that implement
+// ioctl(TIOCSRS485).
+type RS485Config struct {
+	Enabled bool `yaml:"enabled" json:"enabled"`
+	// RTSOnSend is the RTS level (true for asserted) while sending.
+	RTSOnSend bool `yaml:"rtsOnSend" json:"rtsOnSend"`
+	// RTSAfterSend is the RTS level (true for asserted) after sending.
+	RTSAfterSend bool `yaml:"rtsAfterSend" json:"rtsAfterSend"`
+	// RxDuringTx keeps the receiver enabled while sending.
+	RxDuringTx bool `yaml:"rxDuringTx" json:"rxDuringTx"`
+	// DelayRTSBeforeSend and DelayRTSAfterSend are the turnaround delays
+	// around a transmission, with millisecond resolution.
+	DelayRTSBeforeSend time.Duration `yaml:"delayRtsBeforeSend,omitempty" json:"delayRtsBeforeSend,omitempty"`
+	DelayRTSAfterSend  time.Duration `yaml:"delayRtsAfterSend,omitempty" json:"delayRtsAfterSend,omitempty"`
 }
 
 const DefaultSize = 8 // Default value for Config.Size
 
+// Validate checks the settings in c without opening the port, returning
+// ErrBadSize, ErrBadParity, ErrBadStopBits, ErrBadFlowControl or an
+// error wrapping ErrInvalidArg for the first bad one. Zero values stand
+// for the defaults applied by OpenPort and are valid.
+func (c Config) Validate() error {
+	size := c.Size
+	if size == 0 {
+		size = DefaultSize
+	}
+	if size < 5 || size > 8 {
+		return ErrBadSize
+	}
+
+	switch c.Parity {
+	case 0, ParityNone, ParityOdd, ParityEven, ParityMark, ParitySpace:
+	default:
+		return ErrBadParity
+	}
+
+	stop := c.StopBits
+	if stop == 0 {
+		stop = Stop1
+	}
+	switch stop {
+	case Stop1, Stop1Half, Stop2:
+	default:
+		return ErrBadStopBits
+	}
+	if err := checkStopBits(size, stop); err != nil {
+		return err
+	}
+
+	switch c.FlowControl {
+	case FlowNone, FlowHardware, FlowSoftware, FlowDSRDTR:
+	default:
+		return ErrBadFlowControl
+	}
+
+	if c.Baud <= 0 {
+		return fmt.Errorf("%w: baud rate %d", ErrInvalidArg, c.Baud)
+	}
+
+	xon, xoff := c.XonChar, c.XoffChar
+	if xon == 0 {
+		xon = DefaultXonChar
+	}
+	if xoff == 0 {
+		xoff = DefaultXoffChar
+	}
+	if xon == xoff {
+		return fmt.Errorf("%w: XON and XOFF are both %#x", ErrInvalidArg, xon)
+	}
+
+	for _, l := range []LineState{c.InitialDTR, c.InitialRTS} {
+		switch l {
+		case LineLeave, LineAssert, LineDeassert:
+		default:
+			return fmt.Errorf("%w: line state %d", ErrInvalidArg, l)
+		}
+	}
+
+	switch c.ParityErrorMode {
+	case ParityErrorPassThrough, ParityErrorMark, ParityErrorDrop:
+	default:
+		return fmt.Errorf("%w: parity error mode %d", ErrInvalidArg, c.ParityErrorMode)
+	}
+
+	switch c.Mode {
+	case ReadWrite, ReadOnly, WriteOnly:
+	default:
+		return fmt.Errorf("%w: access mode %d", ErrInvalidArg, c.Mode)
+	}
+
+	switch c.InputCRLF {
+	case CRLFNone, CRToLF, LFToCR, IgnoreCR:
+	default:
+		return fmt.Errorf("%w: input CR/LF mode %d", ErrInvalidArg, c.InputCRLF)
+	}
+
+	switch c.OutputCRLF {
+	case CRLFNone, CRToLF, LFToCRLF:
+	default:
+		return fmt.Errorf("%w: output CR/LF mode %d", ErrInvalidArg, c.OutputCRLF)
+	}
+
+	if c.DSRPollInterval < 0 {
+		return fmt.Errorf("%w: DSR poll interval", ErrInvalidArg)
+	}
+
+	if c.RxBufferSize < 0 || c.TxBufferSize < 0 {
+		return fmt.Errorf("%w: buffer size", ErrInvalidArg)
+	}
+
+	if c.ReadIntervalTimeout < 0 || c.ReadTotalTimeoutMultiplier < 0 {
+		return fmt.Errorf("%w: read timeout", ErrInvalidArg)
+	}
+
+	if c.MaxWriteChunk < 0 {
+		return fmt.Errorf("%w: write chunk size", ErrInvalidArg)
+	}
+
+	if c.TxEchoTail < 0 {
+		return fmt.Errorf("%w: echo tail", ErrInvalidArg)
+	}
+
+	// the handshake drives RTS itself
+	if c.SuppressTxEcho && c.TxRTS && c.FlowControl == FlowHardware {
+		return fmt.Errorf("%w: TxRTS with hardware flow control", ErrInvalidArg)
+	}
+
+	return nil
+}
+
+// parityErrorMode returns the ParityErrorMode in effect, which
+// ReportErrors turns to ParityErrorMark unless another mode is chosen.
+func (c *Config) parityErrorMode() ParityErrorMode {
+	if c.ReportErrors && c.ParityErrorMode == ParityErrorPassThrough {
+		return ParityErrorMark
+	}
+
+	return c.ParityErrorMode
+}
+
+// checkStopBits returns ErrBadStopBits for framings a UART cannot
+// produce: 1.5 stop bits are only available with 5 data bits, which in
+// turn cannot be combined with 2 stop bits.
+func checkStopBits(size DataSize, stop StopBits) error {
+	switch {
+	case stop == Stop1Half && size != 5, stop == Stop2 && size == 5:
+		return ErrBadStopBits
+	}
+
+	return nil
+}
+
+const (
+	DefaultXonChar  = 0x11 // Default value for Config.XonChar (DC1)
+	DefaultXoffChar = 0x13 // Default value for Config.XoffChar (DC3)
+)
+
 type DataSize byte
 type StopBits byte
 type Parity byte
+type FlowControl byte
+
+// LineState selects the level of a modem control line at open.
+type LineState byte
+
+const (
+	LineLeave    LineState = iota // leave the line as the driver sets it
+	LineAssert                    // assert the line
+	LineDeassert                  // deassert the line
+)
+
+// ParityErrorMode selects the handling of received characters with a
+// parity error, see Config.ParityErrorMode.
+type ParityErrorMode byte
 
 const (
+	// ParityErrorPassThrough returns the characters as received.
+	ParityErrorPassThrough ParityErrorMode = iota
+	// ParityErrorMark checks parity and marks the characters in the
+	// input (PARMRK), as ReportErrors does on posix: Read still returns
+	// them and counts them, and ErrorReader reports where they are. On
+	// Windows they are replaced by 0xFF.
+	ParityErrorMark
+	// ParityErrorDrop checks parity and discards the characters
+	// (IGNPAR). It is not supported on Windows.
+	ParityErrorDrop
+)
+
+const (
+	// MaxTimeout, like a zero timeout, makes reads and writes block with no
+	// deadline.
 	MaxTimeout = time.Duration(1<<63 - 1)
+	// MinTimeout is the resolution of read and write timeouts; positive
+	// timeouts below it are rounded up to it.
+	MinTimeout = time.Millisecond
+	// NoWait, or any negative timeout, makes reads non-blocking: they
+	// return the data already received, or ErrTimeout at once if there
+	// is none.
+	NoWait = time.Duration(-1)
 )
 
 const (
 	Stop1     StopBits = 1
-	Stop1Half StopBits = 15
-	Stop2     StopBits = 2
+	Stop1Half StopBits = 15 // only valid with 5 data bits
+	Stop2     StopBits = 2  // not valid with 5 data bits
 )
 
 const (
@@ -60,6 +388,101 @@ const (
 	ParitySpace Parity = 'S' // parity bit is always 0
 )
 
+const (
+	FlowNone     FlowControl = iota
+	FlowHardware             // RTS/CTS handshake
+	// FlowSoftware enables the XON/XOFF handshake. Received XON and XOFF
+	// characters are consumed by the driver and never returned by Read,
+	// so it is only suitable for text protocols or binary protocols that
+	// escape those bytes.
+	FlowSoftware
+	// FlowDSRDTR enables the DSR/DTR handshake used by some printers. On
+	// Windows the driver paces the output by DSR and drives DTR. Posix
+	// terminals have no such mode, so before each write to the driver
+	// Write polls DSR in software every Config.DSRPollInterval and waits
+	// while it is deasserted; Config.MaxWriteChunk makes the pacing
+	// finer. DTR is left asserted.
+	FlowDSRDTR
+)
+
+// parityNames are the names of the parity settings in text form.
+var parityNames = map[Parity]string{
+	ParityNone:  "none",
+	ParityOdd:   "odd",
+	ParityEven:  "even",
+	ParityMark:  "mark",
+	ParitySpace: "space",
+}
+
+// MarshalText returns the name of the parity setting, such as "even", or
+// "" for the zero value.
+func (p Parity) MarshalText() ([]byte, error) {
+	if p == 0 {
+		return []byte{}, nil
+	}
+
+	name, ok := parityNames[p]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrBadParity, byte(p))
+	}
+
+	return []byte(name), nil
+}
+
+// UnmarshalText sets p from a name returned by MarshalText, returning an
+// error wrapping ErrBadParity for any other.
+func (p *Parity) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*p = 0
+		return nil
+	}
+
+	for v, name := range parityNames {
+		if string(text) == name {
+			*p = v
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: %q", ErrBadParity, text)
+}
+
+// MarshalText returns the number of stop bits as "1", "1.5" or "2", or
+// "" for the zero value.
+func (s StopBits) MarshalText() ([]byte, error) {
+	switch s {
+	case 0:
+		return []byte{}, nil
+	case Stop1:
+		return []byte("1"), nil
+	case Stop1Half:
+		return []byte("1.5"), nil
+	case Stop2:
+		return []byte("2"), nil
+	}
+
+	return nil, fmt.Errorf("%w: %d", ErrBadStopBits, byte(s))
+}
+
+// UnmarshalText sets s from a value returned by MarshalText, returning
+// an error wrapping ErrBadStopBits for any other.
+func (s *StopBits) UnmarshalText(text []byte) error {
+	switch string(text) {
+	case "":
+		*s = 0
+	case "1":
+		*s = Stop1
+	case "1.5":
+		*s = Stop1Half
+	case "2":
+		*s = Stop2
+	default:
+		return fmt.Errorf("%w: %q", ErrBadStopBits, text)
+	}
+
+	return nil
+}
+
 func (p *Parity) UnmarshalYAML(node *yaml.Node) error {
 	var res Parity
 	switch node.Value {
@@ -109,14 +532,16 @@ func (d *DataSize) UnmarshalYAML(node *yaml.Node) error {
 	var res DataSize
 
 	switch node.Value {
+	case "5":
+		res = 5
+	case "6":
+		res = 6
 	case "7":
 		res = 7
 	case "":
 		fallthrough
 	case "8":
 		res = DefaultSize
-	case "9":
-		res = 9
 	default:
 		return errors.New("invalid data size value")
 	}
@@ -125,3 +550,270 @@ func (d *DataSize) UnmarshalYAML(node *yaml.Node) error {
 
 	return nil
 }
+
+func (l *LineState) UnmarshalYAML(node *yaml.Node) error {
+	var res LineState
+
+	switch node.Value {
+	case "":
+		fallthrough
+	case "leave":
+		res = LineLeave
+	case "assert":
+		res = LineAssert
+	case "deassert":
+		res = LineDeassert
+	default:
+		return errors.New("invalid line state value")
+	}
+
+	*l = res
+
+	return nil
+}
+
+// MarshalText returns the name of the line state as accepted by
+// UnmarshalYAML, such as "assert".
+func (l LineState) MarshalText() ([]byte, error) {
+	switch l {
+	case LineLeave:
+		return []byte("leave"), nil
+	case LineAssert:
+		return []byte("assert"), nil
+	case LineDeassert:
+		return []byte("deassert"), nil
+	}
+
+	return nil, fmt.Errorf("%w: line state %d", ErrInvalidArg, byte(l))
+}
+
+// UnmarshalText sets l from a name returned by MarshalText.
+func (l *LineState) UnmarshalText(text []byte) error {
+	return l.UnmarshalYAML(&yaml.Node{Value: string(text)})
+}
+
+func (m *ParityErrorMode) UnmarshalYAML(node *yaml.Node) error {
+	var res ParityErrorMode
+
+	switch node.Value {
+	case "":
+		fallthrough
+	case "passthrough":
+		res = ParityErrorPassThrough
+	case "mark":
+		res = ParityErrorMark
+	case "drop":
+		res = ParityErrorDrop
+	default:
+		return errors.New("invalid parity error mode value")
+	}
+
+	*m = res
+
+	return nil
+}
+
+// MarshalText returns the name of the parity error mode as accepted by
+// UnmarshalYAML, such as "mark".
+func (m ParityErrorMode) MarshalText() ([]byte, error) {
+	switch m {
+	case ParityErrorPassThrough:
+		return []byte("passthrough"), nil
+	case ParityErrorMark:
+		return []byte("mark"), nil
+	case ParityErrorDrop:
+		return []byte("drop"), nil
+	}
+
+	return nil, fmt.Errorf("%w: parity error mode %d", ErrInvalidArg, byte(m))
+}
+
+// UnmarshalText sets m from a name returned by MarshalText.
+func (m *ParityErrorMode) UnmarshalText(text []byte) error {
+	return m.UnmarshalYAML(&yaml.Node{Value: string(text)})
+}
+
+func (f *FlowControl) UnmarshalYAML(node *yaml.Node) error {
+	var res FlowControl
+
+	switch node.Value {
+	case "":
+		fallthrough
+	case "none":
+		res = FlowNone
+	case "rtscts":
+		res = FlowHardware
+	case "xonxoff":
+		res = FlowSoftware
+	case "dsrdtr":
+		res = FlowDSRDTR
+	default:
+		return errors.New("invalid flow control value")
+	}
+
+	*f = res
+
+	return nil
+}
+
+// MarshalText returns the name of the flow control mode as accepted by
+// UnmarshalYAML, such as "rtscts".
+func (f FlowControl) MarshalText() ([]byte, error) {
+	switch f {
+	case FlowNone:
+		return []byte("none"), nil
+	case FlowHardware:
+		return []byte("rtscts"), nil
+	case FlowSoftware:
+		return []byte("xonxoff"), nil
+	case FlowDSRDTR:
+		return []byte("dsrdtr"), nil
+	}
+
+	return nil, fmt.Errorf("%w: %d", ErrBadFlowControl, byte(f))
+}
+
+// UnmarshalText sets f from a name returned by MarshalText.
+func (f *FlowControl) UnmarshalText(text []byte) error {
+	return f.UnmarshalYAML(&yaml.Node{Value: string(text)})
+}
+
+// AccessMode selects the directions a port is opened for, see
+// Config.Mode.
+type AccessMode byte
+
+const (
+	ReadWrite AccessMode = iota
+	ReadOnly
+	WriteOnly
+)
+
+func (m *AccessMode) UnmarshalYAML(node *yaml.Node) error {
+	var res AccessMode
+
+	switch node.Value {
+	case "":
+		fallthrough
+	case "readwrite":
+		res = ReadWrite
+	case "readonly":
+		res = ReadOnly
+	case "writeonly":
+		res = WriteOnly
+	default:
+		return errors.New("invalid access mode value")
+	}
+
+	*m = res
+
+	return nil
+}
+
+// MarshalText returns the name of the access mode as accepted by
+// UnmarshalYAML, such as "readonly".
+func (m AccessMode) MarshalText() ([]byte, error) {
+	switch m {
+	case ReadWrite:
+		return []byte("readwrite"), nil
+	case ReadOnly:
+		return []byte("readonly"), nil
+	case WriteOnly:
+		return []byte("writeonly"), nil
+	}
+
+	return nil, fmt.Errorf("%w: access mode %d", ErrInvalidArg, byte(m))
+}
+
+// UnmarshalText sets m from a name returned by MarshalText.
+func (m *AccessMode) UnmarshalText(text []byte) error {
+	return m.UnmarshalYAML(&yaml.Node{Value: string(text)})
+}
+
+// ParseConfig parses a connection string of the form
+// "name:baud,bits,parity,stopbits", for example "/dev/ttyUSB0:115200,8,N,1".
+// The name may also be separated from the baud rate by a comma, as in
+// "COM5,9600,7,E,2". Data bits, parity and stop bits are optional and
+// default as in OpenPort. ErrInvalidArg is returned for malformed input.
+func ParseConfig(dsn string) (Config, error) {
+	var c Config
+
+	fields := strings.Split(dsn, ",")
+	if i := strings.LastIndexByte(fields[0], ':'); i >= 0 {
+		c.Name = fields[0][:i]
+		fields[0] = fields[0][i+1:]
+	} else {
+		c.Name = fields[0]
+		fields = fields[1:]
+	}
+
+	if c.Name == "" || len(fields) == 0 || len(fields) > 4 {
+		return Config{}, fmt.Errorf("%w: connection string %q", ErrInvalidArg, dsn)
+	}
+
+	baud, err := strconv.Atoi(fields[0])
+	if err != nil || baud <= 0 {
+		return Config{}, fmt.Errorf("%w: baud rate %q", ErrInvalidArg, fields[0])
+	}
+	c.Baud = baud
+
+	if len(fields) > 1 {
+		size, err := strconv.Atoi(fields[1])
+		if err != nil || size < 5 || size > 8 {
+			return Config{}, fmt.Errorf("%w: data bits %q", ErrInvalidArg, fields[1])
+		}
+		c.Size = DataSize(size)
+	}
+
+	if len(fields) > 2 {
+		var p Parity
+		if len(fields[2]) == 1 {
+			p = Parity(strings.ToUpper(fields[2])[0])
+		}
+
+		switch p {
+		case ParityNone, ParityOdd, ParityEven, ParityMark, ParitySpace:
+			c.Parity = p
+		default:
+			return Config{}, fmt.Errorf("%w: parity %q", ErrInvalidArg, fields[2])
+		}
+	}
+
+	if len(fields) > 3 {
+		switch fields[3] {
+		case "1":
+			c.StopBits = Stop1
+		case "1.5":
+			c.StopBits = Stop1Half
+		case "2":
+			c.StopBits = Stop2
+		default:
+			return Config{}, fmt.Errorf("%w: stop bits %q", ErrInvalidArg, fields[3])
+		}
+	}
+
+	return c, nil
+}
+
+// String formats c as a connection string accepted by ParseConfig. Unset
+// fields are shown with their defaults.
+func (c Config) String() string {
+	size := c.Size
+	if size == 0 {
+		size = DefaultSize
+	}
+
+	parity := c.Parity
+	if parity == 0 {
+		parity = ParityNone
+	}
+
+	stop := "1"
+	switch c.StopBits {
+	case Stop1Half:
+		stop = "1.5"
+	case Stop2:
+		stop = "2"
+	}
+
+	return fmt.Sprintf("%s:%d,%d,%c,%s", c.Name, c.Baud, size, parity, stop)
+}
@@ -1,7 +1,10 @@
 package serial
 
 import (
+	"encoding/json"
+	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"gopkg.in/yaml.v3"
@@ -10,10 +13,135 @@ import (
 func TestConfig(t *testing.T) {
 	const stream = `
 parity: none
+flowControl: rtscts
+initialDtr: deassert
+parityErrorMode: drop
 `
 
 	var c Config
 
 	err := yaml.Unmarshal([]byte(stream), &c)
 	require.NoError(t, err)
+	require.Equal(t, ParityNone, c.Parity)
+	require.Equal(t, FlowHardware, c.FlowControl)
+	require.Equal(t, LineDeassert, c.InitialDTR)
+	require.Equal(t, ParityErrorDrop, c.ParityErrorMode)
+}
+
+func TestConfigJSON(t *testing.T) {
+	c := Config{Name: "/dev/ttyUSB0", Baud: 9600, Size: 7, Parity: ParityEven, StopBits: Stop2, ReadTimeout: time.Second}
+
+	data, err := json.Marshal(c)
+	require.NoError(t, err)
+	require.Contains(t, string(data), `"parity":"even","stopBits":"2"`)
+
+	var c2 Config
+	require.NoError(t, json.Unmarshal(data, &c2))
+	require.Equal(t, c, c2)
+
+	data, err = yaml.Marshal(map[string]interface{}{"parity": ParityMark, "stopBits": Stop1Half})
+	require.NoError(t, err)
+	require.Equal(t, "parity: mark\nstopBits: \"1.5\"\n", string(data))
+
+	err = json.Unmarshal([]byte(`{"parity":"bogus"}`), &c2)
+	require.True(t, errors.Is(err, ErrBadParity))
+	err = json.Unmarshal([]byte(`{"stopBits":"3"}`), &c2)
+	require.True(t, errors.Is(err, ErrBadStopBits))
+}
+
+func TestConfigYAML(t *testing.T) {
+	full := Config{
+		Name: "/dev/ttyUSB0", Baud: 9600, Mode: ReadOnly, Size: 7, Parity: ParityEven, StopBits: Stop2,
+		FlowControl: FlowDSRDTR, InitialDTR: LineAssert, InitialRTS: LineDeassert,
+		InputCRLF: CRToLF, OutputCRLF: LFToCRLF, ParityErrorMode: ParityErrorDrop,
+		RS485: RS485Config{Enabled: true, DelayRTSBeforeSend: time.Millisecond},
+		ReadTimeout: time.Second,
+	}
+
+	data, err := yaml.Marshal(full)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "flowControl: dsrdtr\n")
+	require.Contains(t, string(data), "initialRts: deassert\n")
+
+	for _, c := range []Config{full, {}, {Name: "x", Baud: 9600}} {
+		data, err := yaml.Marshal(c)
+		require.NoError(t, err)
+
+		var c2 Config
+		require.NoError(t, yaml.Unmarshal(data, &c2), string(data))
+		require.Equal(t, c, c2)
+
+		data, err = json.Marshal(c)
+		require.NoError(t, err)
+
+		c2 = Config{}
+		require.NoError(t, json.Unmarshal(data, &c2))
+		require.Equal(t, c, c2)
+	}
+
+	_, err = yaml.Marshal(Config{FlowControl: 9})
+	require.True(t, errors.Is(err, ErrBadFlowControl))
+}
+
+func TestParseConfig(t *testing.T) {
+	c, err := ParseConfig("/dev/ttyUSB0:115200,8,N,1")
+	require.NoError(t, err)
+	require.Equal(t, Config{Name: "/dev/ttyUSB0", Baud: 115200, Size: 8, Parity: ParityNone, StopBits: Stop1}, c)
+	require.Equal(t, "/dev/ttyUSB0:115200,8,N,1", c.String())
+
+	c, err = ParseConfig("COM5,9600,7,e,1.5")
+	require.NoError(t, err)
+	require.Equal(t, Config{Name: "COM5", Baud: 9600, Size: 7, Parity: ParityEven, StopBits: Stop1Half}, c)
+
+	c, err = ParseConfig(c.String())
+	require.NoError(t, err)
+	require.Equal(t, "COM5", c.Name)
+	require.Equal(t, Stop1Half, c.StopBits)
+
+	c, err = ParseConfig("/dev/ttyS0:9600")
+	require.NoError(t, err)
+	require.Equal(t, "/dev/ttyS0:9600,8,N,1", c.String())
+
+	for _, dsn := range []string{"", "COM5", ":9600", "COM5:fast", "COM5:9600,8,X,1", "COM5:9600,8,N,3", "COM5:9600,4", "COM5:9600,8,,1", "COM5:9600,8,N,1,x"} {
+		_, err := ParseConfig(dsn)
+		require.True(t, errors.Is(err, ErrInvalidArg), dsn)
+	}
+}
+
+func TestCheckStopBits(t *testing.T) {
+	require.NoError(t, checkStopBits(5, Stop1Half))
+	require.NoError(t, checkStopBits(5, Stop1))
+	require.NoError(t, checkStopBits(8, Stop2))
+	require.Equal(t, ErrBadStopBits, checkStopBits(8, Stop1Half))
+	require.Equal(t, ErrBadStopBits, checkStopBits(5, Stop2))
+
+	_, err := OpenPort(Config{Name: "unused", Baud: 9600, StopBits: Stop1Half})
+	require.Equal(t, ErrBadStopBits, err)
+}
+
+func TestValidate(t *testing.T) {
+	require.NoError(t, Config{Name: "COM1", Baud: 9600}.Validate())
+	require.NoError(t, Config{Name: "COM1", Baud: 9600, Size: 7, Parity: ParityEven, StopBits: Stop2}.Validate())
+
+	require.Equal(t, ErrBadSize, Config{Baud: 9600, Size: 9}.Validate())
+	require.Equal(t, ErrBadParity, Config{Baud: 9600, Parity: 'X'}.Validate())
+	require.Equal(t, ErrBadStopBits, Config{Baud: 9600, StopBits: 3}.Validate())
+	require.Equal(t, ErrBadFlowControl, Config{Baud: 9600, FlowControl: 7}.Validate())
+	require.True(t, errors.Is(Config{}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, InitialDTR: 5}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, ReadIntervalTimeout: -time.Millisecond}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, TxEchoTail: -time.Millisecond}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, MaxWriteChunk: -1}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, ParityErrorMode: 3}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, Mode: 3}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, InputCRLF: LFToCRLF}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, DSRPollInterval: -time.Millisecond}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, OutputCRLF: IgnoreCR}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, XonChar: 0x01, XoffChar: 0x01}.Validate(), ErrInvalidArg))
+	require.True(t, errors.Is(Config{Baud: 9600, XonChar: DefaultXoffChar}.Validate(), ErrInvalidArg))
+	require.NoError(t, Config{Baud: 9600, XonChar: 0x01, XoffChar: 0x02}.Validate())
+	require.True(t, errors.Is(Config{Baud: 9600, SuppressTxEcho: true, TxRTS: true, FlowControl: FlowHardware}.Validate(), ErrInvalidArg))
+
+	_, err := OpenPort(Config{Name: "unused", Baud: 9600, Size: 4})
+	require.Equal(t, ErrBadSize, err)
 }
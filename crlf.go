@@ -0,0 +1,141 @@
+package serial
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CRLFMode selects a translation of line endings, see Config.InputCRLF
+// and Config.OutputCRLF.
+type CRLFMode byte
+
+const (
+	CRLFNone CRLFMode = iota // pass CR and LF through unchanged
+	CRToLF                   // CR becomes LF (icrnl, ocrnl)
+	LFToCR                   // LF becomes CR (inlcr), input only
+	IgnoreCR                 // CR is discarded (igncr), input only
+	LFToCRLF                 // LF becomes CR LF (onlcr), output only
+)
+
+func (m *CRLFMode) UnmarshalYAML(node *yaml.Node) error {
+	var res CRLFMode
+
+	switch node.Value {
+	case "":
+		fallthrough
+	case "none":
+		res = CRLFNone
+	case "crtolf":
+		res = CRToLF
+	case "lftocr":
+		res = LFToCR
+	case "ignorecr":
+		res = IgnoreCR
+	case "lftocrlf":
+		res = LFToCRLF
+	default:
+		return errors.New("invalid CR/LF mode value")
+	}
+
+	*m = res
+
+	return nil
+}
+
+// MarshalText returns the name of the CR/LF mode as accepted by
+// UnmarshalYAML, such as "crtolf".
+func (m CRLFMode) MarshalText() ([]byte, error) {
+	switch m {
+	case CRLFNone:
+		return []byte("none"), nil
+	case CRToLF:
+		return []byte("crtolf"), nil
+	case LFToCR:
+		return []byte("lftocr"), nil
+	case IgnoreCR:
+		return []byte("ignorecr"), nil
+	case LFToCRLF:
+		return []byte("lftocrlf"), nil
+	}
+
+	return nil, fmt.Errorf("%w: CR/LF mode %d", ErrInvalidArg, byte(m))
+}
+
+// UnmarshalText sets m from a name returned by MarshalText.
+func (m *CRLFMode) UnmarshalText(text []byte) error {
+	return m.UnmarshalYAML(&yaml.Node{Value: string(text)})
+}
+
+// translateInput applies the input translation m to b in place and
+// returns the length of the result, for platforms where the driver
+// cannot do it.
+func translateInput(m CRLFMode, b []byte) int {
+	switch m {
+	case CRToLF:
+		replaceByte(b, '\r', '\n')
+	case LFToCR:
+		replaceByte(b, '\n', '\r')
+	case IgnoreCR:
+		n := 0
+		for _, c := range b {
+			if c != '\r' {
+				b[n] = c
+				n++
+			}
+		}
+		return n
+	}
+
+	return len(b)
+}
+
+// translateOutput returns b with the output translation m applied,
+// without modifying b.
+func translateOutput(m CRLFMode, b []byte) []byte {
+	switch m {
+	case CRToLF:
+		if bytes.IndexByte(b, '\r') >= 0 {
+			b = append([]byte(nil), b...)
+			replaceByte(b, '\r', '\n')
+		}
+	case LFToCRLF:
+		if bytes.IndexByte(b, '\n') >= 0 {
+			b = bytes.Replace(b, []byte{'\n'}, []byte{'\r', '\n'}, -1)
+		}
+	}
+
+	return b
+}
+
+// outputWritten returns how many bytes of b were sent once n bytes of
+// its translation with m were written. A CR LF counts once it is
+// written whole.
+func outputWritten(m CRLFMode, b []byte, n int) int {
+	if m != LFToCRLF {
+		return n
+	}
+
+	i := 0
+	for _, c := range b {
+		if c == '\n' {
+			n--
+		}
+		if n--; n < 0 {
+			break
+		}
+		i++
+	}
+
+	return i
+}
+
+func replaceByte(b []byte, old, new byte) {
+	for i, c := range b {
+		if c == old {
+			b[i] = new
+		}
+	}
+}
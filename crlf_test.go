@@ -0,0 +1,35 @@
+package serial
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranslateInput(t *testing.T) {
+	for _, tc := range []struct {
+		m       CRLFMode
+		in, out string
+	}{
+		{CRLFNone, "a\r\nb", "a\r\nb"},
+		{CRToLF, "a\rb\r", "a\nb\n"},
+		{LFToCR, "a\nb", "a\rb"},
+		{IgnoreCR, "a\r\nb\r", "a\nb"},
+	} {
+		b := []byte(tc.in)
+		n := translateInput(tc.m, b)
+		require.Equal(t, tc.out, string(b[:n]))
+	}
+}
+
+func TestTranslateOutput(t *testing.T) {
+	in := []byte("a\nb\r")
+	require.Equal(t, "a\r\nb\r", string(translateOutput(LFToCRLF, in)))
+	require.Equal(t, "a\nb\n", string(translateOutput(CRToLF, in)))
+	require.Equal(t, "a\nb\r", string(in))
+
+	require.Equal(t, 1, outputWritten(LFToCRLF, in, 2))
+	require.Equal(t, 2, outputWritten(LFToCRLF, in, 3))
+	require.Equal(t, 4, outputWritten(LFToCRLF, in, 5))
+	require.Equal(t, 3, outputWritten(CRToLF, in, 3))
+}
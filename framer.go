@@ -0,0 +1,162 @@
+package serial
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+// FramerOptions describes the frames of a Framer: a header of HeaderSize
+// bytes starting with Magic and holding the payload length, the payload,
+// and a checksum of ChecksumSize bytes over header and payload. The
+// header bytes besides Magic and the length are written as zeros and not
+// checked.
+type FramerOptions struct {
+	Magic        []byte           // bytes every header starts with, if any
+	HeaderSize   int              // size of the header, Magic and length field included
+	LengthOffset int              // offset of the length field in the header
+	LengthSize   int              // size of the length field: 1, 2 or 4 bytes
+	ByteOrder    binary.ByteOrder // of the length field, binary.BigEndian if nil
+	MaxPayload   int              // largest payload, 4096 if 0, at most what the length field holds
+
+	// Checksum, if not nil, returns the ChecksumSize bytes following the
+	// payload for a frame's header and payload.
+	ChecksumSize int
+	Checksum     func(frame []byte) []byte
+}
+
+// Framer reads and writes length-prefixed frames on a Port, as laid out
+// by its FramerOptions. ReadFrame skips whatever does not form a valid
+// frame, so a corrupted frame only loses that frame. ReadFrame and
+// WriteFrame may be called from different goroutines.
+type Framer struct {
+	p Port
+	r *BufferedReader
+	o FramerOptions
+}
+
+// NewLengthPrefixedFramer returns a Framer of p for frames laid out as o
+// says, or an error wrapping ErrInvalidArg if o is inconsistent.
+func NewLengthPrefixedFramer(p Port, o FramerOptions) (*Framer, error) {
+	switch o.LengthSize {
+	case 1, 2, 4:
+	default:
+		return nil, fmt.Errorf("%w: length field size %d", ErrInvalidArg, o.LengthSize)
+	}
+
+	if o.LengthOffset < len(o.Magic) || o.LengthOffset+o.LengthSize > o.HeaderSize {
+		return nil, fmt.Errorf("%w: length field outside the header", ErrInvalidArg)
+	}
+
+	if o.ChecksumSize < 0 || (o.ChecksumSize > 0) != (o.Checksum != nil) {
+		return nil, fmt.Errorf("%w: checksum size %d", ErrInvalidArg, o.ChecksumSize)
+	}
+
+	if o.ByteOrder == nil {
+		o.ByteOrder = binary.BigEndian
+	}
+
+	if o.MaxPayload < 0 {
+		return nil, fmt.Errorf("%w: maximum payload %d", ErrInvalidArg, o.MaxPayload)
+	} else if o.MaxPayload == 0 {
+		o.MaxPayload = defaultBufferedReaderSize
+	}
+	if max := uint64(1)<<(8*uint(o.LengthSize)) - 1; uint64(o.MaxPayload) > max {
+		o.MaxPayload = int(max)
+	}
+
+	size := o.HeaderSize + o.MaxPayload + o.ChecksumSize
+
+	return &Framer{p: p, r: NewBufferedReader(p, size), o: o}, nil
+}
+
+// ReadFrame returns the payload of the next valid frame. Bytes that do
+// not start a frame with the right Magic, a length up to MaxPayload and a
+// matching checksum are skipped one at a time. If a read from the port
+// fails, timeouts included, the error is returned and the bytes received
+// so far are kept for the next call.
+func (f *Framer) ReadFrame() ([]byte, error) {
+	for {
+		header, err := f.r.Peek(f.o.HeaderSize)
+		if err != nil {
+			return nil, err
+		}
+
+		n, ok := f.payloadSize(header)
+		if !ok {
+			_, _ = f.r.ReadByte()
+			continue
+		}
+
+		end := f.o.HeaderSize + n
+		frame, err := f.r.Peek(end + f.o.ChecksumSize)
+		if err != nil {
+			return nil, err
+		}
+
+		if f.o.Checksum != nil && !bytes.Equal(f.o.Checksum(frame[:end]), frame[end:]) {
+			_, _ = f.r.ReadByte()
+			continue
+		}
+
+		payload := append([]byte(nil), frame[f.o.HeaderSize:end]...)
+		f.r.r += len(frame)
+
+		return payload, nil
+	}
+}
+
+// payloadSize decodes the length field of header, reporting whether the
+// header is valid.
+func (f *Framer) payloadSize(header []byte) (int, bool) {
+	if !bytes.HasPrefix(header, f.o.Magic) {
+		return 0, false
+	}
+
+	field := header[f.o.LengthOffset : f.o.LengthOffset+f.o.LengthSize]
+
+	var n int
+	switch f.o.LengthSize {
+	case 1:
+		n = int(field[0])
+	case 2:
+		n = int(f.o.ByteOrder.Uint16(field))
+	case 4:
+		n = int(f.o.ByteOrder.Uint32(field))
+	}
+
+	return n, n >= 0 && n <= f.o.MaxPayload
+}
+
+// WriteFrame writes payload as a single frame with WriteAll. Payloads
+// over MaxPayload are refused with an error wrapping ErrInvalidArg.
+func (f *Framer) WriteFrame(payload []byte) error {
+	if len(payload) > f.o.MaxPayload {
+		return fmt.Errorf("%w: payload of %d bytes", ErrInvalidArg, len(payload))
+	}
+
+	frame := make([]byte, f.o.HeaderSize, f.o.HeaderSize+len(payload)+f.o.ChecksumSize)
+	copy(frame, f.o.Magic)
+
+	field := frame[f.o.LengthOffset : f.o.LengthOffset+f.o.LengthSize]
+	switch f.o.LengthSize {
+	case 1:
+		field[0] = byte(len(payload))
+	case 2:
+		f.o.ByteOrder.PutUint16(field, uint16(len(payload)))
+	case 4:
+		f.o.ByteOrder.PutUint32(field, uint32(len(payload)))
+	}
+
+	frame = append(frame, payload...)
+
+	if f.o.Checksum != nil {
+		sum := f.o.Checksum(frame)
+		if len(sum) != f.o.ChecksumSize {
+			return fmt.Errorf("%w: checksum of %d bytes", ErrInvalidArg, len(sum))
+		}
+		frame = append(frame, sum...)
+	}
+
+	return f.p.WriteAll(frame)
+}
@@ -0,0 +1,75 @@
+package serial
+
+import (
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// sum8 is an 8-bit additive checksum.
+func sum8(b []byte) []byte {
+	var s byte
+	for _, c := range b {
+		s += c
+	}
+	return []byte{s}
+}
+
+func TestFramer(t *testing.T) {
+	m := NewMockPort()
+	f, err := NewLengthPrefixedFramer(m, FramerOptions{
+		Magic:        []byte{0xAA},
+		HeaderSize:   4,
+		LengthOffset: 2,
+		LengthSize:   2,
+		ByteOrder:    binary.LittleEndian,
+		MaxPayload:   16,
+		ChecksumSize: 1,
+		Checksum:     sum8,
+	})
+	require.NoError(t, err)
+
+	require.NoError(t, f.WriteFrame([]byte("hi")))
+	frame := []byte{0xAA, 0, 2, 0, 'h', 'i'}
+	frame = append(frame, sum8(frame)...)
+	require.Equal(t, frame, m.Written())
+
+	require.True(t, errors.Is(f.WriteFrame(make([]byte, 17)), ErrInvalidArg))
+
+	// noise, a frame too long, a frame with a bad checksum
+	bad := append([]byte(nil), frame...)
+	bad[4] = 'H'
+	m.Inject([]byte{0x00, 0xAA, 0xAA, 0, 0xFF, 0})
+	m.Inject(bad)
+	m.Inject(frame)
+
+	payload, err := f.ReadFrame()
+	require.NoError(t, err)
+	require.Equal(t, []byte("hi"), payload)
+
+	empty := []byte{0xAA, 0, 0, 0, 0xAA}
+	m.Inject(empty)
+	payload, err = f.ReadFrame()
+	require.NoError(t, err)
+	require.Empty(t, payload)
+}
+
+func TestFramerOptions(t *testing.T) {
+	m := NewMockPort()
+	for _, o := range []FramerOptions{
+		{HeaderSize: 2, LengthSize: 3},
+		{HeaderSize: 1, LengthSize: 2},
+		{Magic: []byte{1}, HeaderSize: 2, LengthSize: 1},
+		{HeaderSize: 1, LengthSize: 1, ChecksumSize: 1},
+		{HeaderSize: 1, LengthSize: 1, MaxPayload: -1},
+	} {
+		_, err := NewLengthPrefixedFramer(m, o)
+		require.True(t, errors.Is(err, ErrInvalidArg), "%+v: got %v", o, err)
+	}
+
+	f, err := NewLengthPrefixedFramer(m, FramerOptions{HeaderSize: 1, LengthSize: 1})
+	require.NoError(t, err)
+	require.Equal(t, 255, f.o.MaxPayload)
+}
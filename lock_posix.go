@@ -0,0 +1,107 @@
+// +build !windows
+
+package serial
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockDir is where UUCP lock files are kept, see Config.UseLockFile.
+var lockDir = "/var/lock"
+
+// lockPath returns the UUCP lock file for the device name, such as
+// /var/lock/LCK..ttyUSB0 for /dev/ttyUSB0. Links such as the ones in
+// /dev/serial/by-id are resolved first, so that every name of a device
+// shares the lock.
+func lockPath(name string) string {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+
+	return filepath.Join(lockDir, "LCK.."+filepath.Base(name))
+}
+
+// acquireLock creates the UUCP lock file of the device name, holding
+// the PID of this process. A lock left behind by a process that no
+// longer exists is replaced; one held by a live process results in
+// ErrPortBusy.
+func acquireLock(name string) (string, error) {
+	path := lockPath(name)
+
+	// a second attempt follows the removal of a stale lock
+	for i := 0; i < 2; i++ {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			// HDB UUCP format: the PID as 10 characters and a newline
+			_, err = fmt.Fprintf(f, "%10d\n", os.Getpid())
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				_ = os.Remove(path)
+				return "", err
+			}
+
+			return path, nil
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+
+		pid, err := lockOwner(path)
+		if err != nil {
+			return "", err
+		}
+
+		if pid > 0 && ownerAlive(pid) {
+			return "", fmt.Errorf("%w: %s locked by PID %d", ErrPortBusy, name, pid)
+		}
+
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s lock file keeps reappearing", ErrPortBusy, name)
+}
+
+// lockOwner returns the PID recorded in a lock file, in either the HDB
+// text format or the older 4 byte binary format. Zero is returned for a
+// lock that does not hold a PID, or has vanished meanwhile.
+func lockOwner(path string) (int, error) {
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil {
+		return pid, nil
+	}
+
+	if len(b) == 4 {
+		return int(uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24), nil
+	}
+
+	return 0, nil
+}
+
+// ownerAlive reports whether the process pid exists. A process of
+// another user, which may not be signaled, counts as alive.
+func ownerAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// releaseLock removes a lock file created by acquireLock.
+func releaseLock(path string) {
+	if path != "" {
+		_ = os.Remove(path)
+	}
+}
@@ -0,0 +1,209 @@
+package serial
+
+import "sync"
+
+// markEscape introduces the in-band error marks produced by PARMRK: a
+// character X received with a parity or framing error arrives as
+// 0xFF 0x00 X, and a literal 0xFF arrives doubled.
+const markEscape = 0xFF
+
+// markDecoder strips PARMRK escaping from the input stream. It keeps its
+// state between calls so that sequences split across reads are decoded
+// correctly.
+type markDecoder struct {
+	state int
+}
+
+const (
+	markNone = iota
+	markEscaped
+	markError
+)
+
+// decode unescapes b in place and returns the length of the decoded
+// data. mark, if not nil, is called with the offset within the decoded
+// data and the value of every character received in error.
+func (d *markDecoder) decode(b []byte, mark func(off int, c byte)) int {
+	n := 0
+
+	for _, c := range b {
+		switch d.state {
+		case markNone:
+			if c == markEscape {
+				d.state = markEscaped
+				continue
+			}
+		case markEscaped:
+			d.state = markNone
+			if c == 0 {
+				d.state = markError
+				continue
+			}
+			if c != markEscape {
+				// not produced by PARMRK, pass the escape through
+				b[n] = markEscape
+				n++
+			}
+		case markError:
+			d.state = markNone
+			if mark != nil {
+				mark(n, c)
+			}
+		}
+
+		b[n] = c
+		n++
+	}
+
+	return n
+}
+
+// breakQueue holds received data interleaved with break conditions, so
+// that the data before a break is returned first, then ErrBreak, then
+// the data after it.
+type breakQueue struct {
+	mu  sync.Mutex
+	buf []byte
+	// brks are the offsets in buf at which a break was received.
+	brks []int
+}
+
+// push queues b, dropping the characters at the offsets in brks, which
+// are the zero characters that breaks read as.
+func (q *breakQueue) push(b []byte, brks []int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	last := 0
+	for _, off := range brks {
+		q.buf = append(q.buf, b[last:off]...)
+		q.brks = append(q.brks, len(q.buf))
+		last = off + 1
+	}
+	q.buf = append(q.buf, b[last:]...)
+}
+
+// pop moves queued data up to the next break into b and returns its
+// count, or returns ErrBreak if the break is next.
+func (q *breakQueue) pop(b []byte) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.brks) > 0 && q.brks[0] == 0 {
+		q.brks = q.brks[1:]
+		return 0, ErrBreak
+	}
+
+	end := len(q.buf)
+	if len(q.brks) > 0 {
+		end = q.brks[0]
+	}
+
+	n := copy(b, q.buf[:end])
+	q.buf = q.buf[n:]
+	for i := range q.brks {
+		q.brks[i] -= n
+	}
+
+	return n, nil
+}
+
+// empty reports whether neither data nor breaks are queued.
+func (q *breakQueue) empty() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.buf) == 0 && len(q.brks) == 0
+}
+
+// len returns the number of queued data bytes.
+func (q *breakQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.buf)
+}
+
+// reset discards everything queued.
+func (q *breakQueue) reset() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.buf = nil
+	q.brks = nil
+}
+
+// errorReader returns the marked characters of the input as errors at
+// their position in the data, see Port.ErrorReader. read fills b with
+// decoded data and calls mark with the offset of every character
+// received in error.
+type errorReader struct {
+	read func(b []byte, mark func(off int, c byte)) (int, error)
+	// breaks makes a marked zero character read as ErrBreak rather than
+	// a framing error.
+	breaks bool
+
+	space []byte
+	buf   []byte
+	marks []int
+	off   int64
+}
+
+func (r *errorReader) Read(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if len(r.buf) == 0 {
+		if cap(r.space) < len(b) {
+			r.space = make([]byte, len(b))
+		}
+
+		n, err := r.read(r.space[:len(b)], func(off int, c byte) {
+			r.marks = append(r.marks, off)
+		})
+		if err != nil {
+			return 0, err
+		}
+		r.buf = r.space[:n]
+	}
+
+	if len(r.marks) > 0 && r.marks[0] == 0 {
+		c, off := r.buf[0], r.off
+		r.marks = r.marks[1:]
+		r.consume(1)
+
+		switch {
+		case c == 0 && r.breaks:
+			return 0, ErrBreak
+		case c == 0:
+			return 0, &FrameError{Offset: off}
+		}
+
+		return 0, &ParityError{Offset: off, Char: c}
+	}
+
+	end := len(r.buf)
+	if len(r.marks) > 0 {
+		end = r.marks[0]
+	}
+
+	n := copy(b, r.buf[:end])
+	r.consume(n)
+
+	return n, nil
+}
+
+// consume drops the first n buffered bytes.
+func (r *errorReader) consume(n int) {
+	r.buf = r.buf[n:]
+	for i := range r.marks {
+		r.marks[i] -= n
+	}
+	r.off += int64(n)
+}
+
+// reset discards the buffered data and restarts the offsets from zero.
+func (r *errorReader) reset() {
+	r.buf, r.marks, r.off = nil, nil, 0
+}
@@ -0,0 +1,94 @@
+package serial
+
+import (
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMarkDecoder(t *testing.T) {
+	var d markDecoder
+	var marks []int
+
+	mark := func(off int, c byte) {
+		marks = append(marks, off)
+	}
+
+	b := []byte{'a', 0xFF, 0xFF, 'b', 0xFF, 0x00, 'c', 'd', 0xFF}
+	n := d.decode(b, mark)
+	require.Equal(t, []byte{'a', 0xFF, 'b', 'c', 'd'}, b[:n])
+	require.Equal(t, []int{3}, marks)
+
+	// the escape split across reads is carried over
+	b = []byte{0x00, 0x00, 'e'}
+	n = d.decode(b, mark)
+	require.Equal(t, []byte{0x00, 'e'}, b[:n])
+	require.Equal(t, []int{3, 0}, marks)
+}
+
+func TestBreakQueue(t *testing.T) {
+	var q breakQueue
+
+	q.push([]byte{'a', 'b', 0, 'c', 0}, []int{2, 4})
+	require.Equal(t, 3, q.len())
+
+	buf := make([]byte, 8)
+	n, err := q.pop(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ab", string(buf[:n]))
+
+	_, err = q.pop(buf)
+	require.Equal(t, ErrBreak, err)
+
+	n, err = q.pop(buf)
+	require.NoError(t, err)
+	require.Equal(t, "c", string(buf[:n]))
+
+	_, err = q.pop(buf)
+	require.Equal(t, ErrBreak, err)
+	require.True(t, q.empty())
+}
+
+func TestErrorReader(t *testing.T) {
+	var d markDecoder
+	raw := [][]byte{
+		{'a', 0xFF, 0xFF, 'b', 0xFF, 0x00, 'x', 'c', 0xFF},
+		{0x00, 0x00, 'd', 0xFF, 0x00},
+		{0x00},
+	}
+
+	r := &errorReader{read: func(b []byte, mark func(int, byte)) (int, error) {
+		if len(raw) == 0 {
+			return 0, io.EOF
+		}
+		n := copy(b, raw[0])
+		raw = raw[1:]
+		return d.decode(b[:n], mark), nil
+	}}
+
+	buf := make([]byte, 16)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{'a', 0xFF, 'b'}, buf[:n])
+
+	_, err = r.Read(buf)
+	require.Equal(t, &ParityError{Offset: 3, Char: 'x'}, err)
+
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "c", string(buf[:n]))
+
+	_, err = r.Read(buf)
+	require.Equal(t, &FrameError{Offset: 5}, err)
+
+	n, err = r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "d", string(buf[:n]))
+
+	_, err = r.Read(buf)
+	require.Equal(t, &FrameError{Offset: 7}, err)
+
+	_, err = r.Read(buf)
+	require.Equal(t, io.EOF, err)
+}
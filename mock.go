@@ -0,0 +1,932 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MockPort is an in-memory Port for testing code that talks to serial
+// devices. Bytes passed to Inject are returned by Read, and bytes given
+// to Write are collected for inspection with Written. Deadlines, modem
+// status and errors can be controlled by the test.
+//
+// A MockPort is safe for concurrent use.
+type MockPort struct {
+	mu      sync.Mutex
+	c       Config
+	in      breakQueue
+	out     bytes.Buffer
+	status  uint
+	dtr     bool
+	rts     bool
+	breaks  int
+	breakOn bool
+	errs    ErrorCounts
+	ic      InterruptCounts
+	fifo    int
+	readErr error
+	wrErr   error
+	closed  bool
+	// intr is set by ReadContext to interrupt a pending read, and cancel
+	// by CancelRead until a read takes it.
+	intr   bool
+	cancel bool
+	// changed is closed and replaced whenever input, status or the
+	// closed state changes, waking blocked readers.
+	changed chan struct{}
+	la      lookahead
+	stats   counters
+	// readAt and writeAt are the deadlines set by SetReadDeadlineTime and
+	// SetWriteDeadlineTime.
+	readAt, writeAt time.Time
+}
+
+var _ Port = (*MockPort)(nil)
+
+// NewMockPort returns an open MockPort configured with the defaults of
+// OpenPort at 9600 baud.
+func NewMockPort() *MockPort {
+	m := &MockPort{
+		c: Config{
+			Name:         "mock",
+			Baud:         9600,
+			Size:         DefaultSize,
+			Parity:       ParityNone,
+			StopBits:     Stop1,
+			XonChar:      DefaultXonChar,
+			XoffChar:     DefaultXoffChar,
+			ReadTimeout:  MaxTimeout,
+			writeTimeout: MaxTimeout,
+		},
+		changed: make(chan struct{}),
+	}
+	m.stats.reset()
+
+	return m
+}
+
+// notify wakes the goroutines waiting for a change. m.mu must be held.
+func (m *MockPort) notify() {
+	close(m.changed)
+	m.changed = make(chan struct{})
+}
+
+// Inject queues b to be returned by subsequent reads.
+func (m *MockPort) Inject(b []byte) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.in.push(b, nil)
+	m.notify()
+}
+
+// InjectBreak queues a break condition after the injected data, to be
+// returned by Read as ErrBreak.
+func (m *MockPort) InjectBreak() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.in.push([]byte{0}, []int{0})
+	m.notify()
+}
+
+// Written returns a copy of all bytes written to the port so far.
+func (m *MockPort) Written() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]byte(nil), m.out.Bytes()...)
+}
+
+// SetStatus sets the modem lines reported by Status as a mask of Status*
+// constants.
+func (m *MockPort) SetStatus(status uint) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.status = status
+	m.notify()
+}
+
+// SetErrorCounts sets the counts reported by ErrorCounts.
+func (m *MockPort) SetErrorCounts(c ErrorCounts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errs = c
+}
+
+// SetInterruptCounts sets the counts reported by InterruptCounts.
+func (m *MockPort) SetInterruptCounts(c InterruptCounts) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.ic = c
+}
+
+// FailRead makes the next read return err instead of data.
+func (m *MockPort) FailRead(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.readErr = err
+	m.notify()
+}
+
+// FailWrite makes the next write return err without writing anything.
+func (m *MockPort) FailWrite(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.wrErr = err
+}
+
+// DTR and RTS report the levels last set by SetDTR and SetRTS.
+func (m *MockPort) DTR() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.dtr
+}
+
+func (m *MockPort) RTS() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rts
+}
+
+// Breaks returns the number of times SendBreak was called.
+func (m *MockPort) Breaks() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.breaks
+}
+
+// BreakOn reports whether the break condition was left asserted with
+// SetBreak.
+func (m *MockPort) BreakOn() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.breakOn
+}
+
+func (m *MockPort) Read(b []byte) (int, error) {
+	return m.read(b, m.readDeadline())
+}
+
+// ReadFull reads exactly len(b) bytes. The read deadline bounds the
+// whole call rather than each underlying read.
+func (m *MockPort) ReadFull(b []byte) (int, error) {
+	return readFull(m, b)
+}
+
+// ReadAtLeast reads into b until at least min bytes have arrived, like
+// Port.ReadAtLeast.
+func (m *MockPort) ReadAtLeast(b []byte, min int) (int, error) {
+	return readAtLeast(m, b, min)
+}
+
+// ReadUntil reads until the first occurrence of delim and returns the
+// data up to and including it.
+func (m *MockPort) ReadUntil(delim byte) ([]byte, error) {
+	return m.la.until(delim, m.readDeadline(), m.readRaw)
+}
+
+// ReadChan delivers the injected data on a channel like Port.ReadChan.
+// The channels are closed once the port is closed and the injected data
+// is consumed.
+func (m *MockPort) ReadChan(bufSize int) (<-chan []byte, <-chan error) {
+	return readChan(m, bufSize)
+}
+
+// ReadChanPool is ReadChan reading into buffers from pool, like
+// Port.ReadChanPool.
+func (m *MockPort) ReadChanPool(pool *BufferPool) (<-chan []byte, <-chan error) {
+	return readChanFrom(m, pool.Get)
+}
+
+// ReadTimeout reads like Read, but waits at most d instead of the read
+// deadline, which is left untouched. Zero or MaxTimeout waits forever.
+func (m *MockPort) ReadTimeout(b []byte, d time.Duration) (int, error) {
+	return m.read(b, deadlineAfter(d))
+}
+
+// CancelRead makes a pending read, or else the next one that would wait,
+// return ErrReadCanceled like Port.CancelRead.
+func (m *MockPort) CancelRead() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.cancel = true
+	m.notify()
+
+	return nil
+}
+
+// ReadContext reads into b like Read, but returns ctx.Err() as soon as
+// ctx is canceled or its deadline passes.
+func (m *MockPort) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := readContext(ctx, m, b, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+
+		m.intr = true
+		m.notify()
+	})
+
+	m.mu.Lock()
+	m.intr = false
+	m.mu.Unlock()
+
+	return n, err
+}
+
+func (m *MockPort) readDeadline() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return deadlineFor(m.readAt, m.c.ReadTimeout)
+}
+
+func (m *MockPort) read(b []byte, deadline time.Time) (int, error) {
+	if n := m.la.take(b); n > 0 {
+		return n, nil
+	}
+
+	return m.readRaw(b, deadline)
+}
+
+// readRaw waits until injected data, an error or the deadline and reads
+// the available data into b.
+func (m *MockPort) readRaw(b []byte, deadline time.Time) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for {
+		switch {
+		case m.readErr != nil:
+			err := m.readErr
+			m.readErr = nil
+			return 0, err
+		case !m.in.empty():
+			n, err := m.in.pop(b)
+			m.stats.read(n)
+			return n, err
+		case m.closed:
+			return 0, io.EOF
+		case m.cancel:
+			m.cancel = false
+			return 0, ErrReadCanceled
+		case m.intr:
+			return 0, errInterrupted
+		}
+
+		if err := m.wait(deadline); err != nil {
+			return 0, err
+		}
+	}
+}
+
+// wait releases m.mu until the next change or the deadline passes. A
+// zero deadline waits forever.
+func (m *MockPort) wait(deadline time.Time) error {
+	changed := m.changed
+	m.mu.Unlock()
+	defer m.mu.Lock()
+
+	if deadline.IsZero() {
+		<-changed
+		return nil
+	}
+
+	t := time.NewTimer(time.Until(deadline))
+	defer t.Stop()
+
+	select {
+	case <-changed:
+		return nil
+	case <-t.C:
+		return ErrTimeout
+	}
+}
+
+// WriteString collects s for Written.
+func (m *MockPort) WriteString(s string) (int, error) {
+	return m.Write([]byte(s))
+}
+
+// WriteByte collects c for Written.
+func (m *MockPort) WriteByte(c byte) error {
+	_, err := m.Write([]byte{c})
+	return err
+}
+
+// WriteAll writes all of b like Write, failing with a *ShortWriteError
+// if FailWrite set an error.
+func (m *MockPort) WriteAll(b []byte) error {
+	return writeAll(b, m.Write)
+}
+
+// WriteProgress writes b like Write, in chunks, calling cb with the
+// number of bytes written so far after each chunk.
+func (m *MockPort) WriteProgress(b []byte, cb func(written int)) (int, error) {
+	return writeProgress(b, 0, cb, m.Write)
+}
+
+// Write collects b for Written. It never blocks, so the write deadline
+// has no effect.
+func (m *MockPort) Write(b []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch {
+	case m.wrErr != nil:
+		err := m.wrErr
+		m.wrErr = nil
+		return 0, err
+	case m.closed:
+		return 0, os.ErrClosed
+	}
+
+	m.stats.wrote(len(b))
+
+	return m.out.Write(b)
+}
+
+// Close makes pending and later reads return io.EOF once the injected
+// data is consumed.
+func (m *MockPort) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = true
+	m.notify()
+
+	return nil
+}
+
+// Name returns Config.Name, "mock" unless changed.
+func (m *MockPort) Name() string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.c.Name
+}
+
+// WaitForDCD waits for SetStatus to assert StatusDCD like
+// Port.WaitForDCD.
+func (m *MockPort) WaitForDCD(timeout time.Duration) error {
+	return waitForDCD(m, timeout)
+}
+
+// Loopback checks that pattern is read back like Port.Loopback. Written
+// data is not looped back by itself; the test has to Inject it.
+func (m *MockPort) Loopback(pattern []byte, timeout time.Duration) error {
+	return loopback(m, pattern, timeout)
+}
+
+// CloseGraceful closes the port; written data is never pending.
+func (m *MockPort) CloseGraceful(time.Duration) error {
+	return m.Close()
+}
+
+// Reopen reopens a closed port, discarding unread input.
+func (m *MockPort) Reopen() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.closed = false
+	m.in.reset()
+	m.la.reset()
+	m.notify()
+
+	return nil
+}
+
+// Fd returns an invalid descriptor, as no operating system resource
+// backs the port.
+func (m *MockPort) Fd() uintptr {
+	return ^uintptr(0)
+}
+
+// SyscallConn fails with ErrNotSupported, as there is no descriptor.
+func (m *MockPort) SyscallConn() (syscall.RawConn, error) {
+	return nil, ErrNotSupported
+}
+
+func (m *MockPort) SetReadDeadline(t time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.ReadTimeout, m.readAt = t, time.Time{}
+
+	return nil
+}
+
+func (m *MockPort) SetWriteDeadline(t time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.writeTimeout, m.writeAt = t, time.Time{}
+
+	return nil
+}
+
+// SetReadDeadlineTime sets an absolute deadline for all subsequent
+// reads; the zero time removes it.
+func (m *MockPort) SetReadDeadlineTime(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.ReadTimeout, m.readAt = 0, t
+
+	return nil
+}
+
+// SetWriteDeadlineTime records the deadline, which has no effect as
+// writes never block.
+func (m *MockPort) SetWriteDeadlineTime(t time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.writeTimeout, m.writeAt = 0, t
+
+	return nil
+}
+
+// Flush and FlushInput discard injected data not yet read. Written data
+// is never pending, so FlushOutput and Drain do nothing.
+func (m *MockPort) Flush() error {
+	return m.FlushInput()
+}
+
+func (m *MockPort) FlushInput() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.in.reset()
+	m.la.reset()
+
+	return nil
+}
+
+func (m *MockPort) FlushOutput() error {
+	return nil
+}
+
+// FlushInputN discards the injected bytes not yet read and returns how
+// many there were.
+func (m *MockPort) FlushInputN() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := m.in.len() + m.la.len()
+	m.in.reset()
+	m.la.reset()
+
+	return n, nil
+}
+
+// FlushOutputN returns 0, written data is never pending.
+func (m *MockPort) FlushOutputN() (int, error) {
+	return 0, nil
+}
+
+func (m *MockPort) Drain() error {
+	return nil
+}
+
+// Sync does nothing, like Drain.
+func (m *MockPort) Sync() error {
+	return nil
+}
+
+// Available returns the number of injected bytes not yet read.
+func (m *MockPort) Available() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.in.len() + m.la.len(), nil
+}
+
+// Status returns the mask set by SetStatus.
+func (m *MockPort) Status() (uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.status, nil
+}
+
+// ModemStatus combines the status set by SetStatus with the levels last
+// set by SetDTR and SetRTS.
+func (m *MockPort) ModemStatus() (ModemStatus, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return ModemStatus{
+		CTS: m.status&StatusCTS != 0,
+		DSR: m.status&StatusDSR != 0,
+		DCD: m.status&StatusDCD != 0,
+		RI:  m.status&StatusRI != 0,
+		DTR: m.dtr,
+		RTS: m.rts,
+	}, nil
+}
+
+func (m *MockPort) CTS() (bool, error) {
+	return m.modemLine(StatusCTS)
+}
+
+func (m *MockPort) DSR() (bool, error) {
+	return m.modemLine(StatusDSR)
+}
+
+func (m *MockPort) DCD() (bool, error) {
+	return m.modemLine(StatusDCD)
+}
+
+func (m *MockPort) RI() (bool, error) {
+	return m.modemLine(StatusRI)
+}
+
+func (m *MockPort) modemLine(mask uint) (bool, error) {
+	status, err := m.Status()
+	return status&mask != 0, err
+}
+
+// WaitForStatusChange blocks until SetStatus changes one of the given
+// lines or the timeout passes, and returns the new status. A zero
+// timeout waits forever.
+func (m *MockPort) WaitForStatusChange(lines uint, timeout time.Duration) (uint, error) {
+	deadline := deadlineAfter(timeout)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.status
+	for (m.status^status)&lines == 0 {
+		if m.closed {
+			return m.status, os.ErrClosed
+		}
+
+		if err := m.wait(deadline); err != nil {
+			return m.status, err
+		}
+	}
+
+	return m.status, nil
+}
+
+func (m *MockPort) SetDTR(assert bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dtr = assert
+
+	return nil
+}
+
+func (m *MockPort) SetRTS(assert bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.rts = assert
+
+	return nil
+}
+
+// PulseDTR deasserts DTR for the given time and restores it.
+func (m *MockPort) PulseDTR(low time.Duration) error {
+	return m.pulse(&m.dtr, low)
+}
+
+// PulseRTS deasserts RTS for the given time and restores it.
+func (m *MockPort) PulseRTS(low time.Duration) error {
+	return m.pulse(&m.rts, low)
+}
+
+// pulse deasserts the line for low. The lock is not held meanwhile, so
+// that tests can observe the line from another goroutine.
+func (m *MockPort) pulse(line *bool, low time.Duration) error {
+	m.mu.Lock()
+	prev := *line
+	*line = false
+	m.mu.Unlock()
+
+	time.Sleep(low)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	*line = prev
+
+	return nil
+}
+
+func (m *MockPort) SetDTRRTS(dtr, rts bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.dtr, m.rts = dtr, rts
+
+	return nil
+}
+
+// GetModemControl combines the status set by SetStatus with StatusDTR and
+// StatusRTS for the levels last set.
+func (m *MockPort) GetModemControl() (uint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.status
+	if m.dtr {
+		status |= StatusDTR
+	}
+	if m.rts {
+		status |= StatusRTS
+	}
+
+	return status, nil
+}
+
+// SetModemControl sets DTR and RTS if in mask; other lines fail with
+// ErrNotSupported, as on Windows.
+func (m *MockPort) SetModemControl(bits, mask uint) error {
+	if mask&^(StatusDTR|StatusRTS) != 0 {
+		return ErrNotSupported
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if mask&StatusDTR != 0 {
+		m.dtr = bits&StatusDTR != 0
+	}
+	if mask&StatusRTS != 0 {
+		m.rts = bits&StatusRTS != 0
+	}
+
+	return nil
+}
+
+func (m *MockPort) SetParity(val Parity) error {
+	switch val {
+	case ParityNone, ParityOdd, ParityEven, ParityMark, ParitySpace:
+	default:
+		return ErrBadParity
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.Parity = val
+
+	return nil
+}
+
+func (m *MockPort) SetBaud(baud int) error {
+	if baud <= 0 {
+		return fmt.Errorf("%w: baud rate %v", ErrInvalidArg, baud)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.Baud = baud
+
+	return nil
+}
+
+func (m *MockPort) SetStopBits(val StopBits) error {
+	switch val {
+	case Stop1, Stop1Half, Stop2:
+	default:
+		return ErrBadStopBits
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkStopBits(m.c.Size, val); err != nil {
+		return err
+	}
+
+	m.c.StopBits = val
+
+	return nil
+}
+
+func (m *MockPort) SetSize(val DataSize) error {
+	if val < 5 || val > 8 {
+		return ErrBadSize
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := checkStopBits(val, m.c.StopBits); err != nil {
+		return err
+	}
+
+	m.c.Size = val
+
+	return nil
+}
+
+// Reconfigure validates c and takes over its baud rate, framing and
+// flow control settings.
+func (m *MockPort) Reconfigure(c Config) error {
+	c.setDefaults()
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.Baud, m.c.Size, m.c.Parity, m.c.StopBits = c.Baud, c.Size, c.Parity, c.StopBits
+	m.c.FlowControl, m.c.XonChar, m.c.XoffChar = c.FlowControl, c.XonChar, c.XoffChar
+
+	return nil
+}
+
+func (m *MockPort) FlowControl() (FlowControl, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.c.FlowControl, nil
+}
+
+// ActualBaud returns the configured rate, which a mock always achieves.
+func (m *MockPort) ActualBaud() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.c.Baud, nil
+}
+
+// GetConfig returns the settings last applied to the port.
+func (m *MockPort) GetConfig() (Config, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.c, nil
+}
+
+// SendXON and SendXOFF write the configured flow control character.
+func (m *MockPort) SendXON() error {
+	m.mu.Lock()
+	c := m.c.XonChar
+	m.mu.Unlock()
+
+	_, err := m.Write([]byte{c})
+	return err
+}
+
+func (m *MockPort) SendXOFF() error {
+	m.mu.Lock()
+	c := m.c.XoffChar
+	m.mu.Unlock()
+
+	_, err := m.Write([]byte{c})
+	return err
+}
+
+// WriteWithAddress collects addr followed by data for Written; the
+// parity the bytes would have been sent with is not recorded.
+func (m *MockPort) WriteWithAddress(addr byte, data []byte) error {
+	_, err := m.Write(append([]byte{addr}, data...))
+	return err
+}
+
+// SetBreak records the break state, see BreakOn.
+func (m *MockPort) SetBreak(on bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.breakOn = on
+
+	return nil
+}
+
+// SendBreak only counts the call, see Breaks.
+func (m *MockPort) SendBreak(time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.breaks++
+
+	return nil
+}
+
+func (m *MockPort) SetRS485(cfg RS485Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.RS485 = cfg
+
+	return nil
+}
+
+func (m *MockPort) SetLowLatency(on bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.LowLatency = on
+
+	return nil
+}
+
+// SetFIFOTrigger records the level, returned by FIFOTrigger.
+func (m *MockPort) SetFIFOTrigger(level int) error {
+	if level <= 0 {
+		return fmt.Errorf("%w: FIFO trigger level %d", ErrInvalidArg, level)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fifo = level
+
+	return nil
+}
+
+// FIFOTrigger returns the level set by SetFIFOTrigger, or
+// ErrNotSupported if none was set.
+func (m *MockPort) FIFOTrigger() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.fifo == 0 {
+		return 0, ErrNotSupported
+	}
+
+	return m.fifo, nil
+}
+
+func (m *MockPort) SetBufferSizes(rx, tx int) error {
+	if rx < 0 || tx < 0 {
+		return ErrInvalidArg
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.c.RxBufferSize, m.c.TxBufferSize = rx, tx
+
+	return nil
+}
+
+// Stats counts the bytes read and written through the port.
+func (m *MockPort) Stats() Stats {
+	return m.stats.get()
+}
+
+// ResetStats restarts the counts returned by Stats from zero.
+func (m *MockPort) ResetStats() {
+	m.stats.reset()
+}
+
+func (m *MockPort) ResetErrorCounts() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.errs = ErrorCounts{}
+
+	return nil
+}
+
+// ErrorReader returns the port itself, as the mock receives no characters
+// in error.
+func (m *MockPort) ErrorReader() io.Reader {
+	return m
+}
+
+// InterruptCounts returns the counts set by SetInterruptCounts.
+func (m *MockPort) InterruptCounts() (InterruptCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.ic, nil
+}
+
+// ErrorCounts returns the counts set by SetErrorCounts.
+func (m *MockPort) ErrorCounts() (ErrorCounts, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.errs, nil
+}
@@ -0,0 +1,312 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMockPort(t *testing.T) {
+	m := NewMockPort()
+	require.Equal(t, "mock", m.Name())
+
+	_, err := m.Write([]byte("AT\r"))
+	require.NoError(t, err)
+	require.Equal(t, "AT\r", string(m.Written()))
+
+	m.Inject([]byte("OK\r\nrest"))
+	line, err := m.ReadUntil('\n')
+	require.NoError(t, err)
+	require.Equal(t, "OK\r\n", string(line))
+
+	n, err := m.Available()
+	require.NoError(t, err)
+	require.Equal(t, 4, n)
+
+	buf := make([]byte, 4)
+	_, err = m.ReadFull(buf)
+	require.NoError(t, err)
+	require.Equal(t, "rest", string(buf))
+
+	require.NoError(t, m.SetReadDeadline(20*time.Millisecond))
+	_, err = m.Read(buf)
+	require.Equal(t, ErrTimeout, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	require.NoError(t, m.SetReadDeadline(MaxTimeout))
+	_, err = m.ReadContext(ctx, buf)
+	require.Equal(t, context.Canceled, err)
+
+	fail := errors.New("line noise")
+	m.FailRead(fail)
+	_, err = m.Read(buf)
+	require.Equal(t, fail, err)
+
+	m.FailWrite(fail)
+	_, err = m.Write([]byte("x"))
+	require.Equal(t, fail, err)
+	require.Equal(t, "AT\r", string(m.Written()))
+
+	require.NoError(t, m.Close())
+	_, err = m.Read(buf)
+	require.Equal(t, io.EOF, err)
+}
+
+func TestMockPortStatus(t *testing.T) {
+	m := NewMockPort()
+
+	m.SetStatus(StatusCTS)
+	cts, err := m.CTS()
+	require.NoError(t, err)
+	require.True(t, cts)
+
+	time.AfterFunc(10*time.Millisecond, func() {
+		m.SetStatus(StatusCTS | StatusDSR)
+		m.SetStatus(StatusCTS | StatusDSR | StatusDCD)
+	})
+
+	status, err := m.WaitForStatusChange(StatusDCD, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, StatusCTS|StatusDSR|StatusDCD, status)
+
+	_, err = m.WaitForStatusChange(StatusRI, 10*time.Millisecond)
+	require.Equal(t, ErrTimeout, err)
+
+	m.SetErrorCounts(ErrorCounts{BufferOverrun: 3})
+	counts, err := m.ErrorCounts()
+	require.NoError(t, err)
+	require.Equal(t, uint(3), counts.BufferOverrun)
+	require.NoError(t, m.ResetErrorCounts())
+	counts, err = m.ErrorCounts()
+	require.NoError(t, err)
+	require.Zero(t, counts)
+
+	m.SetInterruptCounts(InterruptCounts{DCD: 2, Rx: 100})
+	ic, err := m.InterruptCounts()
+	require.NoError(t, err)
+	require.Equal(t, InterruptCounts{DCD: 2, Rx: 100}, ic)
+
+	require.NoError(t, m.SetDTRRTS(true, true))
+	require.True(t, m.DTR())
+	require.True(t, m.RTS())
+
+	ms, err := m.ModemStatus()
+	require.NoError(t, err)
+	require.Equal(t, ModemStatus{CTS: true, DSR: true, DCD: true, DTR: true, RTS: true}, ms)
+
+	low := make(chan bool)
+	time.AfterFunc(10*time.Millisecond, func() {
+		low <- !m.DTR()
+	})
+	require.NoError(t, m.PulseDTR(50*time.Millisecond))
+	require.True(t, <-low)
+	require.True(t, m.DTR())
+}
+
+func TestMockPortBreak(t *testing.T) {
+	m := NewMockPort()
+
+	m.Inject([]byte("frame"))
+	m.InjectBreak()
+	m.Inject([]byte("next"))
+
+	buf := make([]byte, 16)
+	n, err := m.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "frame", string(buf[:n]))
+
+	_, err = m.Read(buf)
+	require.Equal(t, ErrBreak, err)
+
+	n, err = m.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "next", string(buf[:n]))
+}
+
+func TestMockPortReadChan(t *testing.T) {
+	m := NewMockPort()
+	data, errc := m.ReadChan(4)
+
+	m.Inject([]byte("abcdef"))
+	require.Equal(t, []byte("abcd"), <-data)
+	require.Equal(t, []byte("ef"), <-data)
+
+	m.FailRead(io.ErrUnexpectedEOF)
+	require.Equal(t, io.ErrUnexpectedEOF, <-errc)
+	_, ok := <-data
+	require.False(t, ok)
+
+	data, errc = m.ReadChan(0)
+	require.NoError(t, m.Close())
+	_, ok = <-data
+	require.False(t, ok)
+	require.NoError(t, <-errc)
+}
+
+func TestMockPortReadChanPool(t *testing.T) {
+	m := NewMockPort()
+	pool := NewBufferPool(4, 2)
+	data, _ := m.ReadChanPool(pool)
+
+	m.Inject([]byte("abcdefgh"))
+	b1 := <-data
+	require.Equal(t, []byte("abcd"), b1)
+	pool.Release(b1)
+	require.Equal(t, []byte("efgh"), <-data)
+
+	// the next read reuses the released buffer
+	m.Inject([]byte("ij"))
+	b3 := <-data
+	require.Equal(t, []byte("ij"), b3)
+	require.Equal(t, &b1[0], &b3[0])
+
+	pool.Release(make([]byte, 8))
+	require.Len(t, pool.Get(), 4)
+	require.NoError(t, m.Close())
+
+	// a pool keeping nothing still hands out buffers
+	pool = NewBufferPool(4, -1)
+	b := pool.Get()
+	pool.Release(b)
+	require.Len(t, pool.Get(), 4)
+}
+
+func TestMockPortCancelRead(t *testing.T) {
+	m := NewMockPort()
+
+	time.AfterFunc(10*time.Millisecond, func() { _ = m.CancelRead() })
+	_, err := m.Read(make([]byte, 4))
+	require.Equal(t, ErrReadCanceled, err)
+
+	m.Inject([]byte("ab"))
+	n, err := m.Read(make([]byte, 4))
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+}
+
+func TestMockPortSetBreak(t *testing.T) {
+	m := NewMockPort()
+
+	require.NoError(t, m.SetBreak(true))
+	require.True(t, m.BreakOn())
+	require.NoError(t, m.SetBreak(false))
+	require.False(t, m.BreakOn())
+}
+
+func TestMockPortModemControl(t *testing.T) {
+	m := NewMockPort()
+	m.SetStatus(StatusCTS)
+
+	require.NoError(t, m.SetModemControl(StatusDTR|StatusRTS, StatusDTR|StatusRTS))
+	bits, err := m.GetModemControl()
+	require.NoError(t, err)
+	require.Equal(t, StatusCTS|StatusDTR|StatusRTS, bits)
+
+	// lines outside the mask are left alone
+	require.NoError(t, m.SetModemControl(0, StatusRTS))
+	require.True(t, m.DTR())
+	require.False(t, m.RTS())
+
+	require.Equal(t, ErrNotSupported, m.SetModemControl(StatusCTS, StatusCTS))
+}
+
+func TestMockPortWriteProgress(t *testing.T) {
+	m := NewMockPort()
+
+	var progress []int
+	b := make([]byte, 2*writeChunk+100)
+	n, err := m.WriteProgress(b, func(written int) {
+		progress = append(progress, written)
+	})
+	require.NoError(t, err)
+	require.Equal(t, len(b), n)
+	require.Equal(t, []int{writeChunk, 2 * writeChunk, len(b)}, progress)
+	require.Equal(t, b, m.Written())
+}
+
+func TestWriteAll(t *testing.T) {
+	m := NewMockPort()
+	require.NoError(t, m.WriteAll([]byte("frame")))
+	require.Equal(t, []byte("frame"), m.Written())
+
+	// short writes are carried on until the deadline passes
+	var calls int
+	err := writeAll([]byte("abcdef"), func(b []byte) (int, error) {
+		if calls++; calls == 3 {
+			return 1, ErrTimeout
+		}
+		return 2, nil
+	})
+	var short *ShortWriteError
+	require.True(t, errors.As(err, &short), "got %v", err)
+	require.Equal(t, ShortWriteError{Written: 5, Remaining: 1, Err: ErrTimeout}, *short)
+	require.True(t, errors.Is(err, ErrTimeout))
+
+	err = writeAll([]byte("ab"), func(b []byte) (int, error) { return 0, nil })
+	require.True(t, errors.Is(err, io.ErrShortWrite), "got %v", err)
+}
+
+func TestMockPortStats(t *testing.T) {
+	m := NewMockPort()
+
+	m.Inject([]byte("hello"))
+	_, err := m.Read(make([]byte, 8))
+	require.NoError(t, err)
+	_, err = m.Write([]byte("hi"))
+	require.NoError(t, err)
+
+	s := m.Stats()
+	require.Equal(t, uint64(5), s.BytesRead)
+	require.Equal(t, uint64(2), s.BytesWritten)
+	require.False(t, s.LastRead.IsZero())
+	require.False(t, s.Since.After(s.LastWrite))
+
+	m.ResetStats()
+	require.Zero(t, m.Stats().BytesRead)
+}
+
+func TestMockPortFlushInputN(t *testing.T) {
+	m := NewMockPort()
+
+	m.Inject([]byte("noise"))
+	n, err := m.FlushInputN()
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+
+	n, err = m.Available()
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestMockPortLoopback(t *testing.T) {
+	m := NewMockPort()
+
+	err := m.Loopback([]byte("ping"), 20*time.Millisecond)
+	require.True(t, errors.Is(err, ErrTimeout), "got %v", err)
+}
+
+func TestMockPortWaitForDCD(t *testing.T) {
+	m := NewMockPort()
+
+	require.Equal(t, ErrTimeout, m.WaitForDCD(10*time.Millisecond))
+
+	time.AfterFunc(10*time.Millisecond, func() {
+		m.SetStatus(StatusDSR)
+		m.SetStatus(StatusDSR | StatusDCD)
+	})
+	require.NoError(t, m.WaitForDCD(time.Second))
+}
+
+func TestWaitForDSR(t *testing.T) {
+	m := NewMockPort()
+
+	require.Equal(t, ErrTimeout, waitForDSR(m, time.Now().Add(15*time.Millisecond), 0))
+
+	time.AfterFunc(10*time.Millisecond, func() { m.SetStatus(StatusDSR) })
+	require.NoError(t, waitForDSR(m, time.Time{}, time.Millisecond))
+}
@@ -0,0 +1,89 @@
+package serial
+
+import "time"
+
+// DefaultBaud is the baud rate used by OpenPortWith unless WithBaud is
+// given.
+const DefaultBaud = 9600
+
+// Option changes a setting of the Config built by OpenPortWith.
+type Option func(*Config)
+
+// OpenPortWith opens the named port with the settings of OpenPort as
+// changed by opts. For example:
+//
+//    p, err := serial.OpenPortWith("/dev/ttyUSB0", serial.WithBaud(115200), serial.WithReadTimeout(time.Second))
+//
+func OpenPortWith(name string, opts ...Option) (Port, error) {
+	c := Config{Name: name, Baud: DefaultBaud}
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	return OpenPort(c)
+}
+
+// WithBaud sets the baud rate.
+func WithBaud(baud int) Option {
+	return func(c *Config) {
+		c.Baud = baud
+	}
+}
+
+// WithSize sets the number of data bits.
+func WithSize(size DataSize) Option {
+	return func(c *Config) {
+		c.Size = size
+	}
+}
+
+// WithParity sets the parity.
+func WithParity(parity Parity) Option {
+	return func(c *Config) {
+		c.Parity = parity
+	}
+}
+
+// WithStopBits sets the number of stop bits.
+func WithStopBits(stop StopBits) Option {
+	return func(c *Config) {
+		c.StopBits = stop
+	}
+}
+
+// WithFlowControl sets the flow control mode.
+func WithFlowControl(fc FlowControl) Option {
+	return func(c *Config) {
+		c.FlowControl = fc
+	}
+}
+
+// WithReadTimeout sets Config.ReadTimeout, the read timeout SetReadDeadline
+// later changes on the open port.
+func WithReadTimeout(t time.Duration) Option {
+	return func(c *Config) {
+		c.ReadTimeout = t
+	}
+}
+
+// WithWriteTimeout sets the write deadline, as SetWriteDeadline does on
+// the open port.
+func WithWriteTimeout(t time.Duration) Option {
+	return func(c *Config) {
+		c.writeTimeout = t
+	}
+}
+
+// WithExclusive opens the port for exclusive use, see Config.Exclusive.
+func WithExclusive() Option {
+	return func(c *Config) {
+		c.Exclusive = true
+	}
+}
+
+// WithRS485 enables RS-485 mode with the given settings.
+func WithRS485(cfg RS485Config) Option {
+	return func(c *Config) {
+		c.RS485 = cfg
+	}
+}
@@ -0,0 +1,72 @@
+// +build !windows
+
+package serial
+
+import (
+	"fmt"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// WaitReadable blocks until at least one of ports has data to read, or
+// the timeout passes, and returns the indices of the readable ports. A
+// single poll covers all of them, which scales better than a goroutine
+// per port. Ports that were hung up, such as an unplugged adapter, count
+// as readable so that the next Read reports the error. A zero or
+// MaxTimeout timeout waits as long as it takes, NoWait only checks.
+//
+// Only ports opened by OpenPort can be waited for; others, such as a
+// MockPort, make WaitReadable fail with ErrNotSupported.
+func WaitReadable(ports []Port, timeout time.Duration) ([]int, error) {
+	fds := make([]unix.PollFd, len(ports))
+	var ready []int
+
+	for i, port := range ports {
+		p, ok := port.(*impl)
+		if !ok {
+			return nil, fmt.Errorf("%w: waiting for a %T", ErrNotSupported, port)
+		}
+
+		fds[i] = unix.PollFd{Fd: int32(p.Fd()), Events: unix.POLLIN}
+
+		// data read ahead by the port is not seen by poll
+		if p.la.len() > 0 || !p.brk.empty() {
+			ready = append(ready, i)
+		}
+	}
+
+	if len(ready) > 0 {
+		return ready, nil
+	}
+
+	deadline := deadlineAfter(timeout)
+
+	for {
+		ms := -1
+		if !deadline.IsZero() {
+			ms = 0
+			if remaining := time.Until(deadline); remaining > 0 {
+				ms = int((remaining + time.Millisecond - 1) / time.Millisecond)
+			}
+		}
+
+		n, err := unix.Poll(fds, ms)
+		switch {
+		case err == unix.EINTR:
+			continue
+		case err != nil:
+			return nil, err
+		case n == 0:
+			return nil, ErrTimeout
+		}
+
+		for i, fd := range fds {
+			if fd.Revents != 0 {
+				ready = append(ready, i)
+			}
+		}
+
+		return ready, nil
+	}
+}
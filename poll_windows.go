@@ -0,0 +1,143 @@
+// +build windows
+
+package serial
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// maximumWaitObjects is the most handles WaitForMultipleObjects takes.
+const maximumWaitObjects = 64
+
+// commWait is a WaitCommEvent pending on one of the ports of
+// WaitReadable, or on the port of WaitForStatusChange.
+type commWait struct {
+	p      *impl
+	ov     *syscall.Overlapped
+	events uint32
+}
+
+// WaitReadable blocks until at least one of ports has data to read, or
+// the timeout passes, and returns the indices of the readable ports. A
+// single WaitForMultipleObjects covers all of them, which scales better
+// than a goroutine per port; up to 64 ports can be waited for. A zero or
+// MaxTimeout timeout waits as long as it takes, NoWait only checks.
+//
+// The wait uses the comm event of each port, so it must not run at the
+// same time as WaitForStatusChange on one of them. Only ports opened by
+// OpenPort can be waited for; others, such as a MockPort, make
+// WaitReadable fail with ErrNotSupported.
+func WaitReadable(ports []Port, timeout time.Duration) ([]int, error) {
+	if len(ports) > maximumWaitObjects {
+		return nil, fmt.Errorf("%w: more than %d ports", ErrInvalidArg, maximumWaitObjects)
+	}
+
+	waits := make([]commWait, len(ports))
+	for i, port := range ports {
+		p, ok := port.(*impl)
+		if !ok {
+			return nil, fmt.Errorf("%w: waiting for a %T", ErrNotSupported, port)
+		}
+		waits[i].p = p
+	}
+
+	handles := make([]syscall.Handle, 0, len(ports))
+	defer func() {
+		for i := range waits {
+			waits[i].cancel()
+		}
+	}()
+
+	var ready []int
+	for i := range waits {
+		w := &waits[i]
+
+		pending, err := w.start()
+		if err != nil {
+			return nil, err
+		}
+
+		// data that arrived before the wait started raises no event
+		n, err := w.p.Available()
+		if err != nil {
+			return nil, err
+		}
+
+		if !pending || n > 0 {
+			ready = append(ready, i)
+		}
+		handles = append(handles, w.ov.HEvent)
+	}
+
+	if len(ready) > 0 {
+		return ready, nil
+	}
+
+	ms := uint32(syscall.INFINITE)
+	if deadline := deadlineAfter(timeout); !deadline.IsZero() {
+		ms = 0
+		if remaining := time.Until(deadline); remaining > 0 {
+			ms = uint32((remaining + time.Millisecond - 1) / time.Millisecond)
+		}
+	}
+
+	r, _, err := syscall.Syscall6(nWaitForMultipleObjects, 4,
+		uintptr(len(handles)),
+		uintptr(unsafe.Pointer(&handles[0])),
+		0, // wait for any
+		uintptr(ms), 0, 0)
+	switch {
+	case r == syscall.WAIT_FAILED:
+		return nil, err
+	case r == syscall.WAIT_TIMEOUT:
+		return nil, ErrTimeout
+	}
+
+	for i, h := range handles {
+		if e, _ := syscall.WaitForSingleObject(h, 0); e == syscall.WAIT_OBJECT_0 {
+			ready = append(ready, i)
+		}
+	}
+
+	return ready, nil
+}
+
+// start issues the WaitCommEvent for the events of the port's comm mask,
+// which is set for received characters unless WaitForStatusChange runs.
+// It reports whether the wait is pending rather than done.
+func (w *commWait) start() (bool, error) {
+	ov, err := newOverlapped()
+	if err != nil {
+		return false, err
+	}
+	w.ov = ov
+
+	r, _, err := syscall.Syscall(nWaitCommEvent, 3, uintptr(w.p.fd),
+		uintptr(unsafe.Pointer(&w.events)), uintptr(unsafe.Pointer(w.ov)))
+	if r != 0 {
+		return false, nil
+	} else if err != syscall.ERROR_IO_PENDING {
+		// nothing to cancel
+		_ = syscall.CloseHandle(w.ov.HEvent)
+		w.ov = nil
+		return false, err
+	}
+
+	return true, nil
+}
+
+// cancel ends the wait if it is still pending and releases its event.
+func (w *commWait) cancel() {
+	if w.ov == nil {
+		return
+	}
+
+	// the overlapped I/O must be over before its memory is reused
+	_ = syscall.CancelIoEx(w.p.fd, w.ov)
+	_, _ = w.p.getOverlappedResult(w.p.fd, w.ov)
+	_ = syscall.CloseHandle(w.ov.HEvent)
+	w.ov = nil
+}
@@ -0,0 +1,51 @@
+//go:build freebsd || openbsd || netbsd || dragonfly
+// +build freebsd openbsd netbsd dragonfly
+
+package serial
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// portPatterns match the callout devices of serial ports: cuau0 and
+// cuaU0 (USB) on FreeBSD, cua00 and cuaU0 on OpenBSD, dty00 on NetBSD.
+var portPatterns = []string{"/dev/cua*", "/dev/dty*"}
+
+// ListPorts returns the device paths of the serial ports present on
+// the system. Only the callout devices are returned since the dial-in
+// (/dev/tty*) variants block on open until carrier is detected.
+func ListPorts() ([]string, error) {
+	var ports []string
+	for _, pattern := range portPatterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range matches {
+			// FreeBSD's cuau0.init and cuau0.lock hold the defaults
+			// and locked settings of the port rather than being ports
+			if !strings.Contains(filepath.Base(m), ".") {
+				ports = append(ports, m)
+			}
+		}
+	}
+
+	sort.Strings(ports)
+
+	return ports, nil
+}
+
+// ListPortsByID is not supported on the BSDs, which have no persistent
+// device names.
+func ListPortsByID() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// DetailedPorts is not supported on the BSDs, which describe USB devices
+// through usbconfig and sysctl rather than with the ports.
+func DetailedPorts() ([]PortInfo, error) {
+	return nil, ErrNotSupported
+}
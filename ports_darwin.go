@@ -0,0 +1,196 @@
+//go:build darwin
+// +build darwin
+
+package serial
+
+/*
+#cgo LDFLAGS: -framework IOKit -framework CoreFoundation
+#include <stdlib.h>
+#include <CoreFoundation/CoreFoundation.h>
+#include <IOKit/IOKitLib.h>
+#include <IOKit/serial/IOSerialKeys.h>
+
+// serialServices looks up the IOSerialBSDClient services, one per port.
+static kern_return_t serialServices(io_iterator_t *it) {
+	CFMutableDictionaryRef match = IOServiceMatching(kIOSerialBSDServiceValue);
+	if (match == NULL)
+		return KERN_FAILURE;
+	CFDictionarySetValue(match, CFSTR(kIOSerialBSDTypeKey), CFSTR(kIOSerialBSDAllTypes));
+	// consumes match
+	return IOServiceGetMatchingServices(MACH_PORT_NULL, match, it);
+}
+
+static kern_return_t parentEntry(io_registry_entry_t entry, io_registry_entry_t *parent) {
+	return IORegistryEntryGetParentEntry(entry, kIOServicePlane, parent);
+}
+
+static int isUSBDevice(io_registry_entry_t entry) {
+	return IOObjectConformsTo(entry, "IOUSBDevice") || IOObjectConformsTo(entry, "IOUSBHostDevice");
+}
+
+// regString copies the string property key of entry to buf, returning
+// 0 if it is not set or not a string.
+static int regString(io_registry_entry_t entry, const char *key, char *buf, int size) {
+	CFStringRef k = CFStringCreateWithCString(kCFAllocatorDefault, key, kCFStringEncodingUTF8);
+	CFTypeRef v = IORegistryEntryCreateCFProperty(entry, k, kCFAllocatorDefault, 0);
+	CFRelease(k);
+	if (v == NULL)
+		return 0;
+	int ok = CFGetTypeID(v) == CFStringGetTypeID() &&
+		CFStringGetCString((CFStringRef)v, buf, size, kCFStringEncodingUTF8);
+	CFRelease(v);
+	return ok;
+}
+
+// regNumber stores the number property key of entry in n, returning 0
+// if it is not set or not a number.
+static int regNumber(io_registry_entry_t entry, const char *key, int *n) {
+	CFStringRef k = CFStringCreateWithCString(kCFAllocatorDefault, key, kCFStringEncodingUTF8);
+	CFTypeRef v = IORegistryEntryCreateCFProperty(entry, k, kCFAllocatorDefault, 0);
+	CFRelease(k);
+	if (v == NULL)
+		return 0;
+	int ok = CFGetTypeID(v) == CFNumberGetTypeID() &&
+		CFNumberGetValue((CFNumberRef)v, kCFNumberIntType, n);
+	CFRelease(v);
+	return ok;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"unsafe"
+)
+
+// ListPorts returns the device paths of the serial ports present on
+// the system. Only the callout (/dev/cu.*) devices are returned since
+// the dial-in (/dev/tty.*) variants block on open until carrier is
+// detected.
+func ListPorts() ([]string, error) {
+	ports, err := filepath.Glob("/dev/cu.*")
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Strings(ports)
+
+	return ports, nil
+}
+
+// ListPortsByID is not supported on macOS, where the callout devices
+// returned by ListPorts are already named after the adapter.
+func ListPortsByID() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// DetailedPorts returns the serial ports found in the IORegistry, by
+// their callout devices as with ListPorts, with the USB vendor and
+// product IDs, serial number, manufacturer and product name of USB
+// adapters, and the driver of other ports.
+func DetailedPorts() ([]PortInfo, error) {
+	var it C.io_iterator_t
+	if kr := C.serialServices(&it); kr != C.KERN_SUCCESS {
+		return nil, fmt.Errorf("serial: IOServiceGetMatchingServices: %#x", int(kr))
+	}
+	defer C.IOObjectRelease(C.io_object_t(it))
+
+	var ports []PortInfo
+	for {
+		service := C.IOIteratorNext(it)
+		if service == 0 {
+			break
+		}
+
+		info, ok := portInfo(C.io_registry_entry_t(service))
+		C.IOObjectRelease(service)
+		if ok {
+			ports = append(ports, info)
+		}
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+
+	return ports, nil
+}
+
+// portInfo describes the port of the IOSerialBSDClient service, and of
+// the USB device above it if any. It returns false for a service without
+// a callout device.
+func portInfo(service C.io_registry_entry_t) (PortInfo, bool) {
+	var info PortInfo
+	if info.Name = regString(service, "IOCalloutDevice"); info.Name == "" {
+		return info, false
+	}
+
+	// the client hangs off the driver's serial stream nub, and the
+	// driver off the USB interface, whose parent is the device
+	entry := service
+	C.IOObjectRetain(C.io_object_t(entry))
+	for depth := 0; ; depth++ {
+		var parent C.io_registry_entry_t
+		kr := C.parentEntry(entry, &parent)
+		C.IOObjectRelease(C.io_object_t(entry))
+		if kr != C.KERN_SUCCESS {
+			break
+		}
+		entry = parent
+
+		if depth == 1 {
+			var class [128]C.char
+			if C.IOObjectGetClass(C.io_object_t(entry), &class[0]) == C.KERN_SUCCESS {
+				info.Description = C.GoString(&class[0])
+			}
+		}
+
+		if C.isUSBDevice(entry) == 0 {
+			continue
+		}
+
+		vid, vok := regNumber(entry, "idVendor")
+		pid, pok := regNumber(entry, "idProduct")
+		if vok && pok {
+			info.IsUSB = true
+			info.VID, info.PID = uint16(vid), uint16(pid)
+			info.SerialNumber = regString(entry, "USB Serial Number")
+			info.Manufacturer = regString(entry, "USB Vendor Name")
+			if product := regString(entry, "USB Product Name"); product != "" {
+				info.Description = product
+			}
+		}
+		C.IOObjectRelease(C.io_object_t(entry))
+		break
+	}
+
+	return info, true
+}
+
+// regString returns the string property key of entry, or "" if it is
+// not set.
+func regString(entry C.io_registry_entry_t, key string) string {
+	k := C.CString(key)
+	defer C.free(unsafe.Pointer(k))
+
+	var buf [256]C.char
+	if C.regString(entry, k, &buf[0], C.int(len(buf))) == 0 {
+		return ""
+	}
+
+	return C.GoString(&buf[0])
+}
+
+// regNumber returns the number property key of entry, and whether it is
+// set.
+func regNumber(entry C.io_registry_entry_t, key string) (int, bool) {
+	k := C.CString(key)
+	defer C.free(unsafe.Pointer(k))
+
+	var n C.int
+	if C.regNumber(entry, k, &n) == 0 {
+		return 0, false
+	}
+
+	return int(n), true
+}
@@ -0,0 +1,167 @@
+//go:build linux
+// +build linux
+
+package serial
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ttyPrefixes lists the device name prefixes of serial drivers.
+var ttyPrefixes = []string{
+	"ttyS",     // built-in UARTs
+	"ttyUSB",   // USB to serial converters
+	"ttyACM",   // USB CDC-ACM modems
+	"ttyAMA",   // ARM AMBA UARTs
+	"ttyXRUSB", // Exar USB UARTs
+	"ttyAP",    // Advantech UARTs
+	"ttyGS",    // USB gadget serial
+	"rfcomm",   // Bluetooth serial
+}
+
+// ListPorts returns the device paths of the serial ports present on
+// the system.
+func ListPorts() ([]string, error) {
+	entries, err := ioutil.ReadDir(sysClassTTY)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []string
+	for _, e := range entries {
+		name := e.Name()
+		if !hasTTYPrefix(name) {
+			continue
+		}
+
+		// Entries without a device are virtual consoles and the like
+		device := filepath.Join(sysClassTTY, name, "device")
+		if _, err := os.Stat(device); err != nil {
+			continue
+		}
+
+		// The 8250 driver registers placeholder ttyS ports on the
+		// platform bus whether or not hardware is present
+		if strings.HasPrefix(name, "ttyS") {
+			subsystem, err := os.Readlink(filepath.Join(device, "subsystem"))
+			if err == nil && filepath.Base(subsystem) == "platform" {
+				continue
+			}
+		}
+
+		ports = append(ports, filepath.Join("/dev", name))
+	}
+
+	sort.Strings(ports)
+
+	return ports, nil
+}
+
+// DetailedPorts returns the serial ports of ListPorts with what sysfs
+// tells about their devices: the USB vendor and product IDs, serial
+// number, manufacturer and product name of USB adapters, and the driver
+// of other ports.
+func DetailedPorts() ([]PortInfo, error) {
+	names, err := ListPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make([]PortInfo, 0, len(names))
+	for _, name := range names {
+		ports = append(ports, portInfo(name))
+	}
+
+	return ports, nil
+}
+
+// portInfo describes the port name from the sysfs attributes of its
+// device, and of the USB device above it if any.
+func portInfo(name string) PortInfo {
+	info := PortInfo{Name: name}
+
+	device, err := filepath.EvalSymlinks(filepath.Join(sysClassTTY, filepath.Base(name), "device"))
+	if err != nil {
+		return info
+	}
+
+	if driver, err := os.Readlink(filepath.Join(device, "driver")); err == nil {
+		info.Description = filepath.Base(driver)
+	}
+
+	// the tty hangs off a USB interface, whose parent is the device
+	for dir := device; dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		vid, err := strconv.ParseUint(sysfsAttr(dir, "idVendor"), 16, 16)
+		if err != nil {
+			continue
+		}
+		pid, err := strconv.ParseUint(sysfsAttr(dir, "idProduct"), 16, 16)
+		if err != nil {
+			continue
+		}
+
+		info.IsUSB = true
+		info.VID, info.PID = uint16(vid), uint16(pid)
+		info.SerialNumber = sysfsAttr(dir, "serial")
+		info.Manufacturer = sysfsAttr(dir, "manufacturer")
+		if product := sysfsAttr(dir, "product"); product != "" {
+			info.Description = product
+		}
+		break
+	}
+
+	return info
+}
+
+// sysfsAttr returns the value of the sysfs attribute name in dir, or ""
+// if it cannot be read.
+func sysfsAttr(dir, name string) string {
+	b, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// serialByID is where udev keeps links to USB serial ports named by the
+// adapter's vendor, product and serial number.
+const serialByID = "/dev/serial/by-id"
+
+// ListPortsByID returns the udev links in /dev/serial/by-id, which name
+// USB serial ports by adapter rather than by the order they were plugged
+// in and so stay the same across reboots. The links can be passed to
+// OpenPort as they are. None are returned if udev has not created the
+// directory, as happens while no USB serial adapter is present.
+func ListPortsByID() ([]string, error) {
+	entries, err := ioutil.ReadDir(serialByID)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	ports := make([]string, 0, len(entries))
+	for _, e := range entries {
+		ports = append(ports, filepath.Join(serialByID, e.Name()))
+	}
+
+	sort.Strings(ports)
+
+	return ports, nil
+}
+
+func hasTTYPrefix(name string) bool {
+	for _, prefix := range ttyPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,19 @@
+//go:build !linux && !darwin && !windows && !freebsd && !openbsd && !netbsd && !dragonfly
+// +build !linux,!darwin,!windows,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package serial
+
+// ListPorts is not supported on this platform.
+func ListPorts() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// ListPortsByID is not supported on this platform.
+func ListPortsByID() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// DetailedPorts is not supported on this platform.
+func DetailedPorts() ([]PortInfo, error) {
+	return nil, ErrNotSupported
+}
@@ -0,0 +1,222 @@
+//go:build windows
+// +build windows
+
+package serial
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// ListPorts returns the names of the COM ports present on the system.
+func ListPorts() ([]string, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`, registry.QUERY_VALUE)
+	if err == registry.ErrNotExist {
+		// the key only exists once a serial driver has been loaded
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+
+	names, err := k.ReadValueNames(0)
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make([]string, 0, len(names))
+	for _, name := range names {
+		port, _, err := k.GetStringValue(name)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, port)
+	}
+
+	sort.Strings(ports)
+
+	return ports, nil
+}
+
+// ListPortsByID is not supported on Windows, which has no stable links
+// to COM ports.
+func ListPortsByID() ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+// guidDevClassPorts is GUID_DEVCLASS_PORTS, the setup class of COM and
+// LPT ports.
+var guidDevClassPorts = syscall.GUID{
+	Data1: 0x4d36e978,
+	Data2: 0xe325,
+	Data3: 0x11ce,
+	Data4: [8]byte{0xbf, 0xc1, 0x08, 0x00, 0x2b, 0xe1, 0x03, 0x18},
+}
+
+var (
+	setupapi = syscall.NewLazyDLL("setupapi.dll")
+
+	procSetupDiGetClassDevs              = setupapi.NewProc("SetupDiGetClassDevsW")
+	procSetupDiDestroyDeviceInfoList     = setupapi.NewProc("SetupDiDestroyDeviceInfoList")
+	procSetupDiEnumDeviceInfo            = setupapi.NewProc("SetupDiEnumDeviceInfo")
+	procSetupDiGetDeviceRegistryProperty = setupapi.NewProc("SetupDiGetDeviceRegistryPropertyW")
+	procSetupDiGetDeviceInstanceId       = setupapi.NewProc("SetupDiGetDeviceInstanceIdW")
+	procSetupDiOpenDevRegKey             = setupapi.NewProc("SetupDiOpenDevRegKey")
+)
+
+const (
+	digcfPresent      = 0x02
+	dicsFlagGlobal    = 0x01
+	diregDev          = 0x01
+	spdrpMfg          = 0x0B
+	spdrpFriendlyName = 0x0C
+)
+
+// errorNoMoreItems ends the enumeration of SetupDiEnumDeviceInfo.
+const errorNoMoreItems syscall.Errno = 259
+
+// spDevinfoData is SP_DEVINFO_DATA.
+type spDevinfoData struct {
+	cbSize    uint32
+	classGUID syscall.GUID
+	devInst   uint32
+	reserved  uintptr
+}
+
+// DetailedPorts returns the COM ports known to the Plug and Play manager
+// with their friendly name as Description, and for USB adapters the
+// vendor and product IDs and the serial number from the device instance
+// ID. Serial numbers made up by Windows for adapters without one are left
+// out.
+func DetailedPorts() ([]PortInfo, error) {
+	if err := setupapi.Load(); err != nil {
+		return nil, err
+	}
+
+	h, _, err := procSetupDiGetClassDevs.Call(uintptr(unsafe.Pointer(&guidDevClassPorts)), 0, 0, digcfPresent)
+	if h == uintptr(syscall.InvalidHandle) {
+		return nil, err
+	}
+	defer procSetupDiDestroyDeviceInfoList.Call(h)
+
+	var ports []PortInfo
+	for i := 0; ; i++ {
+		data := spDevinfoData{}
+		data.cbSize = uint32(unsafe.Sizeof(data))
+		if r, _, err := procSetupDiEnumDeviceInfo.Call(h, uintptr(i), uintptr(unsafe.Pointer(&data))); r == 0 {
+			if err == errorNoMoreItems {
+				break
+			}
+			return nil, err
+		}
+
+		name := devicePortName(h, &data)
+		if !strings.HasPrefix(name, "COM") {
+			// printer ports share the class
+			continue
+		}
+
+		info := PortInfo{
+			Name:         name,
+			Description:  deviceProperty(h, &data, spdrpFriendlyName),
+			Manufacturer: deviceProperty(h, &data, spdrpMfg),
+		}
+		info.VID, info.PID, info.SerialNumber, info.IsUSB = parseInstanceID(deviceInstanceID(h, &data))
+
+		ports = append(ports, info)
+	}
+
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+
+	return ports, nil
+}
+
+// devicePortName returns the PortName value of the device's hardware key.
+func devicePortName(h uintptr, data *spDevinfoData) string {
+	key, _, _ := procSetupDiOpenDevRegKey.Call(h, uintptr(unsafe.Pointer(data)), dicsFlagGlobal, 0, diregDev, registry.QUERY_VALUE)
+	if key == uintptr(syscall.InvalidHandle) {
+		return ""
+	}
+
+	k := registry.Key(key)
+	defer k.Close()
+
+	name, _, err := k.GetStringValue("PortName")
+	if err != nil {
+		return ""
+	}
+
+	return name
+}
+
+// deviceProperty returns the string registry property prop of the
+// device, or "" if it has none.
+func deviceProperty(h uintptr, data *spDevinfoData, prop uintptr) string {
+	var buf [256]uint16
+	r, _, _ := procSetupDiGetDeviceRegistryProperty.Call(h, uintptr(unsafe.Pointer(data)), prop, 0,
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)*2), 0)
+	if r == 0 {
+		return ""
+	}
+
+	return syscall.UTF16ToString(buf[:])
+}
+
+// deviceInstanceID returns the device instance ID, such as
+// USB\VID_0403&PID_6001\A9XK3L2Q.
+func deviceInstanceID(h uintptr, data *spDevinfoData) string {
+	var buf [256]uint16
+	r, _, _ := procSetupDiGetDeviceInstanceId.Call(h, uintptr(unsafe.Pointer(data)),
+		uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf)), 0)
+	if r == 0 {
+		return ""
+	}
+
+	return syscall.UTF16ToString(buf[:])
+}
+
+// parseInstanceID decodes the USB IDs and serial number from a device
+// instance ID, reporting whether it is the ID of a USB device. Besides
+// the USB\VID_xxxx&PID_xxxx\serial IDs of the standard drivers, the
+// FTDIBUS\VID_xxxx+PID_xxxx+serialA\0000 IDs of the FTDI driver are
+// understood, which append the letter of the adapter's port.
+func parseInstanceID(id string) (vid, pid uint16, serial string, ok bool) {
+	parts := strings.Split(id, `\`)
+	if len(parts) != 3 {
+		return 0, 0, "", false
+	}
+
+	fields := strings.FieldsFunc(parts[1], func(r rune) bool { return r == '&' || r == '+' })
+	for _, f := range fields {
+		switch {
+		case strings.HasPrefix(f, "VID_"):
+			v, err := strconv.ParseUint(f[4:], 16, 16)
+			if err != nil {
+				return 0, 0, "", false
+			}
+			vid = uint16(v)
+			ok = true
+		case strings.HasPrefix(f, "PID_"):
+			v, err := strconv.ParseUint(f[4:], 16, 16)
+			if err != nil {
+				return 0, 0, "", false
+			}
+			pid = uint16(v)
+		}
+	}
+
+	switch {
+	case parts[0] == "USB" && !strings.Contains(parts[2], "&"):
+		// made up serial numbers contain '&'
+		serial = parts[2]
+	case parts[0] == "FTDIBUS" && len(fields) == 3 && len(fields[2]) > 1:
+		serial = fields[2][:len(fields[2])-1]
+	}
+
+	return vid, pid, serial, ok
+}
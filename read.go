@@ -0,0 +1,363 @@
+package serial
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// readChunk is the size of the reads used to fill the lookahead buffer.
+const readChunk = 256
+
+// deadlineReader is implemented by ports that can read against an
+// absolute deadline, so that several reads share a single budget.
+type deadlineReader interface {
+	readDeadline() time.Time
+	read(b []byte, deadline time.Time) (int, error)
+}
+
+// readFull reads until b is full or the read deadline of r passes. On a
+// shortfall it returns the partial count with ErrTimeout, or with
+// io.ErrUnexpectedEOF if the port hit end of file.
+func readFull(r deadlineReader, b []byte) (int, error) {
+	return readAtLeast(r, b, len(b))
+}
+
+// readAtLeast reads into b until it holds at least min bytes or the read
+// deadline of r passes, with the errors of readFull.
+func readAtLeast(r deadlineReader, b []byte, min int) (n int, err error) {
+	if min > len(b) {
+		return 0, fmt.Errorf("%w: minimum %d exceeds buffer of %d", ErrInvalidArg, min, len(b))
+	}
+
+	deadline := r.readDeadline()
+
+	for n < min && err == nil {
+		var nn int
+		nn, err = r.read(b[n:], deadline)
+		n += nn
+	}
+
+	switch {
+	case n >= min:
+		err = nil
+	case n > 0 && err == io.EOF:
+		err = io.ErrUnexpectedEOF
+	}
+
+	return
+}
+
+// errInterrupted is returned by a read interrupted on behalf of
+// readContext. It is replaced by the context error before reaching the
+// caller.
+var errInterrupted = errors.New("serial: read interrupted")
+
+// interruptInterval is how often readContext repeats the interrupt of a
+// canceled read, in case the read had not started waiting yet.
+const interruptInterval = 10 * time.Millisecond
+
+// readContext reads into b like r.read, but also returns once ctx is done.
+// The earlier of the read deadline and the context deadline applies.
+// interrupt is called from another goroutine, repeatedly, while ctx is done
+// and the read has not returned; it must make the pending read fail.
+func readContext(ctx context.Context, r deadlineReader, b []byte, interrupt func()) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	deadline := r.readDeadline()
+	ctxDeadline, ok := ctx.Deadline()
+	if ok && (deadline.IsZero() || ctxDeadline.Before(deadline)) {
+		deadline = ctxDeadline
+	} else {
+		ok = false
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		select {
+		case <-ctx.Done():
+		case <-stop:
+			return
+		}
+
+		t := time.NewTicker(interruptInterval)
+		defer t.Stop()
+
+		for {
+			interrupt()
+
+			select {
+			case <-t.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	n, err := r.read(b, deadline)
+	close(stop)
+	<-done
+
+	switch {
+	case err == nil:
+	case ok && err == ErrTimeout:
+		err = context.DeadlineExceeded
+	case ctx.Err() != nil:
+		err = ctx.Err()
+	}
+
+	return n, err
+}
+
+// earliest returns the earlier of two deadlines, where the zero time
+// means no deadline.
+func earliest(a, b time.Time) time.Time {
+	if a.IsZero() || (!b.IsZero() && b.Before(a)) {
+		return b
+	}
+
+	return a
+}
+
+// deadlineAfter converts a relative timeout into an absolute deadline.
+// Zero and MaxTimeout yield the zero time, meaning no deadline. A
+// negative timeout, NoWait, yields a deadline that has already passed,
+// so that only data available right away is transferred.
+func deadlineAfter(t time.Duration) time.Time {
+	switch {
+	case t == 0 || t == MaxTimeout:
+		return time.Time{}
+	case t < 0:
+		return time.Now()
+	case t < MinTimeout:
+		t = MinTimeout
+	}
+
+	return time.Now().Add(t)
+}
+
+// deadlineFor returns at, an absolute deadline set for every operation,
+// or the deadline for a timeout t starting now if at is zero.
+func deadlineFor(at time.Time, t time.Duration) time.Time {
+	if !at.IsZero() {
+		return at
+	}
+
+	return deadlineAfter(t)
+}
+
+// lookahead holds bytes read from the port ahead of the caller, such as
+// those past the delimiter found by ReadUntil. Reads are served from it
+// before touching the port again.
+type lookahead struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+// take moves buffered bytes into b and returns their count.
+func (l *lookahead) take(b []byte) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := copy(b, l.buf)
+	l.buf = l.buf[n:]
+	if len(l.buf) == 0 {
+		l.buf = nil
+	}
+
+	return n
+}
+
+// len returns the number of buffered bytes.
+func (l *lookahead) len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return len(l.buf)
+}
+
+// reset discards the buffered bytes.
+func (l *lookahead) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.buf = nil
+}
+
+// line moves the next line, up to and including '\n', into b, calling
+// fill until a whole line is buffered. A line longer than b is returned
+// in pieces. If fill fails first, the partial line stays buffered for the
+// next call and the error is returned.
+func (l *lookahead) line(b []byte, deadline time.Time, fill func([]byte, time.Time) (int, error)) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var chunk [readChunk]byte
+	var err error
+	scanned := 0
+
+	for {
+		i := bytes.IndexByte(l.buf[scanned:], '\n')
+		switch {
+		case i >= 0 && scanned+i+1 < len(b):
+			n := copy(b, l.buf[:scanned+i+1])
+			l.buf = l.buf[n:]
+			return n, nil
+		case i >= 0 || len(l.buf) >= len(b):
+			n := copy(b, l.buf)
+			l.buf = l.buf[n:]
+			return n, nil
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		scanned = len(l.buf)
+
+		var n int
+		n, err = fill(chunk[:], deadline)
+		l.buf = append(l.buf, chunk[:n]...)
+	}
+}
+
+// until returns the bytes up to and including delim, calling fill for
+// more data until delim arrives. If fill fails first, everything
+// buffered so far is returned along with the error.
+func (l *lookahead) until(delim byte, deadline time.Time, fill func([]byte, time.Time) (int, error)) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	var chunk [readChunk]byte
+	var err error
+	scanned := 0
+
+	for {
+		if i := bytes.IndexByte(l.buf[scanned:], delim); i >= 0 {
+			end := scanned + i + 1
+			line := append([]byte(nil), l.buf[:end]...)
+			l.buf = l.buf[end:]
+			return line, nil
+		}
+
+		if err != nil {
+			line := l.buf
+			l.buf = nil
+			return line, err
+		}
+
+		scanned = len(l.buf)
+
+		var n int
+		n, err = fill(chunk[:], deadline)
+		l.buf = append(l.buf, chunk[:n]...)
+	}
+}
+
+// readChan reads r from a goroutine, sending each chunk of up to bufSize
+// bytes on the data channel. The goroutine waits for every chunk to be
+// received before reading on, so input backs up in the driver rather
+// than in memory. Read timeouts are ignored; the first other error is
+// sent on the error channel, after which both channels are closed. A
+// port being closed closes them without an error.
+func readChan(r io.Reader, bufSize int) (<-chan []byte, <-chan error) {
+	if bufSize <= 0 {
+		bufSize = readChunk
+	}
+
+	return readChanFrom(r, func() []byte { return make([]byte, bufSize) })
+}
+
+// readChanFrom is readChan reading into the buffers returned by get.
+func readChanFrom(r io.Reader, get func() []byte) (<-chan []byte, <-chan error) {
+	data := make(chan []byte)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(data)
+		defer close(errc)
+
+		var b []byte
+		for {
+			if b == nil {
+				b = get()
+			}
+
+			n, err := r.Read(b)
+			if n > 0 {
+				data <- b[:n]
+				b = nil
+			}
+
+			switch {
+			case err == nil || errors.Is(err, ErrTimeout):
+			case err == io.EOF || errors.Is(err, os.ErrClosed):
+				return
+			default:
+				errc <- err
+				return
+			}
+		}
+	}()
+
+	return data, errc
+}
+
+// BufferPool recycles the read buffers of Port.ReadChanPool, so that
+// reading at a steady rate does not allocate. It keeps up to a fixed
+// number of released buffers; Get allocates when none is left. A
+// BufferPool may be shared by the readers of several ports.
+type BufferPool struct {
+	size int
+	free chan []byte
+}
+
+// NewBufferPool returns a pool of buffers of size bytes, or 256 if size
+// is not positive, that keeps up to keep released buffers, or none if
+// keep is negative.
+func NewBufferPool(size, keep int) *BufferPool {
+	if size <= 0 {
+		size = readChunk
+	}
+	if keep < 0 {
+		keep = 0
+	}
+
+	return &BufferPool{size: size, free: make(chan []byte, keep)}
+}
+
+// Get returns a released buffer, or a new one if there is none.
+func (bp *BufferPool) Get() []byte {
+	select {
+	case b := <-bp.free:
+		return b
+	default:
+		return make([]byte, bp.size)
+	}
+}
+
+// Release hands back a buffer returned by Get, or a chunk received from
+// ReadChanPool, for reuse. The caller must not use b afterwards. Slices
+// of other buffers are ignored, as are buffers beyond what the pool
+// keeps.
+func (bp *BufferPool) Release(b []byte) {
+	if cap(b) != bp.size {
+		return
+	}
+
+	select {
+	case bp.free <- b[:bp.size]:
+	default:
+	}
+}
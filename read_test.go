@@ -0,0 +1,61 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLookaheadLine(t *testing.T) {
+	var l lookahead
+
+	chunks := []string{"he", "llo\nwor", "ld\n"}
+	fill := func(b []byte, deadline time.Time) (int, error) {
+		if len(chunks) == 0 {
+			return 0, ErrTimeout
+		}
+		n := copy(b, chunks[0])
+		chunks = chunks[1:]
+		return n, nil
+	}
+
+	buf := make([]byte, 16)
+	n, err := l.line(buf, time.Time{}, fill)
+	require.NoError(t, err)
+	require.Equal(t, "hello\n", string(buf[:n]))
+
+	// a line longer than the buffer comes in pieces
+	n, err = l.line(buf[:3], time.Time{}, fill)
+	require.NoError(t, err)
+	require.Equal(t, "wor", string(buf[:n]))
+
+	n, err = l.line(buf, time.Time{}, fill)
+	require.NoError(t, err)
+	require.Equal(t, "ld\n", string(buf[:n]))
+
+	// a partial line is kept on error
+	chunks = []string{"part"}
+	_, err = l.line(buf, time.Time{}, fill)
+	require.Equal(t, ErrTimeout, err)
+	require.Equal(t, 4, l.len())
+}
+
+func TestErrTimeout(t *testing.T) {
+	var ne net.Error
+	require.True(t, errors.As(fmt.Errorf("read: %w", ErrTimeout), &ne))
+	require.True(t, ne.Timeout())
+	require.True(t, ne.Temporary())
+	require.True(t, errors.Is(ne, ErrTimeout))
+}
+
+func TestDeadlineAfter(t *testing.T) {
+	require.True(t, deadlineAfter(0).IsZero())
+	require.True(t, deadlineAfter(MaxTimeout).IsZero())
+	require.False(t, deadlineAfter(NoWait).After(time.Now()))
+	require.True(t, deadlineAfter(time.Second).After(time.Now()))
+	require.False(t, deadlineAfter(time.Nanosecond).Before(time.Now().Add(MinTimeout/2)))
+}
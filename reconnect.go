@@ -0,0 +1,284 @@
+package serial
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+// BackoffFunc returns how long a ReconnectingPort waits before reconnect
+// attempt n, counting from 1, or a negative duration to give up.
+type BackoffFunc func(attempt int) time.Duration
+
+// ExponentialBackoff returns a BackoffFunc that waits min before the
+// first attempt and twice as long before each further one, up to max. It
+// gives up after retries attempts, or never if retries is 0.
+func ExponentialBackoff(min, max time.Duration, retries int) BackoffFunc {
+	return func(attempt int) time.Duration {
+		if retries > 0 && attempt > retries {
+			return -1
+		}
+
+		d := min
+		for i := 1; i < attempt && d < max; i++ {
+			d *= 2
+		}
+		if d > max {
+			d = max
+		}
+
+		return d
+	}
+}
+
+// DefaultBackoff is used by NewReconnecting if no BackoffFunc is given:
+// it retries forever, from 100ms up to 5s apart.
+var DefaultBackoff = ExponentialBackoff(100*time.Millisecond, 5*time.Second, 0)
+
+// ConnState is the state of the connection of a ReconnectingPort.
+type ConnState byte
+
+const (
+	ConnUp   ConnState = iota // the device is open again
+	ConnDown                  // the device went away, reconnecting
+	ConnLost                  // the backoff gave up
+)
+
+// stateQueue is the number of state changes a ReconnectingPort queues
+// for its States channel.
+const stateQueue = 16
+
+// ReconnectingPort is a Port that survives its device going away, as
+// when a USB adapter is unplugged and plugged back. A read or write that
+// fails with ErrPortDisconnected reopens the device with Reopen, waiting
+// between attempts as the BackoffFunc says, and is then retried; the
+// data in flight is lost. Reads and writes block while reconnecting,
+// regardless of their deadlines. Once the backoff gives up, they return
+// the error of the disconnection.
+//
+// Only Read, ReadFull, ReadAtLeast, ReadTimeout, ReadContext, ReadUntil,
+// Write, WriteString, WriteByte and WriteAll reconnect. The other methods go
+// straight to the port, and may fail while it is being reopened.
+type ReconnectingPort struct {
+	Port
+
+	backoff BackoffFunc
+	states  chan ConnState
+	done    chan struct{}
+
+	// rw is held for reading by the operations on the port and for
+	// writing while reconnecting; gen counts the reconnects, and err is
+	// set once the backoff gave up.
+	rw  sync.RWMutex
+	gen int
+	err error
+
+	// cm serializes Close with Reopen.
+	cm     sync.Mutex
+	closed bool
+}
+
+// NewReconnecting opens the port of c like OpenPort and wraps it in a
+// ReconnectingPort using backoff, or DefaultBackoff if nil. An error
+// opening the port is returned as is, without retrying.
+func NewReconnecting(c Config, backoff BackoffFunc) (*ReconnectingPort, error) {
+	p, err := OpenPort(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return newReconnecting(p, backoff), nil
+}
+
+func newReconnecting(p Port, backoff BackoffFunc) *ReconnectingPort {
+	if backoff == nil {
+		backoff = DefaultBackoff
+	}
+
+	return &ReconnectingPort{
+		Port:    p,
+		backoff: backoff,
+		states:  make(chan ConnState, stateQueue),
+		done:    make(chan struct{}),
+	}
+}
+
+// States returns a channel reporting the changes of the connection
+// state. Changes are dropped while 16 of them wait to be received.
+func (r *ReconnectingPort) States() <-chan ConnState {
+	return r.states
+}
+
+func (r *ReconnectingPort) notify(s ConnState) {
+	select {
+	case r.states <- s:
+	default:
+	}
+}
+
+// do runs op, reconnecting and running it again each time it fails with
+// ErrPortDisconnected.
+func (r *ReconnectingPort) do(op func() error) error {
+	for {
+		r.rw.RLock()
+		gen, err := r.gen, r.err
+		if err == nil {
+			err = op()
+		}
+		r.rw.RUnlock()
+
+		if !errors.Is(err, ErrPortDisconnected) {
+			return err
+		}
+
+		if err = r.reconnect(gen, err); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnect reopens the port after an operation started in generation
+// gen failed with cause, unless another operation did so already.
+func (r *ReconnectingPort) reconnect(gen int, cause error) error {
+	r.rw.Lock()
+	defer r.rw.Unlock()
+
+	if r.err != nil {
+		return r.err
+	} else if r.gen != gen {
+		return nil
+	}
+
+	r.notify(ConnDown)
+
+	for attempt := 1; ; attempt++ {
+		d := r.backoff(attempt)
+		if d < 0 {
+			r.err = cause
+			r.notify(ConnLost)
+			return cause
+		}
+
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-r.done:
+			timer.Stop()
+			return os.ErrClosed
+		}
+
+		if err := r.reopen(); err == nil {
+			r.gen++
+			r.notify(ConnUp)
+			return nil
+		} else if err == os.ErrClosed {
+			return err
+		}
+	}
+}
+
+func (r *ReconnectingPort) reopen() error {
+	r.cm.Lock()
+	defer r.cm.Unlock()
+
+	if r.closed {
+		return os.ErrClosed
+	}
+
+	return r.Port.Reopen()
+}
+
+// Close closes the port and ends any reconnect in progress.
+func (r *ReconnectingPort) Close() error {
+	r.cm.Lock()
+	defer r.cm.Unlock()
+
+	if r.closed {
+		return nil
+	}
+
+	r.closed = true
+	close(r.done)
+
+	return r.Port.Close()
+}
+
+func (r *ReconnectingPort) Read(b []byte) (n int, err error) {
+	err = r.do(func() (err error) {
+		n, err = r.Port.Read(b)
+		return
+	})
+
+	return
+}
+
+func (r *ReconnectingPort) ReadFull(b []byte) (n int, err error) {
+	err = r.do(func() (err error) {
+		n, err = r.Port.ReadFull(b)
+		return
+	})
+
+	return
+}
+
+func (r *ReconnectingPort) ReadAtLeast(b []byte, min int) (n int, err error) {
+	err = r.do(func() (err error) {
+		n, err = r.Port.ReadAtLeast(b, min)
+		return
+	})
+
+	return
+}
+
+func (r *ReconnectingPort) ReadTimeout(b []byte, d time.Duration) (n int, err error) {
+	err = r.do(func() (err error) {
+		n, err = r.Port.ReadTimeout(b, d)
+		return
+	})
+
+	return
+}
+
+func (r *ReconnectingPort) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	err = r.do(func() (err error) {
+		n, err = r.Port.ReadContext(ctx, b)
+		return
+	})
+
+	return
+}
+
+func (r *ReconnectingPort) ReadUntil(delim byte) (line []byte, err error) {
+	err = r.do(func() (err error) {
+		line, err = r.Port.ReadUntil(delim)
+		return
+	})
+
+	return
+}
+
+// Write writes b, carrying on with the rest of it after a reconnect.
+func (r *ReconnectingPort) Write(b []byte) (n int, err error) {
+	err = r.do(func() error {
+		nn, err := r.Port.Write(b[n:])
+		n += nn
+		return err
+	})
+
+	return
+}
+
+func (r *ReconnectingPort) WriteString(s string) (int, error) {
+	return r.Write([]byte(s))
+}
+
+func (r *ReconnectingPort) WriteByte(c byte) error {
+	_, err := r.Write([]byte{c})
+	return err
+}
+
+func (r *ReconnectingPort) WriteAll(b []byte) error {
+	return writeAll(b, r.Write)
+}
@@ -0,0 +1,70 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	b := ExponentialBackoff(10*time.Millisecond, 50*time.Millisecond, 5)
+
+	var got []time.Duration
+	for attempt := 1; attempt <= 6; attempt++ {
+		got = append(got, b(attempt))
+	}
+	require.Equal(t, []time.Duration{
+		10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond,
+		50 * time.Millisecond, 50 * time.Millisecond, -1,
+	}, got)
+
+	require.Equal(t, 5*time.Second, DefaultBackoff(100))
+}
+
+func TestReconnectingPort(t *testing.T) {
+	m := NewMockPort()
+	r := newReconnecting(m, ExponentialBackoff(time.Millisecond, time.Millisecond, 0))
+	var _ Port = r
+
+	gone := fmt.Errorf("%w: unplugged", ErrPortDisconnected)
+	m.FailRead(gone)
+	go func() {
+		<-r.States()
+		<-r.States()
+		m.Inject([]byte("back"))
+	}()
+
+	buf := make([]byte, 8)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "back", string(buf[:n]))
+
+	m.FailWrite(gone)
+	_, err = r.WriteString("ok")
+	require.NoError(t, err)
+	require.Equal(t, ConnDown, <-r.States())
+	require.Equal(t, ConnUp, <-r.States())
+	require.Equal(t, []byte("ok"), m.Written())
+
+	require.NoError(t, r.Close())
+	require.NoError(t, r.Close())
+}
+
+func TestReconnectingPortGivesUp(t *testing.T) {
+	m := NewMockPort()
+	r := newReconnecting(m, func(int) time.Duration { return -1 })
+	defer r.Close()
+
+	m.FailRead(fmt.Errorf("%w: unplugged", ErrPortDisconnected))
+
+	_, err := r.Read(make([]byte, 8))
+	require.True(t, errors.Is(err, ErrPortDisconnected), "got %v", err)
+	require.Equal(t, ConnDown, <-r.States())
+	require.Equal(t, ConnLost, <-r.States())
+
+	_, err = r.Write([]byte("x"))
+	require.True(t, errors.Is(err, ErrPortDisconnected), "got %v", err)
+}
@@ -10,20 +10,34 @@ manually:
 
  GOOS=windows make clean install
 
-Currently there is very little in the way of configurability.  You can
-set the baud rate.  Then you can Read(), Write(), or Close() the
-connection.  Read() will block until at least one byte is returned.
-Write is the same.  There is currently no exposed way to set the
-timeouts, though patches are welcome.
+Ports are configured with a Config.  Then you can Read(), Write(), or
+Close() the connection.  Read() will block until at least one byte is
+returned.  Write is the same.  Use SetReadDeadline and SetWriteDeadline
+to bound them.  A read whose deadline passes fails with ErrTimeout, never
+io.EOF; one on a port closed by Close fails with os.ErrClosed, and one on
+a port whose device went away with ErrPortDisconnected, never
+ErrTimeout.
 
-Currently all ports are opened with 8 data bits, 1 stop bit, no
-parity, no hardware flow control, and no software flow control.  This
-works fine for many real devices and many faux serial devices
-including usb-to-serial converters and bluetooth serial ports.
+Unless the Config says otherwise, ports are opened with 8 data bits, 1
+stop bit, no parity and no flow control.  This works fine for many real
+devices and many faux serial devices including usb-to-serial
+converters and bluetooth serial ports.  5 to 8 data bits, odd, even,
+mark and space parity and 1.5 or 2 stop bits may be selected
+instead, e.g. 7E1 for ASCII terminals.
 
 You may Read() and Write() simulantiously on the same connection (from
 different goroutines).
 
+The methods changing settings (SetBaud, SetParity, Reconfigure,
+SetReadDeadline and the like), the control line methods (SetDTR,
+SetRTS, SetDTRRTS, PulseDTR, SetBreak, ...) and the status queries may
+be called from any goroutine as well, including while a Read or Write
+is in progress. They are serialized with each other, so every change is
+applied whole, and a Read or Write keeps the deadline it started with.
+Close may be called at any time and makes pending reads and writes
+fail. Several concurrent Reads, or several concurrent Writes, are not
+supported.
+
 Example usage:
 
   package main
@@ -56,19 +70,123 @@ Example usage:
 package serial
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"io"
+	"sort"
+	"syscall"
 	"time"
 )
 
 type Port interface {
 	io.ReadWriteCloser
+	io.StringWriter
+	io.ByteWriter
+	Reopen() error
+	CloseGraceful(timeout time.Duration) error
+	Loopback(pattern []byte, timeout time.Duration) error
+	Fd() uintptr
+	SyscallConn() (syscall.RawConn, error)
+	Name() string
+	ReadFull([]byte) (int, error)
+	ReadAtLeast(b []byte, min int) (int, error)
+	ReadContext(ctx context.Context, b []byte) (int, error)
+	ReadTimeout(b []byte, d time.Duration) (int, error)
+	ReadUntil(delim byte) ([]byte, error)
+	ReadChan(bufSize int) (<-chan []byte, <-chan error)
+	ReadChanPool(pool *BufferPool) (<-chan []byte, <-chan error)
+	CancelRead() error
 	SetReadDeadline(time.Duration) error
+	SetWriteDeadline(time.Duration) error
+	SetReadDeadlineTime(time.Time) error
+	SetWriteDeadlineTime(time.Time) error
 	Flush() error
+	FlushInput() error
+	FlushOutput() error
+	FlushInputN() (int, error)
+	FlushOutputN() (int, error)
+	Drain() error
+	Sync() error
+	Available() (int, error)
 	Status() (uint, error)
+	ModemStatus() (ModemStatus, error)
+	CTS() (bool, error)
+	DSR() (bool, error)
+	DCD() (bool, error)
+	RI() (bool, error)
+	WaitForStatusChange(lines uint, timeout time.Duration) (uint, error)
+	WaitForDCD(timeout time.Duration) error
 	SetDTR(bool) error
 	SetRTS(bool) error
+	SetDTRRTS(dtr, rts bool) error
+	GetModemControl() (uint, error)
+	SetModemControl(bits, mask uint) error
+	PulseDTR(low time.Duration) error
+	PulseRTS(low time.Duration) error
 	SetParity(Parity) error
+	SetBaud(int) error
+	ActualBaud() (int, error)
+	SetStopBits(StopBits) error
+	SetSize(DataSize) error
+	Reconfigure(Config) error
+	FlowControl() (FlowControl, error)
+	GetConfig() (Config, error)
+	SendXON() error
+	SendXOFF() error
+	SetBreak(on bool) error
+	WriteWithAddress(addr byte, data []byte) error
+	WriteProgress(b []byte, cb func(written int)) (int, error)
+	WriteAll(b []byte) error
+	SendBreak(time.Duration) error
+	SetRS485(RS485Config) error
+	SetLowLatency(bool) error
+	SetFIFOTrigger(level int) error
+	FIFOTrigger() (int, error)
+	SetBufferSizes(rx, tx int) error
+	ErrorCounts() (ErrorCounts, error)
+	InterruptCounts() (InterruptCounts, error)
+	ResetErrorCounts() error
+	ErrorReader() io.Reader
+	Stats() Stats
+	ResetStats()
+}
+
+// ModemStatus is a snapshot of the modem lines.
+type ModemStatus struct {
+	// Inputs
+	CTS bool
+	DSR bool
+	DCD bool
+	RI  bool
+	// Outputs
+	DTR bool
+	RTS bool
+}
+
+// ErrorCounts holds the number of receive errors seen since the port was
+// opened or the counts were last reset.
+type ErrorCounts struct {
+	Frame   uint // characters received with a framing error
+	Parity  uint // characters received with a parity error
+	Overrun uint // characters lost because the UART was not serviced in time
+	// BufferOverrun counts characters lost because the driver's input
+	// buffer was full.
+	BufferOverrun uint
+}
+
+// InterruptCounts holds the counters kept by the driver since it was
+// loaded, as returned by Port.InterruptCounts: the transitions of the
+// modem status lines, the characters transferred and the receive
+// errors.
+type InterruptCounts struct {
+	CTS, DSR, RI, DCD uint // transitions of the modem status lines
+	Rx, Tx            uint // characters received and transmitted
+	Frame             uint // characters received with a framing error
+	Overrun           uint // characters lost by the UART
+	Parity            uint // characters received with a parity error
+	Break             uint // breaks received
+	BufferOverrun     uint // characters lost to a full input buffer
 }
 
 var ErrNotSupported = errors.New("serial: not supported")
@@ -82,10 +200,173 @@ var ErrBadStopBits = errors.New("serial: unsupported stop bit setting")
 // ErrBadParity is returned if the parity is not supported.
 var ErrBadParity = errors.New("serial: unsupported parity setting")
 
+// ErrBadFlowControl is returned if the flow control mode is not supported.
+var ErrBadFlowControl = errors.New("serial: unsupported flow control setting")
+
+// ErrPortBusy is returned by OpenPort if the port is held exclusively
+// by another process.
+var ErrPortBusy = errors.New("serial: port busy")
+
 var ErrInvalidArg = errors.New("serial: invalid argument")
 
+// ErrPortDisconnected is returned, wrapping the underlying error, by reads
+// and writes on a port whose device has gone away, such as an unplugged
+// USB adapter. The port has to be reopened once the device is back.
+var ErrPortDisconnected = errors.New("serial: port disconnected")
+
+// ErrLoopbackMismatch is returned by Loopback, wrapped with the position
+// of the first difference, if the data read back differs from the data
+// sent.
+var ErrLoopbackMismatch = errors.New("serial: loopback mismatch")
+
+// ErrBreak is returned by Read when a break condition was received and
+// Config.DetectBreak is set. Data received before the break is returned
+// by the preceding reads.
+var ErrBreak = errors.New("serial: break received")
+
+// ErrReadCanceled is returned by a read ended by Port.CancelRead.
+var ErrReadCanceled = errors.New("serial: read canceled")
+
+// ErrReadOnly is returned by writes on a port opened ReadOnly, and
+// ErrWriteOnly by reads on a port opened WriteOnly, see Config.Mode.
+var (
+	ErrReadOnly  = errors.New("serial: port opened read-only")
+	ErrWriteOnly = errors.New("serial: port opened write-only")
+)
+
+// ParityError is returned by the reader from Port.ErrorReader in place
+// of a character received with a parity error.
+type ParityError struct {
+	Offset int64 // position of the character in the data read
+	Char   byte  // the character as received
+}
+
+func (e *ParityError) Error() string {
+	return fmt.Sprintf("serial: parity error at byte %d", e.Offset)
+}
+
+// FrameError is returned by the reader from Port.ErrorReader in place of
+// a character received with a framing error.
+type FrameError struct {
+	Offset int64 // position of the character in the data read
+}
+
+func (e *FrameError) Error() string {
+	return fmt.Sprintf("serial: framing error at byte %d", e.Offset)
+}
+
+// PortError records a failed system call on a port, like os.PathError
+// does for files. OpenPort, Read and Write return one for the errors of
+// the operating system, which errors.Is sees through, so that for
+// example errors.Is(err, os.ErrPermission) reports an open that was
+// denied. Errors of the package itself, such as ErrTimeout, are returned
+// as they are.
+type PortError struct {
+	Op   string // "open", "read" or "write"
+	Name string // the name of the port
+	Err  error  // the underlying error
+}
+
+func (e *PortError) Error() string {
+	return e.Op + " " + e.Name + ": " + e.Err.Error()
+}
+
+func (e *PortError) Unwrap() error {
+	return e.Err
+}
+
+// ShortWriteError is returned by Port.WriteAll when only part of the data
+// was written, because the write deadline passed or writing failed. It
+// unwraps to the cause, so errors.Is(err, ErrTimeout) reports a timeout.
+type ShortWriteError struct {
+	Written   int   // bytes written
+	Remaining int   // bytes left unwritten
+	Err       error // the reason
+}
+
+func (e *ShortWriteError) Error() string {
+	return fmt.Sprintf("serial: short write, %d of %d bytes left: %v",
+		e.Remaining, e.Written+e.Remaining, e.Err)
+}
+
+func (e *ShortWriteError) Unwrap() error {
+	return e.Err
+}
+
+// portError wraps err in a *PortError if it comes from a system call or
+// reports a disconnection; any other error is returned unchanged.
+func portError(op, name string, err error) error {
+	var errno syscall.Errno
+	if err == nil || !errors.As(err, &errno) && !errors.Is(err, ErrPortDisconnected) {
+		return err
+	}
+
+	return &PortError{Op: op, Name: name, Err: err}
+}
+
+// ErrTimeout is returned if a read or write deadline expires. It
+// implements net.Error, reporting both Timeout and Temporary.
+var ErrTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "serial: timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
 // OpenPort opens a serial port with the specified configuration
 func OpenPort(c Config) (Port, error) {
+	c.setDefaults()
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	if c.ReadTimeout == 0 {
+		c.ReadTimeout = MaxTimeout
+	}
+
+	if c.writeTimeout == 0 {
+		c.writeTimeout = MaxTimeout
+	}
+
+	return openPort(c)
+}
+
+// OpenPortContext is OpenPort returning ctx.Err() if ctx is done before
+// the open completes. The open itself cannot be interrupted and goes on
+// in the background; a port it opens after that is closed.
+func OpenPortContext(ctx context.Context, c Config) (Port, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		p   Port
+		err error
+	}
+
+	done := make(chan result, 1)
+	go func() {
+		p, err := OpenPort(c)
+		done <- result{p, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.p, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.err == nil {
+				r.p.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// setDefaults fills in the framing settings left zero in c.
+func (c *Config) setDefaults() {
 	if c.Size == 0 {
 		c.Size = DefaultSize
 	}
@@ -98,7 +379,214 @@ func OpenPort(c Config) (Port, error) {
 		c.StopBits = Stop1
 	}
 
-	c.timeout = MaxTimeout
+	if c.XonChar == 0 {
+		c.XonChar = DefaultXonChar
+	}
 
-	return openPort(c)
+	if c.XoffChar == 0 {
+		c.XoffChar = DefaultXoffChar
+	}
+}
+
+// writeChunk is the size of the writes made by WriteProgress, small
+// enough for frequent progress reports at common rates.
+const writeChunk = 4096
+
+// writeProgress writes b in chunks of writeChunk bytes, or max if not
+// zero, with write and calls cb, if not nil, with the total written
+// after each chunk. It stops at the first error, returning the count
+// written until then.
+func writeProgress(b []byte, max int, cb func(written int), write func([]byte) (int, error)) (n int, err error) {
+	chunk := writeChunk
+	if max > 0 {
+		chunk = max
+	}
+
+	for n < len(b) {
+		end := n + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+
+		var nn int
+		nn, err = write(b[n:end])
+		n += nn
+		if nn > 0 && cb != nil {
+			cb(n)
+		}
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// writeAll writes all of b with write, carrying on after short writes.
+// A write failing or making no progress ends it with a *ShortWriteError.
+func writeAll(b []byte, write func([]byte) (int, error)) error {
+	n := 0
+	for n < len(b) {
+		nn, err := write(b[n:])
+		n += nn
+		if err == nil && nn == 0 && n < len(b) {
+			err = io.ErrShortWrite
+		}
+		if err != nil {
+			return &ShortWriteError{Written: n, Remaining: len(b) - n, Err: err}
+		}
+	}
+
+	return nil
+}
+
+// closeGraceful waits up to timeout for the output of p to drain, then
+// discards whatever is still pending and closes p. A zero or MaxTimeout
+// timeout waits as long as it takes. ErrTimeout is returned if output had
+// to be discarded.
+func closeGraceful(p Port, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.Drain()
+	}()
+
+	var expired <-chan time.Time
+	if deadline := deadlineAfter(timeout); !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		expired = t.C
+	}
+
+	var err error
+	select {
+	case err = <-done:
+	case <-expired:
+		err = ErrTimeout
+		_ = p.FlushOutput()
+	}
+
+	if cerr := p.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// StandardBauds returns the baud rates with a native speed constant on
+// this platform, in ascending order. Other rates go through the custom
+// baud rate path where there is one and may be rounded by the driver.
+func StandardBauds() []int {
+	bauds := make([]int, 0, len(speeds))
+	for baud := range speeds {
+		bauds = append(bauds, baud)
+	}
+	sort.Ints(bauds)
+
+	return bauds
+}
+
+// IsStandardBaud reports whether baud is one of StandardBauds.
+func IsStandardBaud(baud int) bool {
+	_, ok := speeds[baud]
+	return ok
+}
+
+// loopback writes pattern to p and expects to read it back within
+// timeout, as with TX and RX jumpered. A zero or MaxTimeout timeout waits
+// as long as it takes.
+func loopback(p Port, pattern []byte, timeout time.Duration) error {
+	if err := p.FlushInput(); err != nil {
+		return err
+	}
+
+	deadline := deadlineAfter(timeout)
+
+	if _, err := p.Write(pattern); err != nil {
+		return err
+	}
+
+	got := make([]byte, len(pattern))
+	for n := 0; n < len(got); {
+		d := time.Duration(0)
+		if !deadline.IsZero() {
+			if d = time.Until(deadline); d <= 0 {
+				d = NoWait
+			}
+		}
+
+		nn, err := p.ReadTimeout(got[n:], d)
+		for i := n; i < n+nn; i++ {
+			if got[i] != pattern[i] {
+				return fmt.Errorf("%w at byte %d: sent %#02x, received %#02x", ErrLoopbackMismatch, i, pattern[i], got[i])
+			}
+		}
+		n += nn
+
+		if err != nil {
+			return fmt.Errorf("serial: loopback received %d of %d bytes: %w", n, len(got), err)
+		}
+	}
+
+	return nil
+}
+
+// dsrPollInterval is how often a write paused by FlowDSRDTR checks DSR
+// where the driver does not do the handshake, unless Config.DSRPollInterval
+// says otherwise.
+const dsrPollInterval = 10 * time.Millisecond
+
+// waitForDSR waits for p to report DSR, polling it every interval, or
+// dsrPollInterval if 0, until the deadline passes. A zero deadline waits
+// as long as it takes.
+func waitForDSR(p Port, deadline time.Time, interval time.Duration) error {
+	if interval <= 0 {
+		interval = dsrPollInterval
+	}
+
+	for {
+		dsr, err := p.DSR()
+		if err != nil {
+			return err
+		} else if dsr {
+			return nil
+		}
+
+		d := interval
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return ErrTimeout
+			} else if left < d {
+				d = left
+			}
+		}
+
+		time.Sleep(d)
+	}
+}
+
+// waitForDCD waits for p to report carrier, until the timeout passes. A
+// zero or MaxTimeout timeout waits as long as it takes.
+func waitForDCD(p Port, timeout time.Duration) error {
+	deadline := deadlineAfter(timeout)
+
+	for {
+		dcd, err := p.DCD()
+		if err != nil {
+			return err
+		} else if dcd {
+			return nil
+		}
+
+		d := time.Duration(0)
+		if !deadline.IsZero() {
+			if d = time.Until(deadline); d <= 0 {
+				return ErrTimeout
+			}
+		}
+
+		if _, err := p.WaitForStatusChange(StatusDCD, d); err != nil {
+			return err
+		}
+	}
 }
@@ -0,0 +1,82 @@
+// +build freebsd openbsd netbsd dragonfly
+
+package serial
+
+// #include <termios.h>
+import "C"
+
+import (
+	"fmt"
+	"time"
+)
+
+// cmspar is zero as there is no mark or space parity on the BSDs.
+const cmspar = 0
+
+// SetRS485 is only supported on Linux.
+func (p *impl) SetRS485(RS485Config) error {
+	return ErrNotSupported
+}
+
+// WaitForStatusChange is only supported on Linux.
+func (p *impl) WaitForStatusChange(uint, time.Duration) (uint, error) {
+	return 0, ErrNotSupported
+}
+
+// InterruptCounts is only supported on Linux.
+func (p *impl) InterruptCounts() (InterruptCounts, error) {
+	return InterruptCounts{}, ErrNotSupported
+}
+
+// SetLowLatency is only supported on Linux.
+func (p *impl) SetLowLatency(bool) error {
+	return ErrNotSupported
+}
+
+// SetFIFOTrigger is only supported on Linux.
+func (p *impl) SetFIFOTrigger(int) error {
+	return ErrNotSupported
+}
+
+// FIFOTrigger is only supported on Linux.
+func (p *impl) FIFOTrigger() (int, error) {
+	return 0, ErrNotSupported
+}
+
+// driverErrorCounts is not available on the BSDs, so ErrorCounts only
+// counts marked characters.
+func (p *impl) driverErrorCounts() (ErrorCounts, error) {
+	return ErrorCounts{}, ErrNotSupported
+}
+
+// setCustomAttrs applies the cached terminal attributes with p.customBaud
+// as the speed. The BSD speed values are the rates themselves, so
+// cfsetspeed accepts any integer and the driver decides what it can do.
+func (p *impl) setCustomAttrs() error {
+	st := p.st
+	if _, err := C.cfsetspeed(&st, C.speed_t(p.customBaud)); err != nil {
+		return fmt.Errorf("serial: unknown baud rate %v", p.customBaud)
+	}
+
+	return retryEINTR(func() error {
+		_, err := C.tcsetattr(C.int(p.fd), C.TCSANOW, &st)
+		return err
+	})
+}
+
+// customBaudRate returns the output rate of the live terminal
+// attributes, which is the rate itself on the BSDs.
+func (p *impl) customBaudRate() (int, error) {
+	var st C.struct_termios
+	if _, err := C.tcgetattr(C.int(p.fd), &st); err != nil {
+		return 0, err
+	}
+
+	return int(C.cfgetospeed(&st)), nil
+}
+
+// clearDivisor has nothing to undo, as custom divisors are only used on
+// Linux.
+func (p *impl) clearDivisor() error {
+	return nil
+}
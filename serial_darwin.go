@@ -0,0 +1,91 @@
+// +build darwin
+
+package serial
+
+// #include <termios.h>
+import "C"
+
+import (
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// cmspar is zero as there is no mark or space parity on this platform.
+const cmspar = 0
+
+// iossiospeed is IOSSIOSPEED from IOKit/serial/ioss.h, _IOW('T', 2, speed_t)
+const iossiospeed = 0x80085402
+
+// SetRS485 is only supported on Linux.
+func (p *impl) SetRS485(RS485Config) error {
+	return ErrNotSupported
+}
+
+// WaitForStatusChange is only supported on Linux.
+func (p *impl) WaitForStatusChange(uint, time.Duration) (uint, error) {
+	return 0, ErrNotSupported
+}
+
+// InterruptCounts is only supported on Linux.
+func (p *impl) InterruptCounts() (InterruptCounts, error) {
+	return InterruptCounts{}, ErrNotSupported
+}
+
+// SetLowLatency is only supported on Linux.
+func (p *impl) SetLowLatency(bool) error {
+	return ErrNotSupported
+}
+
+// SetFIFOTrigger is only supported on Linux.
+func (p *impl) SetFIFOTrigger(int) error {
+	return ErrNotSupported
+}
+
+// FIFOTrigger is only supported on Linux.
+func (p *impl) FIFOTrigger() (int, error) {
+	return 0, ErrNotSupported
+}
+
+// driverErrorCounts is not available on macOS, so ErrorCounts only
+// counts marked characters.
+func (p *impl) driverErrorCounts() (ErrorCounts, error) {
+	return ErrorCounts{}, ErrNotSupported
+}
+
+// setCustomAttrs applies the termios settings with the placeholder speed
+// and then sets the real rate with ioctl(IOSSIOSPEED). The driver resets
+// the rate on every tcsetattr, so both steps are always done together.
+func (p *impl) setCustomAttrs() error {
+	if err := retryEINTR(func() error {
+		_, err := C.tcsetattr(C.int(p.fd), C.TCSANOW, &p.st)
+		return err
+	}); err != nil {
+		return err
+	}
+
+	speed := C.speed_t(p.customBaud)
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(iossiospeed),
+		uintptr(unsafe.Pointer(&speed)),
+	); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// customBaudRate returns the rate set through IOSSIOSPEED, which cannot
+// be read back from the driver.
+func (p *impl) customBaudRate() (int, error) {
+	return p.customBaud, nil
+}
+
+// clearDivisor has nothing to undo, as custom divisors are only used on
+// Linux.
+func (p *impl) clearDivisor() error {
+	return nil
+}
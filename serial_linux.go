@@ -0,0 +1,449 @@
+// +build linux
+
+package serial
+
+// #include <termios.h>
+import "C"
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Flags of struct serial_rs485, see linux/serial.h
+const (
+	serRS485Enabled      = 1 << 0
+	serRS485RTSOnSend    = 1 << 1
+	serRS485RTSAfterSend = 1 << 2
+	serRS485RxDuringTx   = 1 << 4
+)
+
+// cmspar selects mark or space parity, as PARODD is set or not.
+const cmspar = unix.CMSPAR
+
+// Flags of struct serial_struct, see linux/tty_flags.h
+const (
+	asyncLowLatency = 1 << 13
+	// asyncSpdCust makes B38400 select baud_base / custom_divisor
+	asyncSpdCust = 0x0030
+	asyncSpdMask = 0x1030
+)
+
+// serialStruct mirrors struct serial_struct from linux/serial.h
+type serialStruct struct {
+	typ           int32
+	line          int32
+	port          uint32
+	irq           int32
+	flags         int32
+	xmitFifoSize  int32
+	customDivisor int32
+	baudBase      int32
+	closeDelay    uint16
+	ioType        int8
+	reservedChar  [1]int8
+	hub6          int32
+	closingWait   uint16
+	closingWait2  uint16
+	iomemBase     uintptr
+	iomemRegShift uint16
+	portHigh      uint32
+	iomapBase     uintptr
+}
+
+// serialIcounter mirrors struct serial_icounter_struct from linux/serial.h
+type serialIcounter struct {
+	cts, dsr, rng, dcd int32
+	rx, tx             int32
+	frame, overrun     int32
+	parity, brk        int32
+	bufOverrun         int32
+	reserved           [9]int32
+}
+
+// serialRS485 mirrors struct serial_rs485 from linux/serial.h
+type serialRS485 struct {
+	flags              uint32
+	delayRTSBeforeSend uint32
+	delayRTSAfterSend  uint32
+	padding            [5]uint32
+}
+
+// SetRS485 programs the driver's RS-485 mode. ErrNotSupported is
+// returned if the driver does not implement it.
+func (p *impl) SetRS485(cfg RS485Config) error {
+	var rs485 serialRS485
+
+	if cfg.Enabled {
+		rs485.flags |= serRS485Enabled
+	}
+	if cfg.RTSOnSend {
+		rs485.flags |= serRS485RTSOnSend
+	}
+	if cfg.RTSAfterSend {
+		rs485.flags |= serRS485RTSAfterSend
+	}
+	if cfg.RxDuringTx {
+		rs485.flags |= serRS485RxDuringTx
+	}
+
+	rs485.delayRTSBeforeSend = uint32(cfg.DelayRTSBeforeSend / time.Millisecond)
+	rs485.delayRTSAfterSend = uint32(cfg.DelayRTSAfterSend / time.Millisecond)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(unix.TIOCSRS485),
+		uintptr(unsafe.Pointer(&rs485)),
+	); errno != 0 {
+		if errno == unix.ENOTTY || errno == unix.EINVAL {
+			return ErrNotSupported
+		}
+		return errno
+	}
+
+	p.c.RS485 = cfg
+
+	return nil
+}
+
+// setCustomAttrs applies the cached terminal attributes together with
+// p.customBaud through the termios2 interface, which accepts any rate
+// when the speed bits are set to BOTHER. Drivers without BOTHER get the
+// rate as a custom divisor of their base rate instead, see
+// setDivisorAttrs.
+func (p *impl) setCustomAttrs() error {
+	t, err := unix.IoctlGetTermios(int(p.fd), ioctlGetTermios2)
+	if err == unix.ENOTTY || err == unix.EINVAL {
+		return p.setDivisorAttrs()
+	}
+	if err != nil {
+		return err
+	}
+
+	t.Iflag = uint32(p.st.c_iflag)
+	t.Oflag = uint32(p.st.c_oflag)
+	t.Cflag = uint32(p.st.c_cflag)
+	t.Lflag = uint32(p.st.c_lflag)
+	for i := range t.Cc {
+		t.Cc[i] = uint8(p.st.c_cc[i])
+	}
+
+	t.Cflag &^= unix.CBAUD | unix.CBAUD<<unix.IBSHIFT
+	t.Cflag |= unix.BOTHER | unix.BOTHER<<unix.IBSHIFT
+	t.Ispeed = uint32(p.customBaud)
+	t.Ospeed = uint32(p.customBaud)
+
+	err = retryEINTR(func() error {
+		return unix.IoctlSetTermios(int(p.fd), ioctlSetTermios2, t)
+	})
+	if err == unix.EINVAL {
+		return p.setDivisorAttrs()
+	}
+	if err != nil {
+		return err
+	}
+
+	return p.clearDivisor()
+}
+
+// setDivisorAttrs applies p.customBaud the way older drivers take rates
+// without a termios constant: ASYNC_SPD_CUST is set along with a divisor
+// of the driver's base rate, which B38400, the placeholder speed in the
+// cached attributes, then selects.
+func (p *impl) setDivisorAttrs() error {
+	ss, err := p.getSerial()
+	if err != nil {
+		return err
+	}
+
+	div, err := customDivisor(int(ss.baudBase), p.customBaud)
+	if err != nil {
+		return err
+	}
+
+	ss.flags = ss.flags&^asyncSpdMask | asyncSpdCust
+	ss.customDivisor = int32(div)
+	if err = p.setSerial(&ss); err != nil {
+		return err
+	}
+	p.divisor = true
+
+	return retryEINTR(func() error {
+		_, err := C.tcsetattr(C.int(p.fd), C.TCSANOW, &p.st)
+		return err
+	})
+}
+
+// customDivisor returns the divisor of base coming closest to baud.
+func customDivisor(base, baud int) (int, error) {
+	if base <= 0 {
+		return 0, fmt.Errorf("serial: unknown baud rate %v", baud)
+	}
+
+	div := (base + baud/2) / baud
+	if div < 1 {
+		return 0, fmt.Errorf("serial: baud rate %v exceeds the base rate %v", baud, base)
+	}
+
+	return div, nil
+}
+
+// clearDivisor clears ASYNC_SPD_CUST if setDivisorAttrs set it, so that
+// B38400 means 38400 baud again.
+func (p *impl) clearDivisor() error {
+	if !p.divisor {
+		return nil
+	}
+
+	ss, err := p.getSerial()
+	if err != nil {
+		return err
+	}
+
+	ss.flags &^= asyncSpdMask
+	ss.customDivisor = 0
+	if err = p.setSerial(&ss); err != nil {
+		return err
+	}
+	p.divisor = false
+
+	return nil
+}
+
+// customBaudRate returns the output rate achieved by the driver, as
+// reported through the termios2 interface or computed from the custom
+// divisor.
+func (p *impl) customBaudRate() (int, error) {
+	if p.divisor {
+		ss, err := p.getSerial()
+		if err != nil {
+			return 0, err
+		}
+		if ss.customDivisor > 0 {
+			return int(ss.baudBase / ss.customDivisor), nil
+		}
+	}
+
+	t, err := unix.IoctlGetTermios(int(p.fd), ioctlGetTermios2)
+	if err != nil {
+		return 0, err
+	}
+
+	return int(t.Ospeed), nil
+}
+
+// SetLowLatency toggles the driver's ASYNC_LOW_LATENCY flag, which makes
+// received data available to readers without the usual batching delay.
+// ErrNotSupported is returned if the driver does not implement it.
+func (p *impl) SetLowLatency(on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	ss, err := p.getSerial()
+	if err != nil {
+		return err
+	}
+
+	if on {
+		ss.flags |= asyncLowLatency
+	} else {
+		ss.flags &^= asyncLowLatency
+	}
+
+	if err = p.setSerial(&ss); err != nil {
+		return err
+	}
+
+	p.c.LowLatency = on
+
+	return nil
+}
+
+// sysClassTTY is where the kernel lists the terminal devices and their
+// attributes.
+var sysClassTTY = "/sys/class/tty"
+
+// rxTrigPath returns the sysfs attribute holding the receive FIFO trigger
+// level of the device name, resolving links such as the ones in
+// /dev/serial/by-id first.
+func rxTrigPath(name string) string {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+
+	return filepath.Join(sysClassTTY, filepath.Base(name), "rx_trig_bytes")
+}
+
+// SetFIFOTrigger sets the number of bytes in the receive FIFO of a 16550
+// style UART that raise an interrupt, through the rx_trig_bytes attribute
+// of the 8250 driver. A lower level reduces the latency of small packets
+// at the risk of overruns at high rates. The driver picks the highest
+// level it supports not above level, see FIFOTrigger. ErrNotSupported is
+// returned for other drivers, and changing it usually requires root.
+func (p *impl) SetFIFOTrigger(level int) error {
+	if level <= 0 {
+		return fmt.Errorf("%w: FIFO trigger level %d", ErrInvalidArg, level)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	path := rxTrigPath(p.c.Name)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return ErrNotSupported
+	}
+
+	return ioutil.WriteFile(path, []byte(strconv.Itoa(level)), 0644)
+}
+
+// FIFOTrigger returns the receive FIFO trigger level in bytes, see
+// SetFIFOTrigger.
+func (p *impl) FIFOTrigger() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	b, err := ioutil.ReadFile(rxTrigPath(p.c.Name))
+	if os.IsNotExist(err) {
+		return 0, ErrNotSupported
+	} else if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+func (p *impl) getSerial() (ss serialStruct, err error) {
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(unix.TIOCGSERIAL),
+		uintptr(unsafe.Pointer(&ss)),
+	); errno != 0 {
+		err = errno
+		if errno == unix.ENOTTY || errno == unix.EINVAL {
+			err = ErrNotSupported
+		}
+	}
+
+	return
+}
+
+func (p *impl) setSerial(ss *serialStruct) error {
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(unix.TIOCSSERIAL),
+		uintptr(unsafe.Pointer(ss)),
+	); errno != 0 {
+		if errno == unix.ENOTTY || errno == unix.EINVAL {
+			return ErrNotSupported
+		}
+		return errno
+	}
+
+	return nil
+}
+
+// driverErrorCounts reads the driver's interrupt counters.
+func (p *impl) driverErrorCounts() (ErrorCounts, error) {
+	ic, err := p.InterruptCounts()
+	if err != nil {
+		return ErrorCounts{}, err
+	}
+
+	return ErrorCounts{
+		Frame:         ic.Frame,
+		Parity:        ic.Parity,
+		Overrun:       ic.Overrun,
+		BufferOverrun: ic.BufferOverrun,
+	}, nil
+}
+
+// InterruptCounts reads the driver's counters with a single
+// ioctl(TIOCGICOUNT). ErrNotSupported is returned if the driver does not
+// keep them, as USB adapters often do not.
+func (p *impl) InterruptCounts() (InterruptCounts, error) {
+	var ic serialIcounter
+
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(unix.TIOCGICOUNT),
+		uintptr(unsafe.Pointer(&ic)),
+	); errno != 0 {
+		if errno == unix.ENOTTY || errno == unix.EINVAL {
+			return InterruptCounts{}, ErrNotSupported
+		}
+		return InterruptCounts{}, errno
+	}
+
+	return InterruptCounts{
+		CTS:           uint(ic.cts),
+		DSR:           uint(ic.dsr),
+		RI:            uint(ic.rng),
+		DCD:           uint(ic.dcd),
+		Rx:            uint(ic.rx),
+		Tx:            uint(ic.tx),
+		Frame:         uint(ic.frame),
+		Overrun:       uint(ic.overrun),
+		Parity:        uint(ic.parity),
+		Break:         uint(ic.brk),
+		BufferOverrun: uint(ic.bufOverrun),
+	}, nil
+}
+
+// WaitForStatusChange blocks until one of the given Status* lines changes
+// state or the timeout passes, and returns the new status. A zero
+// timeout waits forever. The kernel wait cannot be interrupted, so after
+// a timeout it lingers in the background until the next transition or
+// until the port is closed.
+func (p *impl) WaitForStatusChange(lines uint, timeout time.Duration) (uint, error) {
+	done := make(chan error, 1)
+	go func() {
+		done <- retryEINTR(func() error {
+			if _, _, errno := unix.Syscall(
+				unix.SYS_IOCTL,
+				p.fd,
+				uintptr(unix.TIOCMIWAIT),
+				uintptr(lines),
+			); errno != 0 {
+				return errno
+			}
+			return nil
+		})
+	}()
+
+	var err error
+	if deadline := deadlineAfter(timeout); deadline.IsZero() {
+		err = <-done
+	} else {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+
+		select {
+		case err = <-done:
+		case <-timer.C:
+			return 0, ErrTimeout
+		}
+	}
+
+	if err == unix.ENOTTY || err == unix.EINVAL {
+		return 0, ErrNotSupported
+	} else if err != nil {
+		return 0, err
+	}
+
+	return p.Status()
+}
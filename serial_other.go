@@ -0,0 +1,63 @@
+// +build !windows,!linux,!darwin,!freebsd,!openbsd,!netbsd,!dragonfly
+
+package serial
+
+import (
+	"fmt"
+	"time"
+)
+
+// cmspar is zero as there is no mark or space parity on this platform.
+const cmspar = 0
+
+// SetRS485 is only supported on Linux.
+func (p *impl) SetRS485(RS485Config) error {
+	return ErrNotSupported
+}
+
+// WaitForStatusChange is only supported on Linux.
+func (p *impl) WaitForStatusChange(uint, time.Duration) (uint, error) {
+	return 0, ErrNotSupported
+}
+
+// InterruptCounts is only supported on Linux.
+func (p *impl) InterruptCounts() (InterruptCounts, error) {
+	return InterruptCounts{}, ErrNotSupported
+}
+
+// SetLowLatency is only supported on Linux.
+func (p *impl) SetLowLatency(bool) error {
+	return ErrNotSupported
+}
+
+// SetFIFOTrigger is only supported on Linux.
+func (p *impl) SetFIFOTrigger(int) error {
+	return ErrNotSupported
+}
+
+// FIFOTrigger is only supported on Linux.
+func (p *impl) FIFOTrigger() (int, error) {
+	return 0, ErrNotSupported
+}
+
+// driverErrorCounts is not available on this platform, so ErrorCounts
+// only counts marked characters.
+func (p *impl) driverErrorCounts() (ErrorCounts, error) {
+	return ErrorCounts{}, ErrNotSupported
+}
+
+// setCustomAttrs fails since only rates with a termios constant are
+// supported on this platform.
+func (p *impl) setCustomAttrs() error {
+	return fmt.Errorf("serial: unknown baud rate %v", p.customBaud)
+}
+
+func (p *impl) customBaudRate() (int, error) {
+	return 0, nil
+}
+
+// clearDivisor has nothing to undo, as custom divisors are only used on
+// Linux.
+func (p *impl) clearDivisor() error {
+	return nil
+}
@@ -2,6 +2,7 @@
 
 package serial
 
+// #include <sys/ioctl.h>
 // #include <termios.h>
 // #include <unistd.h>
 import "C"
@@ -9,11 +10,14 @@ import "C"
 // fixme: Maybe change to using syscall package + ioctl instead of cgo
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
@@ -31,13 +35,109 @@ type impl struct {
 	f  *os.File
 	fd uintptr
 	st C.struct_termios
+	la lookahead
+	// customBaud is the requested rate when it has no termios speed
+	// constant and is applied by setCustomAttrs instead.
+	customBaud int
+	// divisor is set while the driver runs at a custom divisor of its
+	// base rate, see setDivisorAttrs.
+	divisor bool
+	// dec strips the error marks from the input when ReportErrors or
+	// DetectBreak is set; brk holds back the data after a break.
+	dec markDecoder
+	brk breakQueue
+	// er is the reader returned by ErrorReader, created on first use.
+	er *errorReader
+	// errs counts the marked characters, errBase holds the driver's
+	// counters at open or the last reset.
+	errs    ErrorCounts
+	errBase ErrorCounts
+	// wakeR and wakeW are a pipe written to interrupt a read waiting in
+	// wait, see ReadContext. They are accessed atomically, as Close sets
+	// them to -1 while reads and writes may be waiting.
+	wakeR, wakeW int32
+	// lock is the UUCP lock file held while open, if any.
+	lock string
+	// closed is set atomically by Close, making reads and writes fail
+	// with os.ErrClosed.
+	closed int32
+	stats  counters
+	// readAt and writeAt are the deadlines set by SetReadDeadlineTime and
+	// SetWriteDeadlineTime, which replace the timeouts while not zero.
+	readAt, writeAt time.Time
+	// echo is set atomically during a write in the SuppressTxEcho mode,
+	// making reads drop what they receive.
+	echo int32
+	// dsrFlow is set atomically while the flow control is FlowDSRDTR,
+	// which writes do in software.
+	dsrFlow int32
+	// cancel is set atomically by CancelRead until a read takes it.
+	cancel int32
 }
 
 var _ Port = (*impl)(nil)
 
+// speeds maps the supported baud rates to termios speed values.
+var speeds = map[int]C.speed_t{
+	230400: C.B230400,
+	115200: C.B115200,
+	57600:  C.B57600,
+	38400:  C.B38400,
+	19200:  C.B19200,
+	9600:   C.B9600,
+	4800:   C.B4800,
+	2400:   C.B2400,
+	1200:   C.B1200,
+	600:    C.B600,
+	300:    C.B300,
+	200:    C.B200,
+	150:    C.B150,
+	134:    C.B134,
+	110:    C.B110,
+	75:     C.B75,
+	50:     C.B50,
+}
+
+// openFlags returns the open(2) access flags for mode.
+func openFlags(mode AccessMode) int {
+	switch mode {
+	case ReadOnly:
+		return syscall.O_RDONLY
+	case WriteOnly:
+		return syscall.O_WRONLY
+	}
+
+	return syscall.O_RDWR
+}
+
 func openPort(c Config) (p Port, err error) {
-	f, err := os.OpenFile(c.Name, syscall.O_RDWR|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
-	if err != nil {
+	defer func() {
+		err = portError("open", c.Name, err)
+	}()
+
+	var lock string
+	if c.UseLockFile {
+		// lock before opening, which may already toggle DTR
+		if lock, err = acquireLock(c.Name); err != nil {
+			return
+		}
+
+		defer func() {
+			if err != nil {
+				releaseLock(lock)
+			}
+		}()
+	}
+
+	f, err := os.OpenFile(c.Name, openFlags(c.Mode)|syscall.O_NOCTTY|syscall.O_NONBLOCK, 0666)
+	if errors.Is(err, syscall.EBUSY) {
+		err = fmt.Errorf("%w: %s", ErrPortBusy, c.Name)
+		return
+	} else if pe, ok := err.(*os.PathError); ok {
+		// the PortError says what was opened
+		err = pe.Err
+		return
+	} else if err != nil {
 		return
 	}
 
@@ -47,143 +147,188 @@ func openPort(c Config) (p Port, err error) {
 		}
 	}()
 
+	var wake [2]int
+	if err = unix.Pipe(wake[:]); err != nil {
+		return
+	}
+
 	pt := &impl{
-		c:  &c,
-		f:  f,
-		fd: f.Fd(),
+		c:     &c,
+		f:     f,
+		fd:    f.Fd(),
+		wakeR: int32(wake[0]),
+		wakeW: int32(wake[1]),
+		lock:  lock,
+	}
+	pt.stats.reset()
+
+	defer func() {
+		if err != nil {
+			_ = unix.Close(wake[0])
+			_ = unix.Close(wake[1])
+		}
+	}()
+
+	if err = unix.SetNonblock(wake[0], true); err != nil {
+		return
+	}
+
+	if err = unix.SetNonblock(wake[1], true); err != nil {
+		return
 	}
 
 	if C.isatty(C.int(pt.fd)) != 1 {
-		err = errors.New("serial: file is not a tty")
+		err = fmt.Errorf("serial: %s is not a tty", c.Name)
 		return
 	}
 
-	if _, err = C.tcgetattr(C.int(pt.fd), &pt.st); err != nil {
+	if c.Exclusive {
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, pt.fd, uintptr(unix.TIOCEXCL), 0); errno != 0 {
+			err = errno
+			return
+		}
+	}
+
+	if err = pt.setInitialLine(pt.SetDTR, c.InitialDTR); err != nil {
 		return
 	}
 
-	var speed C.speed_t
-	switch c.Baud {
-	case 230400:
-		speed = C.B230400
-	case 115200:
-		speed = C.B115200
-	case 57600:
-		speed = C.B57600
-	case 38400:
-		speed = C.B38400
-	case 19200:
-		speed = C.B19200
-	case 9600:
-		speed = C.B9600
-	case 4800:
-		speed = C.B4800
-	case 2400:
-		speed = C.B2400
-	case 1200:
-		speed = C.B1200
-	case 600:
-		speed = C.B600
-	case 300:
-		speed = C.B300
-	case 200:
-		speed = C.B200
-	case 150:
-		speed = C.B150
-	case 134:
-		speed = C.B134
-	case 110:
-		speed = C.B110
-	case 75:
-		speed = C.B75
-	case 50:
-		speed = C.B50
-	default:
-		err = fmt.Errorf("serial: unknown baud rate %v", c.Baud)
+	if err = pt.setInitialLine(pt.SetRTS, c.InitialRTS); err != nil {
 		return
 	}
 
-	// by some bizarre input and output speeds set by separate calls
-	if _, err = C.cfsetispeed(&pt.st, speed); err != nil {
+	if _, err = C.tcgetattr(C.int(pt.fd), &pt.st); err != nil {
 		return
 	}
 
-	if _, err = C.cfsetospeed(&pt.st, speed); err != nil {
+	// keep DTR up on close so that the next open does not pulse it
+	if c.NoResetOnOpen || c.NoHangupOnClose {
+		pt.st.c_cflag &= ^C.tcflag_t(C.HUPCL)
+	}
+
+	if err = pt.setSpeed(c.Baud); err != nil {
 		return
 	}
 
-	// Turn off break interrupts, CR->NL, Parity checks, strip, and IXON
-	pt.st.c_iflag &= ^C.tcflag_t(C.BRKINT | C.ICRNL | C.INPCK | C.ISTRIP | C.IXOFF | C.IXON | C.PARMRK)
+	// Turn off break interrupts, CR->NL, Parity checks, strip, and XON/XOFF
+	pt.st.c_iflag &= ^C.tcflag_t(C.IGNBRK | C.BRKINT | C.ICRNL | C.INLCR | C.IGNCR | C.INPCK | C.ISTRIP | C.IXOFF | C.IXON | C.IXANY | C.PARMRK)
 
-	// Select local mode, turn off parity, set to 8 bits
-	pt.st.c_cflag &= ^C.tcflag_t(C.CSIZE | C.PARENB)
+	// Select local mode, unless the modem is to be heeded
 	pt.st.c_cflag |= C.CLOCAL | C.CREAD
+	if c.UseModemControl {
+		pt.st.c_cflag &= ^C.tcflag_t(C.CLOCAL)
+	}
 
-	// databits
-	switch c.Size {
-	case 5:
-		pt.st.c_cflag |= C.CS5
-	case 6:
-		pt.st.c_cflag |= C.CS6
-	case 7:
-		pt.st.c_cflag |= C.CS7
-	case 8:
-		pt.st.c_cflag |= C.CS8
-	default:
-		err = ErrBadSize
+	if err = setSize(&pt.st, c.Size); err != nil {
 		return
 	}
 
-	// Parity settings
-	switch c.Parity {
-	case ParityNone:
-		// default is no parity
-	case ParityOdd:
-		pt.st.c_cflag |= C.PARENB
-		pt.st.c_cflag |= C.PARODD
-	case ParityEven:
-		pt.st.c_cflag |= C.PARENB
-		pt.st.c_cflag &= ^C.tcflag_t(C.PARODD)
-	default:
-		err = ErrBadParity
+	if err = setParity(&pt.st, c.Parity); err != nil {
 		return
 	}
 
-	// Stop bits settings
-	switch c.StopBits {
-	case Stop1:
-		// as is, default is 1 bit
-	case Stop2:
-		pt.st.c_cflag |= C.CSTOPB
-	default:
-		err = ErrBadStopBits
+	if err = setStopBits(&pt.st, c.StopBits); err != nil {
+		return
+	}
+
+	// Select raw mode like cfmakeraw, whatever the inherited settings, so
+	// that no output post-processing touches the data
+	pt.st.c_lflag &= ^C.tcflag_t(C.ICANON | C.ECHO | C.ECHOE | C.ECHOK | C.ECHONL | C.ISIG | C.IEXTEN)
+	pt.st.c_oflag &= ^C.tcflag_t(C.OPOST | C.ONLCR | C.OCRNL | C.ONOCR | C.ONLRET)
+
+	// Translating line endings takes the driver's post-processing
+	setCRLF(&pt.st, c.InputCRLF, c.OutputCRLF)
+
+	// Or line mode, without any editing so the data passes unchanged
+	if c.Canonical {
+		pt.st.c_lflag |= C.ICANON
+		pt.st.c_lflag &= ^C.tcflag_t(C.IEXTEN)
+		pt.st.c_cc[C.VEOF] = C._POSIX_VDISABLE
+		pt.st.c_cc[C.VEOL] = C._POSIX_VDISABLE
+		pt.st.c_cc[C.VERASE] = C._POSIX_VDISABLE
+		pt.st.c_cc[C.VKILL] = C._POSIX_VDISABLE
+	}
+
+	if err = setFlowControl(&pt.st, &c); err != nil {
 		return
 	}
+	pt.setDSRFlow(c.FlowControl)
 
-	// Select raw mode
-	pt.st.c_lflag &= ^C.tcflag_t(C.ICANON | C.ECHO | C.ECHOE | C.ISIG)
-	pt.st.c_oflag &= ^C.tcflag_t(C.OPOST)
+	switch c.parityErrorMode() {
+	case ParityErrorPassThrough:
+		pt.st.c_iflag &= ^C.tcflag_t(C.IGNPAR)
+	case ParityErrorMark:
+		// Check parity and mark bad characters in the input, see markDecoder
+		pt.st.c_iflag &= ^C.tcflag_t(C.IGNPAR)
+		pt.st.c_iflag |= C.INPCK | C.PARMRK
+	case ParityErrorDrop:
+		pt.st.c_iflag |= C.INPCK | C.IGNPAR
+	}
 
-	// Disable RTS/CTS hardware flow control
-	// pt.st.c_cflag &= ^C.tcflag_t(C.CRTSCTS)
+	// A break is marked as an error on a zero character
+	if c.DetectBreak {
+		pt.st.c_iflag &= ^C.tcflag_t(C.IGNBRK)
+		pt.st.c_iflag |= C.PARMRK
+	}
 
 	if err = pt.Flush(); err != nil {
 		return
 	}
 
-	if err = pt.setTimeouts(1, 0); err != nil {
+	if c.RS485.Enabled {
+		if err = pt.SetRS485(c.RS485); err != nil {
+			return
+		}
+	}
+
+	if c.LowLatency {
+		if err = pt.SetLowLatency(true); err != nil {
+			return
+		}
+	}
+
+	if c.RxBufferSize != 0 || c.TxBufferSize != 0 {
+		if err = pt.SetBufferSizes(c.RxBufferSize, c.TxBufferSize); err != nil {
+			return
+		}
+	}
+
+	// VMin and VTime are the posix counterparts
+	if c.ReadIntervalTimeout != 0 || c.ReadTotalTimeoutMultiplier != 0 {
+		err = ErrNotSupported
+		return
+	}
+
+	vMin, vTime := c.VMin, c.VTime
+	if vMin == 0 && vTime == 0 {
+		vMin = 1
+	}
+
+	if c.Canonical {
+		// VMIN and VTIME may share slots with VEOF and VEOL
+		err = pt.setAttrs()
+	} else {
+		err = pt.setTimeouts(vMin, vTime)
+	}
+	if err != nil {
 		return
 	}
 
-	r1, _, e := syscall.Syscall(syscall.SYS_FCNTL,
-		pt.fd,
-		uintptr(syscall.F_SETFL),
-		uintptr(0))
-	if e != 0 || r1 != 0 {
-		err = fmt.Errorf("serial: clearing NONBLOCK syscall error: %s, %d", e, r1)
+	pt.errBase, _ = pt.driverErrorCounts()
+
+	// f.Fd() switched the descriptor to blocking mode; Read and Write
+	// poll for readiness themselves so that deadlines can be honored.
+	if err = unix.SetNonblock(int(pt.fd), true); err != nil {
+		err = fmt.Errorf("serial: setting NONBLOCK on %s: %s", c.Name, err)
 		return
 	}
 
+	if c.UseModemControl {
+		if err = pt.WaitForDCD(c.ReadTimeout); err != nil {
+			return
+		}
+	}
+
 	p = pt
 
 	return
@@ -193,180 +338,1735 @@ func (p *impl) setTimeouts(vMin, vTime uint8) error {
 	p.st.c_cc[C.VMIN] = C.cc_t(vMin)
 	p.st.c_cc[C.VTIME] = C.cc_t(vTime)
 
-	if _, err := C.tcsetattr(C.int(p.fd), C.TCSANOW, &p.st); err != nil {
+	return p.setAttrs()
+}
+
+// setAttrs applies the cached terminal attributes to the port.
+func (p *impl) setAttrs() error {
+	if p.customBaud != 0 {
+		return p.setCustomAttrs()
+	}
+
+	if err := p.clearDivisor(); err != nil {
 		return err
 	}
 
-	return nil
+	return retryEINTR(func() error {
+		_, err := C.tcsetattr(C.int(p.fd), C.TCSANOW, &p.st)
+		return err
+	})
 }
 
-func (p *impl) SetParity(val Parity) error {
+// retryEINTR calls fn again for as long as it fails with EINTR, so that
+// the signals the Go runtime uses for preemption never surface as
+// errors.
+func retryEINTR(fn func() error) error {
+	for {
+		if err := fn(); err != unix.EINTR {
+			return err
+		}
+	}
+}
+
+// SetBaud changes the speed of the open port. Other settings and the
+// DTR/RTS lines are left untouched, and data already received but not
+// yet read stays in the input queue.
+//
+// Rates without a termios constant are supported on Linux, macOS and
+// the BSDs only; on Linux and the BSDs the rate achieved by the driver
+// is reported by GetConfig. Linux drivers lacking BOTHER are given such
+// rates as a custom divisor of their base rate, which B38400 then
+// selects.
+func (p *impl) SetBaud(baud int) error {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 
-	// Parity settings
-	switch val {
-	case ParityNone:
-		// default is no parity
-	case ParityOdd:
-		p.st.c_cflag |= C.PARENB
-		p.st.c_cflag |= C.PARODD
-	case ParityEven:
-		p.st.c_cflag |= C.PARENB
-		p.st.c_cflag &= ^C.tcflag_t(C.PARODD)
-	default:
-		return ErrBadParity
+	if baud <= 0 {
+		return fmt.Errorf("%w: baud rate %v", ErrInvalidArg, baud)
+	}
+
+	st, customBaud := p.st, p.customBaud
+
+	if err := p.setSpeed(baud); err != nil {
+		p.st, p.customBaud = st, customBaud
+		return err
 	}
 
-	if _, err := C.tcsetattr(C.int(p.fd), C.TCSANOW, &p.st); err != nil {
+	if err := p.setAttrs(); err != nil {
+		p.st, p.customBaud = st, customBaud
 		return err
 	}
 
+	p.c.Baud = baud
+
 	return nil
 }
 
-// SetReadDeadline
-func (p *impl) SetReadDeadline(t time.Duration) error {
-	p.c.timeout = t
+// setSpeed sets the baud rate in the cached terminal attributes. Rates
+// without a termios constant are recorded in customBaud for setAttrs.
+func (p *impl) setSpeed(baud int) error {
+	if baud <= 0 {
+		return fmt.Errorf("serial: unknown baud rate %v", baud)
+	}
+
+	speed, ok := speeds[baud]
+	p.customBaud = 0
+	if !ok {
+		// placeholder speed, the real rate is set by setAttrs
+		speed = C.B38400
+		p.customBaud = baud
+	}
+
+	// by some bizarre input and output speeds set by separate calls
+	if _, err := C.cfsetispeed(&p.st, speed); err != nil {
+		return err
+	}
+
+	if _, err := C.cfsetospeed(&p.st, speed); err != nil {
+		return err
+	}
 
 	return nil
 }
 
-func (p *impl) Read(b []byte) (n int, err error) {
-	return p.f.Read(b)
+// Reconfigure applies the baud rate, data size, parity, stop bits and
+// flow control of c, including the XON/XOFF characters, with a single
+// tcsetattr. The port stays open and the control lines are left alone;
+// the other fields of c are ignored. Zero fields take the defaults of
+// OpenPort. If the settings cannot be applied the port keeps its
+// previous ones.
+func (p *impl) Reconfigure(c Config) error {
+	c.setDefaults()
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	st, customBaud := p.st, p.customBaud
+	err := p.setSpeed(c.Baud)
+	if err == nil {
+		err = setSize(&p.st, c.Size)
+	}
+	if err == nil {
+		err = setParity(&p.st, c.Parity)
+	}
+	if err == nil {
+		err = setStopBits(&p.st, c.StopBits)
+	}
+	if err == nil {
+		err = setFlowControl(&p.st, &c)
+	}
+	if err == nil {
+		err = p.setAttrs()
+	}
+
+	if err != nil {
+		p.st, p.customBaud = st, customBaud
+		// the custom rate is set after the termios, which may have
+		// been applied already
+		_ = p.setAttrs()
+		return err
+	}
+
+	p.c.Baud, p.c.Size, p.c.Parity, p.c.StopBits = c.Baud, c.Size, c.Parity, c.StopBits
+	p.c.FlowControl, p.c.XonChar, p.c.XoffChar = c.FlowControl, c.XonChar, c.XoffChar
+	p.setDSRFlow(c.FlowControl)
+
+	return nil
 }
 
-// func (p *impl) Read(b []byte) (n int, err error) {
-// 	remaining := len(b)
-//
-// 	if remaining == 0 {
-// 		return 0, ErrInvalidArg
-// 	}
-//
-// 	defer func() {
-// 		if p.c.DumpRx != nil && n > 0 {
-// 			p.c.DumpRx(b[:n])
-// 		}
-// 	}()
-//
-// 	remainingTimeout := p.c.timeout
-//
-// 	if remainingTimeout == 0 {
-// 		if err = p.setTimeouts(0, 0); err != nil {
-// 			return
-// 		}
-//
-// 		return p.f.Read(b)
-// 	}
-//
-// 	offset := 0
-//
-// 	for remaining > 0 {
-// 		toRead := remaining
-// 		if toRead > 255 {
-// 			toRead = 255
-// 		}
-//
-// 		vMin := uint8(toRead)
-// 		vTime := uint8(0)
-//
-// 		if remainingTimeout < MaxTimeout {
-// 			t := remainingTimeout
-// 			if t >= maxReadTimeout {
-// 				t = maxReadTimeout
-// 			}
-//
-// 			remainingTimeout -= t
-//
-// 			vTime = posixTimeoutValue(t)
-// 		}
-//
-// 		if err = p.setTimeouts(vMin, vTime); err != nil {
-// 			return
-// 		}
-//
-// 		var nRead int
-// 		nRead, err = p.f.Read(b[offset:])
-// 		n += nRead
-// 		remaining -= nRead
-// 		offset += nRead
-// 		if err != nil {
-// 			return
-// 		}
-// 	}
-//
-// 	return
-// }
+// setDSRFlow makes writes wait for DSR if fc is FlowDSRDTR.
+func (p *impl) setDSRFlow(fc FlowControl) {
+	var on int32
+	if fc == FlowDSRDTR {
+		on = 1
+	}
 
-func (p *impl) Write(b []byte) (n int, err error) {
-	if p.c.DumpTx != nil {
-		p.c.DumpTx(b)
+	atomic.StoreInt32(&p.dsrFlow, on)
+}
+
+// setFlowControl sets the flow control mode and characters of c in st.
+func setFlowControl(st *C.struct_termios, c *Config) error {
+	st.c_cc[C.VSTART] = C.cc_t(c.XonChar)
+	st.c_cc[C.VSTOP] = C.cc_t(c.XoffChar)
+
+	switch c.FlowControl {
+	case FlowNone:
+		st.c_cflag &= ^C.tcflag_t(C.CRTSCTS)
+		st.c_iflag &= ^C.tcflag_t(C.IXON | C.IXOFF | C.IXANY)
+	case FlowHardware:
+		st.c_cflag |= C.CRTSCTS
+		st.c_iflag &= ^C.tcflag_t(C.IXON | C.IXOFF | C.IXANY)
+	case FlowSoftware:
+		st.c_cflag &= ^C.tcflag_t(C.CRTSCTS)
+		st.c_iflag |= C.IXON | C.IXOFF | C.IXANY
+	case FlowDSRDTR:
+		// done in software, see write
+		st.c_cflag &= ^C.tcflag_t(C.CRTSCTS)
+		st.c_iflag &= ^C.tcflag_t(C.IXON | C.IXOFF | C.IXANY)
+	default:
+		return ErrBadFlowControl
 	}
 
-	return p.f.Write(b)
+	return nil
 }
 
-// Discards data written to the port but not transmitted,
-// or data received but not read
-func (p *impl) Flush() error {
-	_, err := C.tcflush(C.int(p.f.Fd()), C.TCIOFLUSH)
-	return err
+func (p *impl) SetParity(val Parity) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.applyParity(val); err != nil {
+		return err
+	}
+
+	p.c.Parity = val
+
+	return nil
 }
 
-func (p *impl) Status() (n uint, err error) {
-	var status uint
-	if _, _, errno := unix.Syscall(
-		unix.SYS_IOCTL,
-		p.fd,
-		uintptr(unix.TIOCMGET),
-		uintptr(unsafe.Pointer(&status)),
-	); errno != 0 {
-		return status, errno
-	} else {
-		return status, nil
+// applyParity switches the port to the parity val, with p.mu held.
+func (p *impl) applyParity(val Parity) error {
+	st := p.st
+	if err := setParity(&p.st, val); err != nil {
+		return err
+	}
+
+	if err := p.setAttrs(); err != nil {
+		p.st = st
+		return err
 	}
+
+	return nil
 }
 
-func (p *impl) SetDTR(assert bool) (err error) {
-	req := unix.TIOCMBIS
-	if !assert {
-		req = unix.TIOCMBIC
+// SetStopBits changes the number of stop bits of the open port.
+func (p *impl) SetStopBits(val StopBits) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := checkStopBits(p.c.Size, val); err != nil {
+		return err
 	}
 
-	var m uint = unix.TIOCM_DTR
-	if _, _, errno := unix.Syscall(
-		unix.SYS_IOCTL,
-		p.fd,
-		uintptr(req),
-		uintptr(unsafe.Pointer(&m)),
-	); errno != 0 {
-		return errno
-	} else {
-		return nil
+	st := p.st
+	if err := setStopBits(&p.st, val); err != nil {
+		return err
+	}
+
+	if err := p.setAttrs(); err != nil {
+		p.st = st
+		return err
 	}
+
+	p.c.StopBits = val
+
+	return nil
 }
 
-func (p *impl) SetRTS(assert bool) (err error) {
-	req := unix.TIOCMBIS
-	if !assert {
-		req = unix.TIOCMBIC
+// SetSize changes the number of data bits of the open port.
+func (p *impl) SetSize(val DataSize) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := checkStopBits(val, p.c.StopBits); err != nil {
+		return err
 	}
 
-	var m uint = unix.TIOCM_RTS
-	if _, _, errno := unix.Syscall(
-		unix.SYS_IOCTL,
-		p.fd,
-		uintptr(req),
-		uintptr(unsafe.Pointer(&m)),
-	); errno != 0 {
-		return errno
-	} else {
-		return nil
+	st := p.st
+	if err := setSize(&p.st, val); err != nil {
+		return err
 	}
+
+	if err := p.setAttrs(); err != nil {
+		p.st = st
+		return err
+	}
+
+	p.c.Size = val
+
+	return nil
 }
 
-func (p *impl) Close() (err error) {
-	return p.f.Close()
+func setSize(st *C.struct_termios, val DataSize) error {
+	var size C.tcflag_t
+	switch val {
+	case 5:
+		size = C.CS5
+	case 6:
+		size = C.CS6
+	case 7:
+		size = C.CS7
+	case 8:
+		size = C.CS8
+	default:
+		return ErrBadSize
+	}
+
+	st.c_cflag &= ^C.tcflag_t(C.CSIZE)
+	st.c_cflag |= size
+
+	return nil
+}
+
+// setParity sets the parity mode. Mark and space parity need CMSPAR,
+// which only Linux has.
+func setParity(st *C.struct_termios, val Parity) error {
+	switch val {
+	case ParityNone:
+		st.c_cflag &= ^C.tcflag_t(C.PARENB | C.PARODD | cmspar)
+	case ParityOdd:
+		st.c_cflag |= C.PARENB
+		st.c_cflag |= C.PARODD
+		st.c_cflag &= ^C.tcflag_t(cmspar)
+	case ParityEven:
+		st.c_cflag |= C.PARENB
+		st.c_cflag &= ^C.tcflag_t(C.PARODD | cmspar)
+	case ParityMark, ParitySpace:
+		if cmspar == 0 {
+			return ErrBadParity
+		}
+		st.c_cflag |= C.PARENB | cmspar
+		st.c_cflag &= ^C.tcflag_t(C.PARODD)
+		if val == ParityMark {
+			st.c_cflag |= C.PARODD
+		}
+	default:
+		return ErrBadParity
+	}
+
+	return nil
+}
+
+// setStopBits sets the number of stop bits. termios only distinguishes
+// one from more than one; with 5 data bits CSTOPB selects 1.5 stop bits.
+func setStopBits(st *C.struct_termios, val StopBits) error {
+	switch val {
+	case Stop1:
+		st.c_cflag &= ^C.tcflag_t(C.CSTOPB)
+	case Stop1Half, Stop2:
+		st.c_cflag |= C.CSTOPB
+	default:
+		return ErrBadStopBits
+	}
+
+	return nil
+}
+
+func setCRLF(st *C.struct_termios, in, out CRLFMode) {
+	switch in {
+	case CRToLF:
+		st.c_iflag |= C.ICRNL
+	case LFToCR:
+		st.c_iflag |= C.INLCR
+	case IgnoreCR:
+		st.c_iflag |= C.IGNCR
+	}
+
+	switch out {
+	case CRToLF:
+		st.c_oflag |= C.OPOST | C.OCRNL
+	case LFToCRLF:
+		st.c_oflag |= C.OPOST | C.ONLCR
+	}
+}
+
+// FlowControl reports the flow control mode currently active on the port.
+func (p *impl) FlowControl() (FlowControl, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var st C.struct_termios
+	if _, err := C.tcgetattr(C.int(p.fd), &st); err != nil {
+		return FlowNone, err
+	}
+
+	if st.c_cflag&C.CRTSCTS != 0 {
+		return FlowHardware, nil
+	}
+
+	if st.c_iflag&(C.IXON|C.IXOFF) != 0 {
+		return FlowSoftware, nil
+	}
+
+	// not a terminal setting, see write
+	if p.c.FlowControl == FlowDSRDTR {
+		return FlowDSRDTR, nil
+	}
+
+	return FlowNone, nil
+}
+
+// SendXON transmits the XON character, asking the remote end to resume
+// sending.
+func (p *impl) SendXON() error {
+	_, err := C.tcflow(C.int(p.fd), C.TCION)
+	return err
+}
+
+// SendXOFF transmits the XOFF character, asking the remote end to stop
+// sending.
+func (p *impl) SendXOFF() error {
+	_, err := C.tcflow(C.int(p.fd), C.TCIOFF)
+	return err
+}
+
+// ActualBaud reads back the rate the driver applied, which differs from
+// Config.Baud when the hardware cannot produce it exactly.
+func (p *impl) ActualBaud() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var st C.struct_termios
+	if _, err := C.tcgetattr(C.int(p.fd), &st); err != nil {
+		return 0, err
+	}
+
+	return p.liveBaud(&st)
+}
+
+// liveBaud decodes the rate of the terminal attributes st. p.mu has to be
+// held.
+func (p *impl) liveBaud(st *C.struct_termios) (int, error) {
+	if !p.divisor {
+		speed := C.cfgetospeed(st)
+		for baud, s := range speeds {
+			if s == speed {
+				return baud, nil
+			}
+		}
+	}
+
+	// a custom divisor is selected by the B38400 placeholder
+	return p.customBaudRate()
+}
+
+// GetConfig reads the live terminal attributes and reconstructs the
+// effective configuration of the port.
+func (p *impl) GetConfig() (Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := *p.c
+
+	var st C.struct_termios
+	if _, err := C.tcgetattr(C.int(p.fd), &st); err != nil {
+		return c, err
+	}
+
+	baud, err := p.liveBaud(&st)
+	if err != nil {
+		return c, err
+	}
+	c.Baud = baud
+
+	switch st.c_cflag & C.CSIZE {
+	case C.CS5:
+		c.Size = 5
+	case C.CS6:
+		c.Size = 6
+	case C.CS7:
+		c.Size = 7
+	case C.CS8:
+		c.Size = 8
+	}
+
+	switch {
+	case st.c_cflag&C.PARENB == 0:
+		c.Parity = ParityNone
+	case st.c_cflag&cmspar != 0 && st.c_cflag&C.PARODD != 0:
+		c.Parity = ParityMark
+	case st.c_cflag&cmspar != 0:
+		c.Parity = ParitySpace
+	case st.c_cflag&C.PARODD != 0:
+		c.Parity = ParityOdd
+	default:
+		c.Parity = ParityEven
+	}
+
+	switch {
+	case st.c_cflag&C.CSTOPB == 0:
+		c.StopBits = Stop1
+	case c.Size == 5:
+		c.StopBits = Stop1Half
+	default:
+		c.StopBits = Stop2
+	}
+
+	switch {
+	case st.c_cflag&C.CRTSCTS != 0:
+		c.FlowControl = FlowHardware
+	case st.c_iflag&(C.IXON|C.IXOFF) != 0:
+		c.FlowControl = FlowSoftware
+	case c.FlowControl == FlowDSRDTR:
+	default:
+		c.FlowControl = FlowNone
+	}
+
+	c.XonChar = byte(st.c_cc[C.VSTART])
+	c.XoffChar = byte(st.c_cc[C.VSTOP])
+
+	return c, nil
+}
+
+// SetReadDeadline sets the timeout for subsequent Read calls. A zero
+// duration (or MaxTimeout) blocks until data arrives, a negative one
+// (NoWait) makes Read return the data already received or ErrTimeout
+// without waiting.
+func (p *impl) SetReadDeadline(t time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.c.ReadTimeout, p.readAt = t, time.Time{}
+
+	return nil
+}
+
+// SetWriteDeadline sets the timeout for subsequent Write calls. A zero
+// duration (or MaxTimeout) blocks until all data is written.
+func (p *impl) SetWriteDeadline(t time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.c.writeTimeout, p.writeAt = t, time.Time{}
+
+	return nil
+}
+
+// SetReadDeadlineTime sets an absolute deadline for all subsequent Read
+// calls, like net.Conn, in place of the timeout of SetReadDeadline. Once
+// it has passed, reads return the data already received or ErrTimeout.
+// The zero time removes the deadline, making reads block.
+func (p *impl) SetReadDeadlineTime(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.c.ReadTimeout, p.readAt = 0, t
+
+	return nil
+}
+
+// SetWriteDeadlineTime sets an absolute deadline for all subsequent
+// Write calls like SetReadDeadlineTime does for reads.
+func (p *impl) SetWriteDeadlineTime(t time.Time) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.c.writeTimeout, p.writeAt = 0, t
+
+	return nil
+}
+
+func (p *impl) Read(b []byte) (n int, err error) {
+	return p.read(b, p.readDeadline())
+}
+
+// ReadFull reads exactly len(b) bytes. The read deadline bounds the
+// whole call rather than each underlying read.
+func (p *impl) ReadFull(b []byte) (int, error) {
+	return readFull(p, b)
+}
+
+// ReadAtLeast reads into b until at least min bytes have arrived, like
+// io.ReadAtLeast. The read deadline bounds the whole call; on a shortfall
+// the partial count is returned with ErrTimeout.
+func (p *impl) ReadAtLeast(b []byte, min int) (int, error) {
+	return readAtLeast(p, b, min)
+}
+
+// ReadUntil reads until the first occurrence of delim and returns the
+// data up to and including it. If the read deadline passes first, the
+// bytes read so far are returned along with ErrTimeout. Bytes read past
+// delim are kept for subsequent reads.
+func (p *impl) ReadUntil(delim byte) ([]byte, error) {
+	return p.la.until(delim, p.readDeadline(), p.readRaw)
+}
+
+// ReadChan reads the port from a goroutine, delivering the data in
+// chunks of up to bufSize bytes as it arrives. Each chunk has to be
+// received before the next read; read timeouts are skipped. The first
+// other error is sent on the error channel and both channels are then
+// closed, as they are without an error when the port is closed.
+func (p *impl) ReadChan(bufSize int) (<-chan []byte, <-chan error) {
+	return readChan(p, bufSize)
+}
+
+// ReadChanPool is ReadChan reading into buffers from pool. The chunks
+// received belong to the receiver, which should hand each back with
+// pool.Release once done with it.
+func (p *impl) ReadChanPool(pool *BufferPool) (<-chan []byte, <-chan error) {
+	return readChanFrom(p, pool.Get)
+}
+
+// ReadTimeout reads like Read, but waits at most d instead of the read
+// deadline, which is left untouched. Zero or MaxTimeout waits forever.
+func (p *impl) ReadTimeout(b []byte, d time.Duration) (int, error) {
+	return p.read(b, deadlineAfter(d))
+}
+
+// ReadContext reads into b like Read, but returns ctx.Err() as soon as
+// ctx is canceled or its deadline passes. It must not be called
+// concurrently with other reads.
+func (p *impl) ReadContext(ctx context.Context, b []byte) (int, error) {
+	n, err := readContext(ctx, p, b, p.wake)
+	if ctx.Err() != nil {
+		p.drainWake()
+	}
+
+	return n, err
+}
+
+// wake interrupts a read waiting in wait.
+func (p *impl) wake() {
+	_, _ = unix.Write(int(atomic.LoadInt32(&p.wakeW)), []byte{0})
+}
+
+// CancelRead makes a pending read return ErrReadCanceled, from another
+// goroutine, without closing the port. If no read is waiting for input,
+// the next one that would wait is canceled instead, so that a reader
+// about to block is not missed. Later reads work as usual.
+func (p *impl) CancelRead() error {
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return os.ErrClosed
+	}
+
+	atomic.StoreInt32(&p.cancel, 1)
+	p.wake()
+
+	return nil
+}
+
+// takeCancel reports and clears a cancel requested by CancelRead.
+func (p *impl) takeCancel() bool {
+	if !atomic.CompareAndSwapInt32(&p.cancel, 1, 0) {
+		return false
+	}
+
+	p.drainWake()
+
+	return true
+}
+
+// drainWake empties the wake pipe so that later reads are not
+// interrupted.
+func (p *impl) drainWake() {
+	var buf [16]byte
+	for {
+		if n, err := unix.Read(int(atomic.LoadInt32(&p.wakeR)), buf[:]); n <= 0 || err != nil {
+			return
+		}
+	}
+}
+
+// readDeadline returns the deadline for a read starting now.
+func (p *impl) readDeadline() time.Time {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return deadlineFor(p.readAt, p.c.ReadTimeout)
+}
+
+// writeDeadline returns the deadline for a write starting now. p.mu has
+// to be held.
+func (p *impl) writeDeadline() time.Time {
+	return deadlineFor(p.writeAt, p.c.writeTimeout)
+}
+
+// read reads into b, serving bytes buffered by ReadUntil first.
+func (p *impl) read(b []byte, deadline time.Time) (int, error) {
+	if n := p.la.take(b); n > 0 {
+		return n, nil
+	}
+
+	if (p.c.VMin != 0 || p.c.VTime != 0) && !p.c.Canonical {
+		return p.readVMin(b, deadline)
+	}
+
+	return p.readRaw(b, deadline)
+}
+
+// readVMin emulates the VMIN/VTIME semantics of a blocking termios read,
+// which the descriptor does not provide in non-blocking mode.
+func (p *impl) readVMin(b []byte, deadline time.Time) (n int, err error) {
+	vMin := int(p.c.VMin)
+	if vMin > len(b) {
+		vMin = len(b)
+	}
+	vTime := time.Duration(p.c.VTime) * 100 * time.Millisecond
+
+	// without VMIN the timer runs from the start of the read
+	if vMin == 0 {
+		return p.readRaw(b, earliest(deadline, time.Now().Add(vTime)))
+	}
+
+	// otherwise it is restarted by every byte received
+	n, err = p.readRaw(b, deadline)
+	for err == nil && n < vMin {
+		idle := deadline
+		if vTime > 0 {
+			idle = earliest(deadline, time.Now().Add(vTime))
+		}
+
+		var nn int
+		nn, err = p.readRaw(b[n:], idle)
+		n += nn
+	}
+
+	if n > 0 && err == ErrTimeout {
+		err = nil
+	}
+
+	return
+}
+
+// readRaw waits for data until the deadline passes and reads whatever
+// is available into b. A zero deadline waits forever.
+func (p *impl) readRaw(b []byte, deadline time.Time) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	defer func() {
+		p.received(b[:n])
+	}()
+
+	if !p.brk.empty() {
+		return p.brk.pop(b)
+	}
+
+	if !p.marked() {
+		return p.readFd(b, deadline, nil)
+	}
+
+	var brks []int
+	n, err = p.readFd(b, deadline, func(off int, c byte) {
+		if c == 0 && p.c.DetectBreak {
+			brks = append(brks, off)
+		} else {
+			p.countMark(c)
+		}
+	})
+
+	if err == nil && len(brks) > 0 {
+		p.brk.push(b[:n], brks)
+		return p.brk.pop(b)
+	}
+
+	return n, err
+}
+
+// marked reports whether the input carries PARMRK marks to decode.
+func (p *impl) marked() bool {
+	return p.c.DetectBreak || p.c.parityErrorMode() == ParityErrorMark
+}
+
+// readFd reads from the descriptor into b, waiting until the deadline
+// passes. With mark set, the PARMRK escaping is decoded and mark is
+// called for every character received in error.
+func (p *impl) readFd(b []byte, deadline time.Time, mark func(off int, c byte)) (n int, err error) {
+	if p.c.Mode == WriteOnly {
+		return 0, ErrWriteOnly
+	}
+
+	for {
+		if atomic.LoadInt32(&p.closed) != 0 {
+			return 0, os.ErrClosed
+		}
+
+		n, err = unix.Read(int(p.fd), b)
+		switch {
+		case err == unix.EAGAIN || err == unix.EINTR:
+			if err = p.wait(unix.POLLIN, deadline); err == errInterrupted && p.takeCancel() {
+				return 0, ErrReadCanceled
+			} else if err != nil {
+				return 0, err
+			}
+		case err != nil:
+			return 0, portError("read", p.c.Name, disconnected(err))
+		case n == 0:
+			// a terminal reads end of file once it has been hung up,
+			// which is what removing the device does
+			return 0, portError("read", p.c.Name, disconnected(io.EOF))
+		case atomic.LoadInt32(&p.echo) != 0:
+			// the echo of a half-duplex write, see writeHalfDuplex;
+			// the decoder has to follow the escaping all the same
+			if mark != nil {
+				p.dec.decode(b[:n], nil)
+			}
+		case mark != nil:
+			n = p.dec.decode(b[:n], mark)
+
+			// a read holding only part of a mark decodes to nothing
+			if n > 0 {
+				return n, nil
+			}
+		default:
+			return n, nil
+		}
+	}
+}
+
+// received accounts for data returned by a read.
+func (p *impl) received(b []byte) {
+	p.stats.read(len(b))
+	if p.c.DumpRx != nil && len(b) > 0 {
+		p.c.DumpRx(b)
+	}
+}
+
+// ErrorReader returns a reader of the port that returns a *ParityError
+// or *FrameError in place of every character received in error, at its
+// position in the data, rather than only counting it. Config.ReportErrors
+// or ParityErrorMark has to be set for characters to be checked. With
+// DetectBreak, a break reads as ErrBreak. The reader keeps the data read
+// ahead of the errors, so reads through it should not be mixed with
+// Read.
+func (p *impl) ErrorReader() io.Reader {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.er == nil {
+		p.er = &errorReader{read: p.readMarked, breaks: p.c.DetectBreak}
+	}
+
+	return p.er
+}
+
+// readMarked reads decoded data for the ErrorReader, serving bytes
+// buffered by Read first.
+func (p *impl) readMarked(b []byte, mark func(off int, c byte)) (n int, err error) {
+	if n = p.la.take(b); n > 0 {
+		return n, nil
+	}
+
+	if !p.brk.empty() {
+		return p.brk.pop(b)
+	}
+
+	if !p.marked() {
+		mark = nil
+	} else {
+		next := mark
+		mark = func(off int, c byte) {
+			if c != 0 || !p.c.DetectBreak {
+				p.countMark(c)
+			}
+			next(off, c)
+		}
+	}
+
+	n, err = p.readFd(b, p.readDeadline(), mark)
+	p.received(b[:n])
+
+	return n, err
+}
+
+// countMark records a character received in error. The marks do not say
+// which error occurred; a zero character, which is what a framing error
+// usually reads as, is counted as a framing error and anything else as
+// a parity error.
+func (p *impl) countMark(c byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if c == 0 {
+		p.errs.Frame++
+	} else {
+		p.errs.Parity++
+	}
+}
+
+// ErrorCounts returns the receive errors seen since the port was opened
+// or ResetErrorCounts was called. The driver's counters are used where
+// available; otherwise only the characters marked while ReportErrors is
+// set are counted.
+func (p *impl) ErrorCounts() (ErrorCounts, error) {
+	c, err := p.driverErrorCounts()
+	if err == ErrNotSupported {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		return p.errs, nil
+	} else if err != nil {
+		return ErrorCounts{}, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return ErrorCounts{
+		Frame:         c.Frame - p.errBase.Frame,
+		Parity:        c.Parity - p.errBase.Parity,
+		Overrun:       c.Overrun - p.errBase.Overrun,
+		BufferOverrun: c.BufferOverrun - p.errBase.BufferOverrun,
+	}, nil
+}
+
+// ResetErrorCounts restarts the counts returned by ErrorCounts from zero.
+func (p *impl) ResetErrorCounts() error {
+	c, err := p.driverErrorCounts()
+	if err != nil && err != ErrNotSupported {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.errs, p.errBase = ErrorCounts{}, c
+
+	return nil
+}
+
+// Write returns the number of bytes transferred and ErrTimeout if the
+// write deadline passes before all of b is written.
+func (p *impl) Write(b []byte) (n int, err error) {
+	p.mu.Lock()
+	deadline := p.writeDeadline()
+	p.mu.Unlock()
+
+	if p.c.SuppressTxEcho {
+		return p.writeHalfDuplex(func() (int, error) {
+			return p.write(b, deadline)
+		})
+	}
+
+	return p.write(b, deadline)
+}
+
+// writeHalfDuplex runs write in the SuppressTxEcho mode: RTS is asserted
+// first if TxRTS is set, reads drop their input until the data has been
+// transmitted and TxEchoTail has passed, and the input received by then
+// is discarded before RTS is released.
+func (p *impl) writeHalfDuplex(write func() (int, error)) (n int, err error) {
+	if p.c.TxRTS {
+		if err = p.SetRTS(true); err != nil {
+			return 0, err
+		}
+	}
+
+	atomic.StoreInt32(&p.echo, 1)
+	defer atomic.StoreInt32(&p.echo, 0)
+
+	n, err = write()
+	if derr := p.Drain(); err == nil {
+		err = derr
+	}
+	time.Sleep(p.c.TxEchoTail)
+
+	// unlike FlushInput this keeps what ReadUntil buffered before
+	if ferr := retryEINTR(func() error {
+		_, err := C.tcflush(C.int(p.fd), C.TCIFLUSH)
+		return err
+	}); err == nil {
+		err = ferr
+	}
+
+	if p.c.TxRTS {
+		if rerr := p.SetRTS(false); err == nil {
+			err = rerr
+		}
+	}
+
+	return n, err
+}
+
+// WriteAll writes all of b within the write deadline. Unlike Write, whose
+// count is easily overlooked, it fails with a *ShortWriteError telling
+// how much was left if it cannot; it is the method to send whole frames.
+func (p *impl) WriteAll(b []byte) error {
+	return writeAll(b, p.Write)
+}
+
+// WriteProgress writes b like Write, in chunks of a few KB, calling cb
+// with the number of bytes written so far after each chunk. The write
+// deadline bounds the whole call; on error the count written until then
+// is returned.
+func (p *impl) WriteProgress(b []byte, cb func(written int)) (int, error) {
+	p.mu.Lock()
+	deadline := p.writeDeadline()
+	p.mu.Unlock()
+
+	write := func(chunk []byte) (int, error) {
+		return p.write(chunk, deadline)
+	}
+
+	if p.c.SuppressTxEcho {
+		return p.writeHalfDuplex(func() (int, error) {
+			return writeProgress(b, p.c.MaxWriteChunk, cb, write)
+		})
+	}
+
+	return writeProgress(b, p.c.MaxWriteChunk, cb, write)
+}
+
+// WriteString writes s like Write, within the write deadline.
+func (p *impl) WriteString(s string) (int, error) {
+	return p.Write([]byte(s))
+}
+
+// WriteByte writes the single byte c like Write, within the write
+// deadline.
+func (p *impl) WriteByte(c byte) error {
+	b := [1]byte{c}
+	_, err := p.Write(b[:])
+	return err
+}
+
+// WriteWithAddress sends addr with mark parity followed by data with
+// space parity, the 9-bit addressing of multi-drop buses where the
+// parity bit tells addresses from data. Each part is drained before the
+// parity is switched, and the configured parity is restored afterwards.
+// The write deadline bounds the writes and the drains, and Close ends
+// the sequence with os.ErrClosed. Other writes must not run meanwhile.
+// With SuppressTxEcho the echo of both parts is dropped as for Write.
+// Mark and space parity are only supported on Linux.
+func (p *impl) WriteWithAddress(addr byte, data []byte) error {
+	p.mu.Lock()
+	deadline := p.writeDeadline()
+	p.mu.Unlock()
+
+	if p.c.SuppressTxEcho {
+		_, err := p.writeHalfDuplex(func() (int, error) {
+			return 0, p.writeWithAddress(addr, data, deadline)
+		})
+		return err
+	}
+
+	return p.writeWithAddress(addr, data, deadline)
+}
+
+// writeWithAddress sends the parts of WriteWithAddress.
+func (p *impl) writeWithAddress(addr byte, data []byte, deadline time.Time) (err error) {
+	defer func() {
+		if rerr := p.switchParity(0); err == nil {
+			err = rerr
+		}
+	}()
+
+	for _, part := range []struct {
+		parity Parity
+		b      []byte
+	}{
+		{ParityMark, []byte{addr}},
+		{ParitySpace, data},
+	} {
+		if err = p.switchParity(part.parity); err != nil {
+			return
+		}
+
+		if _, err = p.write(part.b, deadline); err != nil {
+			return
+		}
+
+		if err = p.drainUntil(deadline); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// switchParity applies val, or the configured parity if 0, for
+// WriteWithAddress, which only holds p.mu for the change so that Close
+// is not held up by the writes.
+func (p *impl) switchParity(val Parity) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return os.ErrClosed
+	}
+	if val == 0 {
+		val = p.c.Parity
+	}
+
+	return p.applyParity(val)
+}
+
+// drainUntil waits like Drain for the data written to be transmitted,
+// but polls the output queue so that it gives up with ErrTimeout once
+// the deadline passes, or with os.ErrClosed once the port is closed,
+// where tcdrain would wait for a stopped handshake forever.
+func (p *impl) drainUntil(deadline time.Time) error {
+	for {
+		if atomic.LoadInt32(&p.closed) != 0 {
+			return os.ErrClosed
+		}
+
+		n, err := p.outputQueued()
+		if err != nil {
+			return err
+		} else if n == 0 {
+			// the rest is in the UART, which tcdrain waits out briefly
+			return p.Drain()
+		}
+
+		d := dsrPollInterval
+		if !deadline.IsZero() {
+			left := time.Until(deadline)
+			if left <= 0 {
+				return ErrTimeout
+			} else if left < d {
+				d = left
+			}
+		}
+
+		time.Sleep(d)
+	}
+}
+
+// write writes all of b unless the deadline passes first.
+func (p *impl) write(b []byte, deadline time.Time) (n int, err error) {
+	if p.c.Mode == ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	if p.c.DumpTx != nil {
+		p.c.DumpTx(b)
+	}
+
+	for n < len(b) {
+		if atomic.LoadInt32(&p.closed) != 0 {
+			return n, os.ErrClosed
+		}
+
+		end := len(b)
+		if max := p.c.MaxWriteChunk; max > 0 && n+max < end {
+			end = n + max
+		}
+
+		if atomic.LoadInt32(&p.dsrFlow) != 0 {
+			if err = waitForDSR(p, deadline, p.c.DSRPollInterval); err != nil {
+				return
+			}
+		}
+
+		var wr int
+		wr, err = unix.Write(int(p.fd), b[n:end])
+		if wr > 0 {
+			n += wr
+			p.stats.wrote(wr)
+		}
+
+		switch {
+		case err == unix.EAGAIN || err == unix.EINTR:
+			if err = p.wait(unix.POLLOUT, deadline); err != nil {
+				return
+			}
+		case err != nil:
+			return n, portError("write", p.c.Name, disconnected(err))
+		case n < len(b) && !deadline.IsZero() && !time.Now().Before(deadline):
+			return n, ErrTimeout
+		}
+	}
+
+	return n, nil
+}
+
+// disconnected wraps the errors reported once the device behind the
+// port has been removed in ErrPortDisconnected.
+func disconnected(err error) error {
+	switch err {
+	case io.EOF, unix.EIO, unix.ENODEV, unix.ENXIO:
+		return fmt.Errorf("%w: %v", ErrPortDisconnected, err)
+	}
+
+	return err
+}
+
+// wait blocks until the port is ready for the requested poll events or
+// the deadline passes. A zero deadline waits forever.
+//
+// Waits for input are also ended by the wake pipe, with errInterrupted.
+// Waits for output only watch it for the hangup Close causes, so that a
+// write blocked on a full buffer fails with os.ErrClosed.
+func (p *impl) wait(events int16, deadline time.Time) error {
+	wakeEvents := int16(0)
+	if events&unix.POLLIN != 0 {
+		wakeEvents = unix.POLLIN
+	}
+	fds := []unix.PollFd{
+		{Fd: int32(p.fd), Events: events},
+		{Fd: atomic.LoadInt32(&p.wakeR), Events: wakeEvents},
+	}
+
+	for {
+		if atomic.LoadInt32(&p.closed) != 0 {
+			return os.ErrClosed
+		}
+
+		timeout := -1
+		if !deadline.IsZero() {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return ErrTimeout
+			}
+			timeout = int((remaining + time.Millisecond - 1) / time.Millisecond)
+		}
+
+		n, err := unix.Poll(fds, timeout)
+		switch {
+		case err == unix.EINTR:
+			continue
+		case err != nil:
+			return err
+		case atomic.LoadInt32(&p.closed) != 0:
+			return os.ErrClosed
+		case fds[1].Revents != 0:
+			return errInterrupted
+		case n > 0:
+			return nil
+		}
+	}
+}
+
+// Discards data written to the port but not transmitted,
+// or data received but not read. Use Drain to wait for pending
+// output to be sent instead.
+func (p *impl) Flush() error {
+	p.la.reset()
+	p.brk.reset()
+
+	// p.f.Fd() would put the descriptor back into blocking mode
+	return retryEINTR(func() error {
+		_, err := C.tcflush(C.int(p.fd), C.TCIOFLUSH)
+		return err
+	})
+}
+
+// FlushInput discards data received but not read.
+func (p *impl) FlushInput() error {
+	p.la.reset()
+	p.brk.reset()
+
+	return retryEINTR(func() error {
+		_, err := C.tcflush(C.int(p.fd), C.TCIFLUSH)
+		return err
+	})
+}
+
+// FlushOutput discards data written to the port but not transmitted.
+func (p *impl) FlushOutput() error {
+	return retryEINTR(func() error {
+		_, err := C.tcflush(C.int(p.fd), C.TCOFLUSH)
+		return err
+	})
+}
+
+// FlushInputN discards data received but not read like FlushInput and
+// returns roughly how many bytes that was; data arriving meanwhile may
+// be discarded without being counted.
+func (p *impl) FlushInputN() (int, error) {
+	n, err := p.Available()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, p.FlushInput()
+}
+
+// FlushOutputN discards data written but not transmitted like
+// FlushOutput and returns roughly how many bytes that was.
+func (p *impl) FlushOutputN() (int, error) {
+	n, err := p.outputQueued()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, p.FlushOutput()
+}
+
+// outputQueued returns the number of bytes written but not yet handed
+// to the UART, as reported by ioctl(TIOCOUTQ).
+func (p *impl) outputQueued() (int, error) {
+	var n C.int
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(C.TIOCOUTQ),
+		uintptr(unsafe.Pointer(&n)),
+	); errno != 0 {
+		return 0, errno
+	}
+
+	return int(n), nil
+}
+
+// Drain blocks until all data written to the port has been transmitted,
+// including the contents of the UART shift register. Unlike Flush it
+// does not discard anything.
+func (p *impl) Drain() error {
+	return retryEINTR(func() error {
+		_, err := C.tcdrain(C.int(p.fd))
+		return err
+	})
+}
+
+// Sync waits for written data to be transmitted, like Drain, so that
+// the port can stand in for an *os.File. The port does no buffering of
+// its own that would have to be flushed first. It may be called
+// concurrently with Write.
+func (p *impl) Sync() error {
+	return p.Drain()
+}
+
+// Modem status lines as reported by Status and accepted by
+// WaitForStatusChange. The output lines StatusDTR and StatusRTS are
+// reported by GetModemControl and set by SetModemControl.
+const (
+	StatusCTS uint = unix.TIOCM_CTS
+	StatusDSR uint = unix.TIOCM_DSR
+	StatusDCD uint = unix.TIOCM_CAR
+	StatusRI  uint = unix.TIOCM_RNG
+	StatusDTR uint = unix.TIOCM_DTR
+	StatusRTS uint = unix.TIOCM_RTS
+)
+
+// Available returns the number of bytes received and waiting in the
+// input queue, including those buffered by ReadUntil.
+func (p *impl) Available() (int, error) {
+	var n C.int
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(C.FIONREAD),
+		uintptr(unsafe.Pointer(&n)),
+	); errno != 0 {
+		return 0, errno
+	}
+
+	return int(n) + p.la.len() + p.brk.len(), nil
+}
+
+// Status returns the modem line bitmask reported by ioctl(TIOCMGET), see
+// the Status* constants and the TIOCM_* constants in golang.org/x/sys/unix.
+// CTS, DSR, DCD and RI decode the individual input lines.
+func (p *impl) Status() (n uint, err error) {
+	var status uint
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(unix.TIOCMGET),
+		uintptr(unsafe.Pointer(&status)),
+	); errno != 0 {
+		return status, errno
+	} else {
+		return status, nil
+	}
+}
+
+// ModemStatus returns the state of all modem lines, read with a single
+// TIOCMGET.
+func (p *impl) ModemStatus() (ModemStatus, error) {
+	status, err := p.Status()
+	if err != nil {
+		return ModemStatus{}, err
+	}
+
+	return ModemStatus{
+		CTS: status&StatusCTS != 0,
+		DSR: status&StatusDSR != 0,
+		DCD: status&StatusDCD != 0,
+		RI:  status&StatusRI != 0,
+		DTR: status&unix.TIOCM_DTR != 0,
+		RTS: status&unix.TIOCM_RTS != 0,
+	}, nil
+}
+
+// CTS reports whether Clear To Send is asserted.
+func (p *impl) CTS() (bool, error) {
+	return p.modemLine(StatusCTS)
+}
+
+// DSR reports whether Data Set Ready is asserted.
+func (p *impl) DSR() (bool, error) {
+	return p.modemLine(StatusDSR)
+}
+
+// DCD reports whether Data Carrier Detect is asserted.
+func (p *impl) DCD() (bool, error) {
+	return p.modemLine(StatusDCD)
+}
+
+// RI reports whether Ring Indicator is asserted.
+func (p *impl) RI() (bool, error) {
+	return p.modemLine(StatusRI)
+}
+
+func (p *impl) modemLine(mask uint) (bool, error) {
+	status, err := p.Status()
+	if err != nil {
+		return false, err
+	}
+
+	return status&mask != 0, nil
+}
+
+// setInitialLine applies a LineState at open with the given setter.
+func (p *impl) setInitialLine(set func(bool) error, state LineState) error {
+	switch state {
+	case LineLeave:
+		return nil
+	case LineAssert:
+		return set(true)
+	case LineDeassert:
+		return set(false)
+	default:
+		return ErrInvalidArg
+	}
+}
+
+func (p *impl) SetDTR(assert bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.setLine(unix.TIOCM_DTR, assert)
+}
+
+func (p *impl) SetRTS(assert bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.setLine(unix.TIOCM_RTS, assert)
+}
+
+// setLine asserts or deasserts an output line with TIOCMBIS or
+// TIOCMBIC. p.mu has to be held.
+func (p *impl) setLine(line uint, assert bool) error {
+	req := unix.TIOCMBIS
+	if !assert {
+		req = unix.TIOCMBIC
+	}
+
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(req),
+		uintptr(unsafe.Pointer(&line)),
+	); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// PulseDTR deasserts DTR for the given time, as used to reset many
+// microcontroller boards, and then restores its previous level. Other
+// calls are not held up meanwhile; if the port is closed during the
+// pulse, the line is left alone and os.ErrClosed is returned.
+func (p *impl) PulseDTR(low time.Duration) error {
+	return p.pulse(unix.TIOCM_DTR, low)
+}
+
+// PulseRTS deasserts RTS for the given time like PulseDTR.
+func (p *impl) PulseRTS(low time.Duration) error {
+	return p.pulse(unix.TIOCM_RTS, low)
+}
+
+// pulse deasserts the output line for low and restores it. The lock is
+// not held while the line is low, so that Close and the setters need not
+// wait for the pulse.
+func (p *impl) pulse(line uint, low time.Duration) error {
+	p.mu.Lock()
+	m, err := p.Status()
+	if err == nil {
+		err = p.setLine(line, false)
+	}
+	p.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(low)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return os.ErrClosed
+	}
+
+	if m&line != 0 {
+		return p.setLine(line, true)
+	}
+
+	return nil
+}
+
+// SetDTRRTS sets DTR and RTS together with a single TIOCMSET, so that a
+// device watching both lines never sees only one of them changed.
+func (p *impl) SetDTRRTS(dtr, rts bool) error {
+	var bits uint
+	if dtr {
+		bits |= StatusDTR
+	}
+	if rts {
+		bits |= StatusRTS
+	}
+
+	return p.SetModemControl(bits, StatusDTR|StatusRTS)
+}
+
+// GetModemControl returns the state of all modem lines, inputs and
+// outputs, read with a single TIOCMGET; see the Status* constants.
+func (p *impl) GetModemControl() (uint, error) {
+	return p.Status()
+}
+
+// SetModemControl sets the lines in mask to their state in bits, leaving
+// the others alone, with a single TIOCMGET and TIOCMSET. For example
+// SetModemControl(StatusRTS, StatusDTR|StatusRTS) drops DTR and raises
+// RTS at once. Other TIOCM_* bits of golang.org/x/sys/unix may be given
+// for drivers that have them.
+func (p *impl) SetModemControl(bits, mask uint) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	m, err := p.Status()
+	if err != nil {
+		return err
+	}
+
+	m = m&^mask | bits&mask
+
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(unix.TIOCMSET),
+		uintptr(unsafe.Pointer(&m)),
+	); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+// SendBreak transmits a break condition for the given duration and then
+// restores the normal line state. A zero duration uses the platform
+// default (between 0.25 and 0.5 seconds).
+func (p *impl) SendBreak(d time.Duration) error {
+	if d == 0 {
+		return retryEINTR(func() error {
+			_, err := C.tcsendbreak(C.int(p.fd), 0)
+			return err
+		})
+	}
+
+	if err := p.SetBreak(true); err != nil {
+		return err
+	}
+
+	time.Sleep(d)
+
+	return p.SetBreak(false)
+}
+
+// SetBreak asserts the break condition on the transmit line, or clears
+// it, leaving the line in that state until the next call. SendBreak
+// sends a break of fixed length instead.
+func (p *impl) SetBreak(on bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	req := unix.TIOCSBRK
+	if !on {
+		req = unix.TIOCCBRK
+	}
+
+	if _, _, errno := unix.Syscall(
+		unix.SYS_IOCTL,
+		p.fd,
+		uintptr(req),
+		0,
+	); errno != 0 {
+		return errno
+	}
+
+	return nil
+}
+
+func (p *impl) Close() (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.close()
+}
+
+// close closes the port, with p.mu held. Closing it again fails with
+// os.ErrClosed rather than closing descriptor numbers reused since.
+func (p *impl) close() error {
+	if atomic.LoadInt32(&p.closed) == 1 {
+		return os.ErrClosed
+	}
+
+	atomic.StoreInt32(&p.closed, 1)
+	// the divisor would outlive the port and alter B38400 for others
+	_ = p.clearDivisor()
+	// closing the write end wakes up a read waiting for input
+	_ = unix.Close(int(atomic.SwapInt32(&p.wakeW, -1)))
+	_ = unix.Close(int(atomic.SwapInt32(&p.wakeR, -1)))
+
+	err := p.f.Close()
+	releaseLock(p.lock)
+	p.lock = ""
+
+	return err
+}
+
+// SetBufferSizes is not supported on posix systems, where the size of
+// the tty buffers is fixed by the kernel.
+func (p *impl) SetBufferSizes(rx, tx int) error {
+	return ErrNotSupported
+}
+
+// Stats returns the number of bytes transferred since the port was
+// opened or ResetStats was called. Reopen does not reset them.
+func (p *impl) Stats() Stats {
+	return p.stats.get()
+}
+
+// ResetStats restarts the counts returned by Stats from zero.
+func (p *impl) ResetStats() {
+	p.stats.reset()
+}
+
+// Name returns the device path the port was opened with, Config.Name.
+func (p *impl) Name() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.c.Name
+}
+
+// Fd returns the file descriptor of the port, for ioctls the package
+// does not wrap or for adding it to an event loop. The descriptor is in
+// non-blocking mode and must stay so. Using it bypasses the locking and
+// deadline handling of the port, and it changes with Reopen.
+func (p *impl) Fd() uintptr {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.fd
+}
+
+// SyscallConn returns a syscall.RawConn of the port, implementing
+// syscall.Conn. Its Control runs with the settings locked, like SetBaud
+// and the other setting methods, so its function must not call methods of
+// the port. Its Read and Write wait for the port to become ready between
+// calls of their function, within the read and write deadlines, and are
+// ended by Close and CancelRead like the port's own Read and Write.
+func (p *impl) SyscallConn() (syscall.RawConn, error) {
+	return rawConn{p}, nil
+}
+
+// rawConn is the syscall.RawConn of a posix port.
+type rawConn struct {
+	p *impl
+}
+
+func (c rawConn) Control(f func(fd uintptr)) error {
+	c.p.mu.Lock()
+	defer c.p.mu.Unlock()
+
+	if atomic.LoadInt32(&c.p.closed) != 0 {
+		return os.ErrClosed
+	}
+
+	f(c.p.fd)
+
+	return nil
+}
+
+func (c rawConn) Read(f func(fd uintptr) (done bool)) error {
+	return c.run(f, unix.POLLIN, c.p.readDeadline())
+}
+
+func (c rawConn) Write(f func(fd uintptr) (done bool)) error {
+	c.p.mu.Lock()
+	deadline := c.p.writeDeadline()
+	c.p.mu.Unlock()
+
+	return c.run(f, unix.POLLOUT, deadline)
+}
+
+// run calls f until it reports done, waiting for events on the port in
+// between until the deadline passes.
+func (c rawConn) run(f func(fd uintptr) bool, events int16, deadline time.Time) error {
+	for {
+		if atomic.LoadInt32(&c.p.closed) != 0 {
+			return os.ErrClosed
+		}
+
+		if f(c.p.fd) {
+			return nil
+		}
+
+		if err := c.p.wait(events, deadline); err == errInterrupted && c.p.takeCancel() {
+			return ErrReadCanceled
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// WaitForDCD blocks until Data Carrier Detect is asserted, as when a
+// modem has established a connection, and returns ErrTimeout if this
+// does not happen within timeout. Zero waits as long as it takes.
+func (p *impl) WaitForDCD(timeout time.Duration) error {
+	return waitForDCD(p, timeout)
+}
+
+// Loopback checks the port and its wiring with TX and RX jumpered: it
+// discards pending input, writes pattern and reads it back within
+// timeout. A difference is reported with ErrLoopbackMismatch and the
+// position of the first wrong byte.
+func (p *impl) Loopback(pattern []byte, timeout time.Duration) error {
+	return loopback(p, pattern, timeout)
+}
+
+// CloseGraceful closes the port after waiting up to timeout for the
+// data written to it to be transmitted, unlike Close which may discard
+// it. Zero waits as long as it takes.
+func (p *impl) CloseGraceful(timeout time.Duration) error {
+	return closeGraceful(p, timeout)
+}
+
+// Reopen closes the device and opens it again with the current settings,
+// to recover from a disconnect such as a USB adapter re-enumerating. The
+// port itself stays valid. If the device cannot be opened, for example
+// because it has not reappeared yet, the error is returned and Reopen
+// may be retried. It must not be called while the port is in use by
+// other goroutines.
+func (p *impl) Reopen() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// close first, an exclusive open would fail otherwise
+	_ = p.close()
+	p.fd = ^uintptr(0)
+
+	np, err := openPort(*p.c)
+	if err != nil {
+		return err
+	}
+	n := np.(*impl)
+
+	p.c, p.f, p.fd, p.st, p.customBaud = n.c, n.f, n.fd, n.st, n.customBaud
+	p.divisor = n.divisor
+	atomic.StoreInt32(&p.wakeR, n.wakeR)
+	atomic.StoreInt32(&p.wakeW, n.wakeW)
+	p.lock = n.lock
+	atomic.StoreInt32(&p.closed, 0)
+	p.dec, p.errs, p.errBase = markDecoder{}, ErrorCounts{}, n.errBase
+	p.la.reset()
+	p.brk.reset()
+	if p.er != nil {
+		p.er.reset()
+		p.er.breaks = p.c.DetectBreak
+	}
+
+	return nil
 }
 
 // Converts the timeout values for Linux / POSIX systems
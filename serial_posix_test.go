@@ -0,0 +1,1626 @@
+// +build linux
+
+package serial
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a pseudo-terminal pair and returns the master side along
+// with a Port opened on the slave side. Callers close both.
+func openPty(t testing.TB, c Config) (*os.File, Port) {
+	t.Helper()
+
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	require.NoError(t, err)
+
+	var unlock int32
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, master.Fd(), unix.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); errno != 0 {
+		_ = master.Close()
+		t.Fatal(errno)
+	}
+
+	var n uint32
+	if _, _, errno := unix.Syscall(unix.SYS_IOCTL, master.Fd(), unix.TIOCGPTN, uintptr(unsafe.Pointer(&n))); errno != 0 {
+		_ = master.Close()
+		t.Fatal(errno)
+	}
+
+	if c.Baud == 0 {
+		c.Baud = 115200
+	}
+	c.Name = fmt.Sprintf("/dev/pts/%d", n)
+
+	p, err := OpenPort(c)
+	if err != nil {
+		_ = master.Close()
+		t.Fatal(err)
+	}
+
+	return master, p
+}
+
+func TestReadDeadline(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.SetReadDeadline(100*time.Millisecond))
+
+	buf := make([]byte, 16)
+	start := time.Now()
+	n, err := p.Read(buf)
+	require.Equal(t, ErrTimeout, err)
+	require.Zero(t, n)
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	n, err = p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+}
+
+func TestWriteDeadline(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.SetWriteDeadline(100*time.Millisecond))
+
+	// nobody reads the master side, so the pty buffer fills up
+	buf := make([]byte, 1<<20)
+	n, err := p.Write(buf)
+	require.Equal(t, ErrTimeout, err)
+	require.True(t, n > 0 && n < len(buf), "n = %d", n)
+}
+
+func TestCloseDuringWrite(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_ = p.Close()
+	}()
+
+	// nobody reads the master side, so without a deadline only Close
+	// ends the write
+	_, err := p.Write(make([]byte, 1<<20))
+	require.True(t, errors.Is(err, os.ErrClosed), "got %v", err)
+}
+
+func TestFlowControl(t *testing.T) {
+	for _, fc := range []FlowControl{FlowNone, FlowHardware, FlowSoftware, FlowDSRDTR} {
+		master, p := openPty(t, Config{FlowControl: fc})
+
+		got, err := p.FlowControl()
+		require.NoError(t, err)
+		require.Equal(t, fc, got)
+
+		_ = p.Close()
+		_ = master.Close()
+	}
+}
+
+func TestSendBreak(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	start := time.Now()
+	require.NoError(t, p.SendBreak(50*time.Millisecond))
+	require.True(t, time.Since(start) >= 50*time.Millisecond)
+}
+
+func TestSetBreak(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.SetBreak(true))
+	require.NoError(t, p.SetBreak(false))
+}
+
+func TestListPorts(t *testing.T) {
+	ports, err := ListPorts()
+	require.NoError(t, err)
+
+	for _, port := range ports {
+		_, err := os.Stat(port)
+		require.NoError(t, err)
+	}
+}
+
+func TestGetConfig(t *testing.T) {
+	// ptys always report 8 data bits and no parity, whatever was set
+	master, p := openPty(t, Config{Baud: 9600, StopBits: Stop2})
+	defer master.Close()
+	defer p.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 9600, c.Baud)
+	require.Equal(t, DataSize(8), c.Size)
+	require.Equal(t, ParityNone, c.Parity)
+	require.Equal(t, Stop2, c.StopBits)
+	require.Equal(t, FlowNone, c.FlowControl)
+	require.Equal(t, byte(DefaultXonChar), c.XonChar)
+	require.Equal(t, byte(DefaultXoffChar), c.XoffChar)
+}
+
+func TestXonXoffChars(t *testing.T) {
+	master, p := openPty(t, Config{FlowControl: FlowSoftware, XonChar: 0x01, XoffChar: 0x02})
+	defer master.Close()
+	defer p.Close()
+
+	st, err := unix.IoctlGetTermios(int(p.Fd()), unix.TCGETS)
+	require.NoError(t, err)
+	require.Equal(t, uint8(0x01), st.Cc[unix.VSTART])
+	require.Equal(t, uint8(0x02), st.Cc[unix.VSTOP])
+}
+
+func TestSetBaud(t *testing.T) {
+	master, p := openPty(t, Config{Baud: 9600})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.SetBaud(115200))
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 115200, c.Baud)
+
+	baud, err := p.ActualBaud()
+	require.NoError(t, err)
+	require.Equal(t, 115200, baud)
+
+	require.True(t, errors.Is(p.SetBaud(0), ErrInvalidArg))
+	require.True(t, errors.Is(p.SetBaud(-9600), ErrInvalidArg))
+}
+
+func TestCustomBaud(t *testing.T) {
+	master, p := openPty(t, Config{Baud: 31250})
+	defer master.Close()
+	defer p.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 31250, c.Baud)
+
+	// other attribute changes keep the custom rate
+	require.NoError(t, p.SetStopBits(Stop2))
+	require.NoError(t, p.SetBaud(250000))
+
+	c, err = p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 250000, c.Baud)
+	require.Equal(t, Stop2, c.StopBits)
+
+	baud, err := p.ActualBaud()
+	require.NoError(t, err)
+	require.Equal(t, 250000, baud)
+
+	require.NoError(t, p.SetBaud(9600))
+
+	c, err = p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 9600, c.Baud)
+}
+
+func TestSetStopBits(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.SetStopBits(Stop2))
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, Stop2, c.StopBits)
+
+	require.Equal(t, ErrBadStopBits, p.SetStopBits(3))
+	require.Equal(t, ErrBadSize, p.SetSize(9))
+
+	// 1.5 stop bits require 5 data bits, which do not go with 2
+	require.Equal(t, ErrBadStopBits, p.SetStopBits(Stop1Half))
+	require.Equal(t, ErrBadStopBits, p.SetSize(5))
+}
+
+func TestSetRS485NotSupported(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.Equal(t, ErrNotSupported, p.SetRS485(RS485Config{Enabled: true, RTSOnSend: true}))
+}
+
+func TestAvailable(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	n, err := p.Available()
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	n, err = p.Available()
+	require.NoError(t, err)
+	require.Equal(t, 5, n)
+}
+
+func TestDrain(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	_, err := p.Write([]byte("hello"))
+	require.NoError(t, err)
+	require.NoError(t, p.Drain())
+	require.NoError(t, p.Sync())
+
+	// Flush must leave the port non-blocking so deadlines keep working
+	require.NoError(t, p.Flush())
+	require.NoError(t, p.SetReadDeadline(10*time.Millisecond))
+	_, err = p.Read(make([]byte, 1))
+	require.Equal(t, ErrTimeout, err)
+}
+
+func TestFlushInput(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	_, err := master.Write([]byte("stale"))
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	require.NoError(t, p.FlushInput())
+
+	n, err := p.Available()
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestReadFull(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	go func() {
+		for _, chunk := range []string{"he", "ll", "o"} {
+			_, _ = master.Write([]byte(chunk))
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	buf := make([]byte, 5)
+	n, err := p.ReadFull(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+
+	// the deadline is an overall budget, not a per read timeout
+	require.NoError(t, p.SetReadDeadline(100*time.Millisecond))
+	go func() {
+		for i := 0; i < 10; i++ {
+			_, _ = master.Write([]byte("x"))
+			time.Sleep(30 * time.Millisecond)
+		}
+	}()
+
+	buf = make([]byte, 10)
+	n, err = p.ReadFull(buf)
+	require.Equal(t, ErrTimeout, err)
+	require.True(t, n > 0 && n < len(buf), "n = %d", n)
+}
+
+func TestReadAtLeast(t *testing.T) {
+	master, p := openPty(t, Config{ReadTimeout: 100 * time.Millisecond})
+	defer master.Close()
+	defer p.Close()
+
+	go func() {
+		for _, chunk := range []string{"he", "llo"} {
+			_, _ = master.Write([]byte(chunk))
+			time.Sleep(20 * time.Millisecond)
+		}
+	}()
+
+	buf := make([]byte, 16)
+	n, err := p.ReadAtLeast(buf, 4)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+
+	n, err = p.ReadAtLeast(buf, 1)
+	require.Equal(t, ErrTimeout, err)
+	require.Zero(t, n)
+
+	_, err = p.ReadAtLeast(buf, 17)
+	require.True(t, errors.Is(err, ErrInvalidArg))
+}
+
+func TestReadUntil(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	_, err := master.Write([]byte("$GPGGA,1\r\n$GPRMC,2\r\npartial"))
+	require.NoError(t, err)
+
+	line, err := p.ReadUntil('\n')
+	require.NoError(t, err)
+	require.Equal(t, "$GPGGA,1\r\n", string(line))
+
+	line, err = p.ReadUntil('\n')
+	require.NoError(t, err)
+	require.Equal(t, "$GPRMC,2\r\n", string(line))
+
+	require.NoError(t, p.SetReadDeadline(50*time.Millisecond))
+	line, err = p.ReadUntil('\n')
+	require.Equal(t, ErrTimeout, err)
+	require.Equal(t, "partial", string(line))
+}
+
+func TestReadAfterReadUntil(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	_, err := master.Write([]byte("AT\rOK"))
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+
+	line, err := p.ReadUntil('\r')
+	require.NoError(t, err)
+	require.Equal(t, "AT\r", string(line))
+
+	n, err := p.Available()
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	buf := make([]byte, 2)
+	_, err = p.ReadFull(buf)
+	require.NoError(t, err)
+	require.Equal(t, "OK", string(buf))
+}
+
+func TestSetLowLatencyNotSupported(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.Equal(t, ErrNotSupported, p.SetLowLatency(true))
+}
+
+func TestWaitForStatusChangeNotSupported(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	_, err := p.WaitForStatusChange(StatusDCD|StatusRI, time.Second)
+	require.Equal(t, ErrNotSupported, err)
+}
+
+func TestReportErrors(t *testing.T) {
+	master, p := openPty(t, Config{ReportErrors: true})
+	defer master.Close()
+	defer p.Close()
+
+	// the line discipline doubles 0xFF while marking errors
+	_, err := master.Write([]byte{'a', 0xFF, 'b'})
+	require.NoError(t, err)
+
+	buf := make([]byte, 3)
+	_, err = p.ReadFull(buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{'a', 0xFF, 'b'}, buf)
+
+	counts, err := p.ErrorCounts()
+	require.NoError(t, err)
+	require.Equal(t, ErrorCounts{}, counts)
+	require.NoError(t, p.ResetErrorCounts())
+
+	// ptys keep no driver counters
+	_, err = p.InterruptCounts()
+	require.Equal(t, ErrNotSupported, err)
+}
+
+func TestParityErrorMode(t *testing.T) {
+	for _, tc := range []struct {
+		c     Config
+		iflag uint32
+	}{
+		{Config{}, 0},
+		{Config{ReportErrors: true}, unix.INPCK | unix.PARMRK},
+		{Config{ParityErrorMode: ParityErrorMark}, unix.INPCK | unix.PARMRK},
+		{Config{ParityErrorMode: ParityErrorDrop}, unix.INPCK | unix.IGNPAR},
+		{Config{ReportErrors: true, ParityErrorMode: ParityErrorDrop}, unix.INPCK | unix.IGNPAR},
+	} {
+		master, p := openPty(t, tc.c)
+
+		st, err := unix.IoctlGetTermios(int(p.Fd()), unix.TCGETS)
+		require.NoError(t, err)
+		require.Equal(t, tc.iflag, st.Iflag&(unix.INPCK|unix.PARMRK|unix.IGNPAR))
+
+		require.NoError(t, p.Close())
+		require.NoError(t, master.Close())
+	}
+}
+
+func TestRawMode(t *testing.T) {
+	// a new pty starts out cooked, with ONLCR, ICRNL and echo
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	st, err := unix.IoctlGetTermios(int(p.Fd()), unix.TCGETS)
+	require.NoError(t, err)
+	require.Zero(t, st.Oflag&(unix.OPOST|unix.ONLCR|unix.OCRNL|unix.ONOCR|unix.ONLRET))
+	require.Zero(t, st.Iflag&(unix.IGNBRK|unix.BRKINT|unix.PARMRK|unix.ISTRIP|unix.INLCR|unix.IGNCR|unix.ICRNL|unix.IXON))
+	require.Zero(t, st.Lflag&(unix.ECHO|unix.ECHONL|unix.ICANON|unix.ISIG|unix.IEXTEN))
+	require.Equal(t, uint32(unix.CS8), st.Cflag&(unix.CSIZE|unix.PARENB))
+
+	frame := []byte("\r\n\x00\x7f\x03\x11\x13\xff")
+	_, err = p.Write(frame)
+	require.NoError(t, err)
+	buf := make([]byte, len(frame))
+	_, err = io.ReadFull(master, buf)
+	require.NoError(t, err)
+	require.Equal(t, frame, buf)
+}
+
+func TestCRLF(t *testing.T) {
+	master, p := openPty(t, Config{InputCRLF: CRToLF, OutputCRLF: LFToCRLF})
+	defer master.Close()
+	defer p.Close()
+
+	st, err := unix.IoctlGetTermios(int(p.Fd()), unix.TCGETS)
+	require.NoError(t, err)
+	require.Equal(t, uint32(unix.ICRNL), st.Iflag&(unix.ICRNL|unix.INLCR|unix.IGNCR))
+	require.Equal(t, uint32(unix.OPOST|unix.ONLCR), st.Oflag&(unix.OPOST|unix.ONLCR|unix.OCRNL))
+
+	_, err = master.Write([]byte("a\rb"))
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, err := p.ReadFull(buf[:3])
+	require.NoError(t, err)
+	require.Equal(t, "a\nb", string(buf[:n]))
+
+	_, err = p.WriteString("x\n")
+	require.NoError(t, err)
+	n, err = io.ReadFull(master, buf[:3])
+	require.NoError(t, err)
+	require.Equal(t, "x\r\n", string(buf[:n]))
+}
+
+// BenchmarkIdleRead reports the CPU time spent by reads waiting out
+// their deadline on an idle port, which poll(2) keeps close to zero.
+func BenchmarkIdleRead(b *testing.B) {
+	master, p := openPty(b, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	buf := make([]byte, 16)
+	var before, after unix.Rusage
+	require.NoError(b, unix.Getrusage(unix.RUSAGE_SELF, &before))
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		if _, err := p.ReadTimeout(buf, 10*time.Millisecond); err != ErrTimeout {
+			b.Fatal(err)
+		}
+	}
+
+	b.StopTimer()
+	require.NoError(b, unix.Getrusage(unix.RUSAGE_SELF, &after))
+	cpu := time.Duration(after.Utime.Nano() + after.Stime.Nano() - before.Utime.Nano() - before.Stime.Nano())
+	b.ReportMetric(float64(cpu.Nanoseconds())/float64(b.N), "cpu-ns/op")
+}
+
+func TestReadContext(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	start := time.Now()
+	n, err := p.ReadContext(ctx, make([]byte, 16))
+	require.Equal(t, context.Canceled, err)
+	require.Zero(t, n)
+	require.True(t, time.Since(start) < time.Second)
+
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = p.ReadContext(ctx, make([]byte, 16))
+	require.Equal(t, context.DeadlineExceeded, err)
+
+	// a canceled read does not disturb the next one
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	n, err = p.ReadContext(context.Background(), buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+}
+
+func TestSetSizeTermios(t *testing.T) {
+	var p impl
+
+	for size, flag := range map[DataSize]uint64{5: unix.CS5, 6: unix.CS6, 7: unix.CS7, 8: unix.CS8} {
+		require.NoError(t, setSize(&p.st, size))
+		require.Equal(t, flag, uint64(p.st.c_cflag&unix.CSIZE), "size %d", size)
+	}
+
+	// 7E1, as used by many ASCII terminals
+	require.NoError(t, setSize(&p.st, 7))
+	require.NoError(t, setParity(&p.st, ParityEven))
+	require.Equal(t, uint64(unix.CS7|unix.PARENB), uint64(p.st.c_cflag&(unix.CSIZE|unix.PARENB|unix.PARODD)))
+
+	for _, size := range []DataSize{0, 4, 9} {
+		require.Equal(t, ErrBadSize, setSize(&p.st, size))
+	}
+}
+
+func TestReopen(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.SetBaud(9600))
+	require.NoError(t, p.SetReadDeadline(100*time.Millisecond))
+	require.NoError(t, p.Reopen())
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 9600, c.Baud)
+
+	_, err = master.Write([]byte("hello"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(buf[:n]))
+
+	_, err = p.Read(buf)
+	require.Equal(t, ErrTimeout, err)
+
+	// a port whose device path has gone cannot be reopened, as with a
+	// removed USB adapter; the pts number itself may be reused at once
+	dir, err := ioutil.TempDir("", "serial")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	link := filepath.Join(dir, "ttyGONE")
+	require.NoError(t, os.Symlink(c.Name, link))
+	lp, err := OpenPort(Config{Name: link, Baud: 9600})
+	require.NoError(t, err)
+	defer lp.Close()
+
+	require.NoError(t, os.Remove(link))
+	require.Error(t, lp.Reopen())
+	require.Error(t, lp.Reopen())
+}
+
+func TestSetBufferSizesNotSupported(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.Equal(t, ErrNotSupported, p.SetBufferSizes(65536, 0))
+}
+
+func TestReadIntervalTimeoutNotSupported(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+
+	_, err = OpenPort(Config{Name: c.Name, Baud: 9600, ReadIntervalTimeout: 20 * time.Millisecond})
+	require.Equal(t, ErrNotSupported, err)
+}
+
+func TestNoResetOnOpen(t *testing.T) {
+	master, p := openPty(t, Config{NoResetOnOpen: true})
+	defer master.Close()
+	defer p.Close()
+
+	st, err := unix.IoctlGetTermios(int(p.Fd()), unix.TCGETS)
+	require.NoError(t, err)
+	require.Zero(t, st.Cflag&unix.HUPCL)
+}
+
+func TestNoHangupOnClose(t *testing.T) {
+	master, p := openPty(t, Config{NoHangupOnClose: true})
+	defer master.Close()
+	defer p.Close()
+
+	st, err := unix.IoctlGetTermios(int(p.Fd()), unix.TCGETS)
+	require.NoError(t, err)
+	require.Zero(t, st.Cflag&unix.HUPCL)
+}
+
+func TestUseModemControl(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+
+	st, err := unix.IoctlGetTermios(int(p.Fd()), unix.TCGETS)
+	require.NoError(t, err)
+	require.NotZero(t, st.Cflag&unix.CLOCAL)
+	require.NoError(t, p.Close())
+
+	// a pty has no carrier to wait for
+	c.UseModemControl = true
+	c.ReadTimeout = 20 * time.Millisecond
+	_, err = OpenPort(c)
+	require.Error(t, err)
+}
+
+func TestVMinVTime(t *testing.T) {
+	master, p := openPty(t, Config{VMin: 5, VTime: 1})
+	defer master.Close()
+	defer p.Close()
+
+	go func() {
+		_, _ = master.Write([]byte("abc"))
+		time.Sleep(30 * time.Millisecond)
+		_, _ = master.Write([]byte("de"))
+	}()
+
+	buf := make([]byte, 16)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "abcde", string(buf[:n]))
+
+	// fewer than VMin bytes are returned after VTime of idle line
+	_, err = master.Write([]byte("xy"))
+	require.NoError(t, err)
+
+	start := time.Now()
+	n, err = p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "xy", string(buf[:n]))
+	require.True(t, time.Since(start) >= 100*time.Millisecond)
+}
+
+func TestCanonical(t *testing.T) {
+	master, p := openPty(t, Config{Canonical: true})
+	defer master.Close()
+	defer p.Close()
+
+	// editing and EOF characters are passed through
+	_, err := master.Write([]byte("AT+X\x7f\x04\nOK\n"))
+	require.NoError(t, err)
+
+	buf := make([]byte, 16)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "AT+X\x7f\x04\n", string(buf[:n]))
+
+	n, err = p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "OK\n", string(buf[:n]))
+
+	require.NoError(t, p.SetReadDeadline(50*time.Millisecond))
+	_, err = master.Write([]byte("partial"))
+	require.NoError(t, err)
+	_, err = p.Read(buf)
+	require.Equal(t, ErrTimeout, err)
+}
+
+func TestReadTimeout(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.SetReadDeadline(50*time.Millisecond))
+
+	start := time.Now()
+	_, err := p.ReadTimeout(make([]byte, 1), 150*time.Millisecond)
+	require.Equal(t, ErrTimeout, err)
+	require.True(t, time.Since(start) >= 150*time.Millisecond)
+
+	// the read deadline is unchanged
+	start = time.Now()
+	_, err = p.Read(make([]byte, 1))
+	require.Equal(t, ErrTimeout, err)
+	require.True(t, time.Since(start) < 150*time.Millisecond)
+}
+
+func TestOpenPortWith(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+
+	p, err = OpenPortWith(c.Name, WithBaud(57600), WithStopBits(Stop2), WithReadTimeout(20*time.Millisecond))
+	require.NoError(t, err)
+	defer p.Close()
+	require.Equal(t, c.Name, p.Name())
+
+	c, err = p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 57600, c.Baud)
+	require.Equal(t, Stop2, c.StopBits)
+
+	_, err = p.Read(make([]byte, 1))
+	require.Equal(t, ErrTimeout, err)
+}
+
+func TestOpenPortContext(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err = OpenPortContext(ctx, Config{Name: c.Name, Baud: 9600})
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+
+	cancel()
+	_, err = OpenPortContext(ctx, Config{Name: c.Name, Baud: 9600})
+	require.Equal(t, context.Canceled, err)
+}
+
+func TestPortError(t *testing.T) {
+	_, err := OpenPort(Config{Name: "/dev/does-not-exist", Baud: 9600})
+	require.True(t, errors.Is(err, os.ErrNotExist), "got %v", err)
+
+	var pe *PortError
+	require.True(t, errors.As(err, &pe))
+	require.Equal(t, "open", pe.Op)
+	require.Equal(t, "/dev/does-not-exist", pe.Name)
+	require.Equal(t, "open /dev/does-not-exist: no such file or directory", err.Error())
+
+	// errors of the package are not wrapped
+	require.Equal(t, ErrTimeout, portError("read", "x", ErrTimeout))
+}
+
+func TestConfigReadTimeout(t *testing.T) {
+	master, p := openPty(t, Config{ReadTimeout: 20 * time.Millisecond})
+	defer master.Close()
+	defer p.Close()
+
+	start := time.Now()
+	_, err := p.Read(make([]byte, 1))
+	require.Equal(t, ErrTimeout, err)
+	require.True(t, time.Since(start) >= 20*time.Millisecond)
+}
+
+func TestPortErrorReader(t *testing.T) {
+	master, p := openPty(t, Config{ReportErrors: true, ReadTimeout: time.Second})
+	defer master.Close()
+	defer p.Close()
+
+	// a pty reports no errors, but doubles a literal 0xFF under PARMRK
+	_, err := master.Write([]byte{'a', 0xFF, 'b'})
+	require.NoError(t, err)
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(p.ErrorReader(), buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{'a', 0xFF, 'b'}, buf)
+}
+
+func TestCustomDivisor(t *testing.T) {
+	div, err := customDivisor(115200, 31250)
+	require.NoError(t, err)
+	require.Equal(t, 4, div)
+
+	div, err = customDivisor(1500000, 250000)
+	require.NoError(t, err)
+	require.Equal(t, 6, div)
+
+	_, err = customDivisor(115200, 250000)
+	require.Error(t, err)
+
+	_, err = customDivisor(0, 31250)
+	require.Error(t, err)
+}
+
+func TestAutodetectBaud(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+
+	var tried []int
+	probe := func(p Port) bool {
+		c, err := p.GetConfig()
+		require.NoError(t, err)
+		tried = append(tried, c.Baud)
+		return c.Baud == 57600
+	}
+
+	baud, err := AutodetectBaud(c.Name, nil, probe)
+	require.NoError(t, err)
+	require.Equal(t, 57600, baud)
+	require.Equal(t, []int{9600, 19200, 38400, 57600}, tried)
+
+	_, err = AutodetectBaud(c.Name, []int{1200, 2400}, probe)
+	require.Equal(t, ErrBaudNotDetected, err)
+}
+
+func TestConcurrentSettings(t *testing.T) {
+	master, p := openPty(t, Config{ReadTimeout: 5 * time.Millisecond})
+	defer master.Close()
+	defer p.Close()
+
+	done := make(chan struct{})
+	running := func() bool {
+		select {
+		case <-done:
+			return false
+		default:
+			return true
+		}
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(4)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 16)
+		for running() {
+			_, _ = p.Read(buf)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for running() {
+			_, _ = master.Write([]byte("data"))
+			time.Sleep(time.Millisecond)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; running(); i++ {
+			_ = p.SetParity([]Parity{ParityNone, ParityEven, ParityOdd}[i%3])
+			_ = p.SetReadDeadline(time.Duration(i%5+1) * time.Millisecond)
+			_, _ = p.GetConfig()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; running(); i++ {
+			// ptys have no modem lines, the calls still have to be safe
+			_ = p.SetDTR(i%2 == 0)
+			_ = p.SetDTRRTS(i%2 == 0, i%2 != 0)
+			_ = p.PulseRTS(0)
+		}
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	close(done)
+	wg.Wait()
+
+	require.NoError(t, p.SetParity(ParityNone))
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, ParityNone, c.Parity)
+}
+
+func TestSetReadDeadlineTime(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	deadline := time.Now().Add(30 * time.Millisecond)
+	require.NoError(t, p.SetReadDeadlineTime(deadline))
+
+	buf := make([]byte, 8)
+	_, err := p.Read(buf)
+	require.Equal(t, ErrTimeout, err)
+	require.False(t, time.Now().Before(deadline))
+
+	// the deadline stays passed for later reads, which still return
+	// the data already received
+	start := time.Now()
+	_, err = p.Read(buf)
+	require.Equal(t, ErrTimeout, err)
+	require.True(t, time.Since(start) < 20*time.Millisecond)
+
+	_, err = master.Write([]byte("ok"))
+	require.NoError(t, err)
+	time.Sleep(10 * time.Millisecond)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(buf[:n]))
+
+	require.NoError(t, p.SetWriteDeadlineTime(time.Now().Add(time.Second)))
+	_, err = p.Write([]byte("hi"))
+	require.NoError(t, err)
+
+	// the zero time blocks again
+	require.NoError(t, p.SetReadDeadlineTime(time.Time{}))
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		_, _ = master.Write([]byte("x"))
+	}()
+	n, err = p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "x", string(buf[:n]))
+}
+
+func TestSuppressTxEcho(t *testing.T) {
+	master, p := openPty(t, Config{SuppressTxEcho: true, TxEchoTail: 20 * time.Millisecond, ReadTimeout: time.Second})
+	defer master.Close()
+	defer p.Close()
+
+	// the other end echoes the request, as a half-duplex line does, and
+	// replies after a while
+	go func() {
+		buf := make([]byte, 8)
+		n, err := master.Read(buf)
+		if err != nil {
+			return
+		}
+		_, _ = master.Write(buf[:n])
+		time.Sleep(100 * time.Millisecond)
+		_, _ = master.Write([]byte("reply\n"))
+	}()
+
+	// a read pending during the write must not see the echo either
+	type result struct {
+		b   []byte
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		b, err := p.ReadUntil('\n')
+		done <- result{b, err}
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	_, err := p.Write([]byte("req\n"))
+	require.NoError(t, err)
+
+	r := <-done
+	require.NoError(t, r.err)
+	require.Equal(t, "reply\n", string(r.b))
+}
+
+func TestMaxWriteChunk(t *testing.T) {
+	master, p := openPty(t, Config{MaxWriteChunk: 3})
+	defer master.Close()
+	defer p.Close()
+
+	n, err := p.Write([]byte("0123456789"))
+	require.NoError(t, err)
+	require.Equal(t, 10, n)
+
+	buf := make([]byte, 10)
+	_, err = io.ReadFull(master, buf)
+	require.NoError(t, err)
+	require.Equal(t, "0123456789", string(buf))
+
+	// the deadline is checked after every chunk
+	require.NoError(t, p.SetWriteDeadlineTime(time.Now()))
+	n, err = p.Write([]byte("0123456789"))
+	require.Equal(t, ErrTimeout, err)
+	require.Equal(t, 3, n)
+}
+
+func TestCloseTwice(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	require.NoError(t, p.Close())
+
+	// the descriptor numbers of the wake pipe are reused by the next open
+	r, w, err := os.Pipe()
+	require.NoError(t, err)
+	defer r.Close()
+	defer w.Close()
+
+	require.Equal(t, os.ErrClosed, p.Close())
+
+	_, err = w.Write([]byte("x"))
+	require.NoError(t, err)
+	buf := make([]byte, 1)
+	_, err = r.Read(buf)
+	require.NoError(t, err)
+}
+
+func TestCloseGraceful(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	_, err := p.Write([]byte("shutdown\n"))
+	require.NoError(t, err)
+	require.NoError(t, p.CloseGraceful(time.Second))
+
+	buf := make([]byte, 16)
+	n, err := master.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "shutdown\n", string(buf[:n]))
+}
+
+func TestPortDisconnected(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer p.Close()
+
+	// the slave side of a pty is hung up once the master is gone, the
+	// same as a removed USB adapter
+	require.NoError(t, master.Close())
+
+	_, err := p.Read(make([]byte, 1))
+	require.True(t, errors.Is(err, ErrPortDisconnected), "got %v", err)
+
+	_, err = p.Write([]byte("x"))
+	require.True(t, errors.Is(err, ErrPortDisconnected), "got %v", err)
+}
+
+func TestReadOutcomes(t *testing.T) {
+	master, p := openPty(t, Config{ReadTimeout: 50 * time.Millisecond})
+	defer master.Close()
+	defer p.Close()
+
+	buf := make([]byte, 16)
+
+	_, err := master.Write([]byte("ok"))
+	require.NoError(t, err)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(buf[:n]))
+
+	// silence is a timeout, not the end of the data
+	n, err = p.Read(buf)
+	require.Zero(t, n)
+	require.Equal(t, ErrTimeout, err)
+	require.False(t, errors.Is(err, io.EOF) || errors.Is(err, ErrPortDisconnected))
+
+	// closing or unplugging ends a blocked read with no timeout
+	for _, end := range []struct {
+		do   func(master *os.File, p Port) error
+		want error
+	}{
+		{func(_ *os.File, p Port) error { return p.Close() }, os.ErrClosed},
+		{func(master *os.File, _ Port) error { return master.Close() }, ErrPortDisconnected},
+	} {
+		master, p := openPty(t, Config{})
+
+		time.AfterFunc(50*time.Millisecond, func() { _ = end.do(master, p) })
+		_, err = p.Read(buf)
+		require.True(t, errors.Is(err, end.want), "got %v", err)
+		require.NotEqual(t, ErrTimeout, err)
+
+		_ = p.Close()
+		_ = master.Close()
+	}
+}
+
+func TestSyscallConn(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	var _ syscall.Conn = p
+	raw, err := p.SyscallConn()
+	require.NoError(t, err)
+
+	var fd uintptr
+	require.NoError(t, raw.Control(func(f uintptr) { fd = f }))
+	require.Equal(t, p.Fd(), fd)
+
+	time.AfterFunc(20*time.Millisecond, func() { _, _ = master.Write([]byte("raw")) })
+	buf := make([]byte, 16)
+	var n int
+	require.NoError(t, raw.Read(func(fd uintptr) bool {
+		n, err = unix.Read(int(fd), buf)
+		return err != unix.EAGAIN
+	}))
+	require.NoError(t, err)
+	require.Equal(t, "raw", string(buf[:n]))
+
+	require.NoError(t, p.SetReadDeadline(50*time.Millisecond))
+	require.Equal(t, ErrTimeout, raw.Read(func(uintptr) bool { return false }))
+
+	require.NoError(t, p.Close())
+	require.Equal(t, os.ErrClosed, raw.Control(func(uintptr) {}))
+}
+
+func TestAccessMode(t *testing.T) {
+	buf := make([]byte, 16)
+
+	master, p := openPty(t, Config{Mode: ReadOnly})
+	flags, err := unix.FcntlInt(p.Fd(), unix.F_GETFL, 0)
+	require.NoError(t, err)
+	require.Equal(t, unix.O_RDONLY, flags&unix.O_ACCMODE)
+
+	_, err = p.Write([]byte("x"))
+	require.Equal(t, ErrReadOnly, err)
+	_, err = master.Write([]byte("in"))
+	require.NoError(t, err)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "in", string(buf[:n]))
+
+	require.NoError(t, p.Close())
+	require.NoError(t, master.Close())
+
+	master, p = openPty(t, Config{Mode: WriteOnly})
+	defer master.Close()
+	defer p.Close()
+
+	flags, err = unix.FcntlInt(p.Fd(), unix.F_GETFL, 0)
+	require.NoError(t, err)
+	require.Equal(t, unix.O_WRONLY, flags&unix.O_ACCMODE)
+
+	_, err = p.Read(buf)
+	require.Equal(t, ErrWriteOnly, err)
+	_, err = p.Write([]byte("out"))
+	require.NoError(t, err)
+	n, err = io.ReadFull(master, buf[:3])
+	require.NoError(t, err)
+	require.Equal(t, "out", string(buf[:n]))
+}
+
+func TestStandardBauds(t *testing.T) {
+	bauds := StandardBauds()
+	require.Contains(t, bauds, 9600)
+	require.Contains(t, bauds, 115200)
+	require.True(t, sort.IntsAreSorted(bauds))
+
+	require.True(t, IsStandardBaud(9600))
+	require.False(t, IsStandardBaud(250000))
+}
+
+func TestUseLockFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(d string) { lockDir = d }(lockDir)
+	lockDir = dir
+
+	master, p := openPty(t, Config{UseLockFile: true})
+	defer master.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	lock := lockPath(c.Name)
+
+	b, err := ioutil.ReadFile(lock)
+	require.NoError(t, err)
+	require.Equal(t, fmt.Sprintf("%10d\n", os.Getpid()), string(b))
+
+	_, err = OpenPort(Config{Name: c.Name, Baud: 115200, UseLockFile: true})
+	require.True(t, errors.Is(err, ErrPortBusy), "got %v", err)
+
+	require.NoError(t, p.Close())
+	_, err = os.Stat(lock)
+	require.True(t, os.IsNotExist(err))
+
+	// a lock left by a process that no longer exists is taken over
+	require.NoError(t, ioutil.WriteFile(lock, []byte("2147483646\n"), 0644))
+	p, err = OpenPort(Config{Name: c.Name, Baud: 115200, UseLockFile: true})
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+}
+
+func TestReadChan(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	data, errc := p.ReadChan(64)
+
+	_, err := master.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.Equal(t, []byte("ping"), <-data)
+
+	require.NoError(t, p.Close())
+	_, ok := <-data
+	require.False(t, ok)
+	require.NoError(t, <-errc)
+}
+
+func TestReconfigure(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.Reconfigure(Config{Baud: 9600, StopBits: Stop2, FlowControl: FlowSoftware}))
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 9600, c.Baud)
+	require.Equal(t, Stop2, c.StopBits)
+
+	fc, err := p.FlowControl()
+	require.NoError(t, err)
+	require.Equal(t, FlowSoftware, fc)
+
+	require.Equal(t, ErrBadSize, p.Reconfigure(Config{Baud: 19200, Size: 9}))
+
+	c, err = p.GetConfig()
+	require.NoError(t, err)
+	require.Equal(t, 9600, c.Baud)
+}
+
+func TestRetryEINTR(t *testing.T) {
+	calls := 0
+	err := retryEINTR(func() error {
+		if calls++; calls < 3 {
+			return unix.EINTR
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, 3, calls)
+
+	require.Equal(t, unix.EIO, retryEINTR(func() error { return unix.EIO }))
+}
+
+func TestOpenSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(d string) { lockDir = d }(lockDir)
+	lockDir = dir
+
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	c, err := p.GetConfig()
+	require.NoError(t, err)
+	require.NoError(t, p.Close())
+
+	// as udev links /dev/serial/by-id/usb-FTDI_... to ../../ttyUSB0
+	link := filepath.Join(dir, "usb-Test_Adapter-if00-port0")
+	require.NoError(t, os.Symlink(c.Name, link))
+
+	p, err = OpenPort(Config{Name: link, Baud: 115200, UseLockFile: true})
+	require.NoError(t, err)
+	defer p.Close()
+	require.Equal(t, link, p.Name())
+
+	_, err = os.Stat(filepath.Join(dir, "LCK.."+filepath.Base(c.Name)))
+	require.NoError(t, err)
+}
+
+func TestListPortsByID(t *testing.T) {
+	ports, err := ListPortsByID()
+	require.NoError(t, err)
+	for _, port := range ports {
+		require.True(t, strings.HasPrefix(port, "/dev/serial/by-id/"), port)
+	}
+}
+
+func TestDetailedPorts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "serial")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(d string) { sysClassTTY = d }(sysClassTTY)
+	sysClassTTY = filepath.Join(dir, "class", "tty")
+
+	// a USB adapter: the tty hangs off an interface of the USB device
+	usb := filepath.Join(dir, "devices", "usb1", "1-1")
+	iface := filepath.Join(usb, "1-1:1.0")
+	require.NoError(t, os.MkdirAll(iface, 0755))
+	for attr, value := range map[string]string{
+		"idVendor": "0403\n", "idProduct": "6001\n", "serial": "A9XK3L2Q\n",
+		"manufacturer": "FTDI\n", "product": "FT232R USB UART\n",
+	} {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(usb, attr), []byte(value), 0644))
+	}
+
+	pnp := filepath.Join(dir, "devices", "pnp0", "00:01")
+	require.NoError(t, os.MkdirAll(pnp, 0755))
+	require.NoError(t, os.Symlink(filepath.Join(dir, "drivers", "serial"), filepath.Join(pnp, "driver")))
+
+	for name, device := range map[string]string{"ttyUSB0": iface, "ttyS0": pnp} {
+		require.NoError(t, os.MkdirAll(filepath.Join(sysClassTTY, name), 0755))
+		require.NoError(t, os.Symlink(device, filepath.Join(sysClassTTY, name, "device")))
+	}
+
+	ports, err := DetailedPorts()
+	require.NoError(t, err)
+	require.Equal(t, []PortInfo{
+		{Name: "/dev/ttyS0", Description: "serial"},
+		{Name: "/dev/ttyUSB0", Description: "FT232R USB UART", IsUSB: true, VID: 0x0403, PID: 0x6001,
+			SerialNumber: "A9XK3L2Q", Manufacturer: "FTDI"},
+	}, ports)
+
+	_, err = OpenByUSB(0x0403, 0x6002, "", Config{})
+	require.True(t, errors.Is(err, ErrPortNotFound), "got %v", err)
+}
+
+func TestSetParityMarkSpace(t *testing.T) {
+	var p impl
+
+	require.NoError(t, setParity(&p.st, ParityMark))
+	require.Equal(t, uint64(unix.PARENB|unix.PARODD|unix.CMSPAR), uint64(p.st.c_cflag&(unix.PARENB|unix.PARODD|unix.CMSPAR)))
+
+	require.NoError(t, setParity(&p.st, ParitySpace))
+	require.Equal(t, uint64(unix.PARENB|unix.CMSPAR), uint64(p.st.c_cflag&(unix.PARENB|unix.PARODD|unix.CMSPAR)))
+
+	require.NoError(t, setParity(&p.st, ParityEven))
+	require.Equal(t, uint64(unix.PARENB), uint64(p.st.c_cflag&(unix.PARENB|unix.PARODD|unix.CMSPAR)))
+}
+
+func TestWriteWithAddress(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.WriteWithAddress(0x42, []byte{1, 2, 3}))
+
+	// the address and the data are sent separately
+	buf := make([]byte, 4)
+	_, err := io.ReadFull(master, buf)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x42, 1, 2, 3}, buf)
+}
+
+func TestWriteWithAddressSuppressTxEcho(t *testing.T) {
+	master, p := openPty(t, Config{SuppressTxEcho: true, TxEchoTail: 20 * time.Millisecond, ReadTimeout: time.Second})
+	defer master.Close()
+	defer p.Close()
+
+	// the other end echoes the address and the data, then replies
+	go func() {
+		buf := make([]byte, 8)
+		for got := 0; got < 4; {
+			n, err := master.Read(buf)
+			if err != nil {
+				return
+			}
+			_, _ = master.Write(buf[:n])
+			got += n
+		}
+		time.Sleep(100 * time.Millisecond)
+		_, _ = master.Write([]byte("reply\n"))
+	}()
+
+	require.NoError(t, p.WriteWithAddress(0x42, []byte{1, 2, 3}))
+
+	b, err := p.ReadUntil('\n')
+	require.NoError(t, err)
+	require.Equal(t, "reply\n", string(b))
+}
+
+func TestCloseDuringWriteWithAddress(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		// nobody reads the master side, so the data part blocks
+		done <- p.WriteWithAddress(0x42, make([]byte, 1<<20))
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, p.Close())
+
+	err := <-done
+	require.True(t, errors.Is(err, os.ErrClosed), "got %v", err)
+}
+
+func TestStats(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	_, err := p.Write([]byte("ping"))
+	require.NoError(t, err)
+	_, err = master.Write([]byte("pong!"))
+	require.NoError(t, err)
+	_, err = p.ReadFull(make([]byte, 5))
+	require.NoError(t, err)
+
+	s := p.Stats()
+	require.Equal(t, uint64(4), s.BytesWritten)
+	require.Equal(t, uint64(5), s.BytesRead)
+
+	p.ResetStats()
+	require.Zero(t, p.Stats().BytesWritten)
+}
+
+func TestReadNoWait(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	require.NoError(t, p.SetReadDeadline(NoWait))
+
+	start := time.Now()
+	_, err := p.Read(make([]byte, 4))
+	require.Equal(t, ErrTimeout, err)
+	require.True(t, time.Since(start) < 50*time.Millisecond)
+
+	_, err = master.Write([]byte("ok"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	buf := make([]byte, 4)
+	n, err := p.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(buf[:n]))
+}
+
+func TestWriteStringByte(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	n, err := p.WriteString("AT")
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	require.NoError(t, p.WriteByte('\r'))
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(master, buf)
+	require.NoError(t, err)
+	require.Equal(t, "AT\r", string(buf))
+}
+
+func TestFlushInputN(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	_, err := master.Write([]byte("garbage"))
+	require.NoError(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	n, err := p.FlushInputN()
+	require.NoError(t, err)
+	require.Equal(t, 7, n)
+
+	n, err = p.Available()
+	require.NoError(t, err)
+	require.Zero(t, n)
+
+	_, err = p.FlushOutputN()
+	require.NoError(t, err)
+}
+
+func TestLoopback(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	// echo everything back, flipping the last byte of the second pattern
+	go func() {
+		buf := make([]byte, 64)
+		for {
+			n, err := master.Read(buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "bad" {
+				buf[2] = 'x'
+			}
+			_, _ = master.Write(buf[:n])
+		}
+	}()
+
+	require.NoError(t, p.Loopback([]byte("hello"), time.Second))
+
+	err := p.Loopback([]byte("bad"), time.Second)
+	require.True(t, errors.Is(err, ErrLoopbackMismatch), "got %v", err)
+	require.Contains(t, err.Error(), "byte 2")
+}
+
+func TestWaitReadable(t *testing.T) {
+	master1, p1 := openPty(t, Config{})
+	defer master1.Close()
+	defer p1.Close()
+
+	master2, p2 := openPty(t, Config{})
+	defer master2.Close()
+	defer p2.Close()
+
+	ports := []Port{p1, p2}
+
+	_, err := WaitReadable(ports, 20*time.Millisecond)
+	require.Equal(t, ErrTimeout, err)
+
+	time.AfterFunc(10*time.Millisecond, func() {
+		_, _ = master2.Write([]byte("x"))
+	})
+
+	ready, err := WaitReadable(ports, time.Second)
+	require.NoError(t, err)
+	require.Equal(t, []int{1}, ready)
+
+	_, err = WaitReadable([]Port{NewMockPort()}, NoWait)
+	require.True(t, errors.Is(err, ErrNotSupported))
+}
+
+func TestFIFOTrigger(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	// ptys have no FIFO
+	_, err := p.FIFOTrigger()
+	require.Equal(t, ErrNotSupported, err)
+	require.Equal(t, ErrNotSupported, p.SetFIFOTrigger(1))
+
+	dir, err := ioutil.TempDir("", "serial")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	defer func(d string) { sysClassTTY = d }(sysClassTTY)
+	sysClassTTY = dir
+
+	attr := filepath.Join(dir, filepath.Base(p.Name()))
+	require.NoError(t, os.Mkdir(attr, 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(attr, "rx_trig_bytes"), []byte("8\n"), 0644))
+
+	level, err := p.FIFOTrigger()
+	require.NoError(t, err)
+	require.Equal(t, 8, level)
+
+	require.NoError(t, p.SetFIFOTrigger(4))
+	level, err = p.FIFOTrigger()
+	require.NoError(t, err)
+	require.Equal(t, 4, level)
+
+	require.True(t, errors.Is(p.SetFIFOTrigger(0), ErrInvalidArg))
+}
+
+func TestCancelRead(t *testing.T) {
+	master, p := openPty(t, Config{})
+	defer master.Close()
+	defer p.Close()
+
+	time.AfterFunc(20*time.Millisecond, func() { _ = p.CancelRead() })
+	_, err := p.Read(make([]byte, 8))
+	require.Equal(t, ErrReadCanceled, err)
+
+	// the port reads on afterwards
+	_, err = master.Write([]byte("ok"))
+	require.NoError(t, err)
+	buf := make([]byte, 2)
+	_, err = p.ReadFull(buf)
+	require.NoError(t, err)
+	require.Equal(t, "ok", string(buf))
+
+	// a cancel before the read is not lost
+	require.NoError(t, p.CancelRead())
+	_, err = p.Read(buf)
+	require.Equal(t, ErrReadCanceled, err)
+	_, err = p.ReadTimeout(buf, 10*time.Millisecond)
+	require.Equal(t, ErrTimeout, err)
+}
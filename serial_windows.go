@@ -3,26 +3,80 @@
 package serial
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"math"
 	"os"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unsafe"
 )
 
 type impl struct {
+	c  *Config
 	f  *os.File
 	fd syscall.Handle
+	// mu serializes setting changes and guards the settings in c; tm
+	// guards the timeouts in c, which reads narrow for their duration.
+	// Locks are taken in the order mu, cl, rl, wl, tm.
+	mu sync.Mutex
+	tm sync.Mutex
 	rl sync.Mutex
 	wl sync.Mutex
 	ro *syscall.Overlapped
 	wo *syscall.Overlapped
+	la lookahead
+	// errs accumulates the error flags reported by ClearCommError, brk
+	// is set when it reported a break not yet returned by a read.
+	em   sync.Mutex
+	errs ErrorCounts
+	brk  bool
+	// dtr and rts are the output levels last set, which Windows does not
+	// report.
+	cl  sync.Mutex
+	dtr bool
+	rts bool
+	// closed is set atomically by Close, making reads and writes fail
+	// with os.ErrClosed.
+	closed int32
+	// cancel is set atomically by CancelRead until a read takes it.
+	cancel int32
+	stats  counters
+	// readAt and writeAt are the deadlines set by SetReadDeadlineTime and
+	// SetWriteDeadlineTime, which replace the timeouts while not zero.
+	// They are guarded by tm.
+	readAt, writeAt time.Time
+	// readSet and writeSet are the timeouts currently applied with
+	// SetCommTimeouts, which may be narrowed from those in c. They are
+	// guarded by tm, so that reads and writes each change only their own.
+	readSet, writeSet time.Duration
 }
 
 var _ Port = (*impl)(nil)
 
+// speeds maps the baud rates with a CBR_* constant to their DCB value.
+// The driver may accept other rates as well.
+var speeds = map[int]uint32{
+	256000: 256000,
+	128000: 128000,
+	115200: 115200,
+	57600:  57600,
+	38400:  38400,
+	19200:  19200,
+	14400:  14400,
+	9600:   9600,
+	4800:   4800,
+	2400:   2400,
+	1200:   1200,
+	600:    600,
+	300:    300,
+	110:    110,
+}
+
 type structDCB struct {
 	DCBlength  uint32
 	BaudRate   uint32
@@ -41,144 +95,1521 @@ type structDCB struct {
 	wReserved1 uint16
 }
 
-type structTimeouts struct {
-	ReadIntervalTimeout         uint32
-	ReadTotalTimeoutMultiplier  uint32
-	ReadTotalTimeoutConstant    uint32
-	WriteTotalTimeoutMultiplier uint32
-	WriteTotalTimeoutConstant   uint32
+type structComstat struct {
+	flags    uint32
+	cbInQue  uint32
+	cbOutQue uint32
+}
+
+type structTimeouts struct {
+	ReadIntervalTimeout         uint32
+	ReadTotalTimeoutMultiplier  uint32
+	ReadTotalTimeoutConstant    uint32
+	WriteTotalTimeoutMultiplier uint32
+	WriteTotalTimeoutConstant   uint32
+}
+
+// devicePath returns the Win32 device path of the port name. Names such
+// as COM10 and above can only be opened as \\.\COM10, and the prefix is
+// harmless for the others; names that already are a path are kept.
+func devicePath(name string) string {
+	if name == "" || strings.HasPrefix(name, `\\`) || strings.HasPrefix(name, "//") {
+		return name
+	}
+
+	return `\\.\` + name
+}
+
+// desiredAccess returns the CreateFile access rights for mode.
+func desiredAccess(mode AccessMode) uint32 {
+	switch mode {
+	case ReadOnly:
+		return syscall.GENERIC_READ
+	case WriteOnly:
+		return syscall.GENERIC_WRITE
+	}
+
+	return syscall.GENERIC_READ | syscall.GENERIC_WRITE
+}
+
+// checkSupported fails with ErrNotSupported if c asks for settings that
+// Windows ports lack, so that the open fails before CreateFile asserts
+// DTR and resets the device.
+func checkSupported(c *Config) error {
+	if c.RS485.Enabled || c.LowLatency || c.VMin != 0 || c.VTime != 0 || c.NoHangupOnClose || c.SuppressTxEcho || c.UseModemControl {
+		return ErrNotSupported
+	}
+
+	return nil
+}
+
+func openPort(c Config) (p Port, err error) {
+	defer func() {
+		err = portError("open", c.Name, err)
+	}()
+
+	if err = checkSupported(&c); err != nil {
+		return nil, err
+	}
+
+	name := devicePath(c.Name)
+
+	var utfName *uint16
+	if utfName, err = syscall.UTF16PtrFromString(name); err != nil {
+		return
+	}
+
+	pt := &impl{c: &c}
+	pt.stats.reset()
+
+	pt.fd, err = syscall.CreateFile(utfName,
+		desiredAccess(c.Mode),
+		0,
+		nil,
+		syscall.OPEN_EXISTING,
+		syscall.FILE_ATTRIBUTE_NORMAL|syscall.FILE_FLAG_OVERLAPPED,
+		0)
+
+	if err == syscall.ERROR_ACCESS_DENIED {
+		// COM ports are opened without sharing, so this means another
+		// process has it open
+		return nil, fmt.Errorf("%w: %s", ErrPortBusy, c.Name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	pt.f = os.NewFile(uintptr(pt.fd), name)
+	defer func() {
+		if err != nil {
+			_ = pt.f.Close()
+		}
+	}()
+
+	if err = pt.setCommState(&c); err != nil {
+		return nil, err
+	}
+	if err = pt.SetBufferSizes(c.RxBufferSize, c.TxBufferSize); err != nil {
+		return nil, err
+	}
+
+	if err = pt.setCommTimeouts(c.ReadTimeout, c.writeTimeout); err != nil {
+		return nil, err
+	}
+
+	if err = pt.setCommMask(evRxChar); err != nil {
+		return nil, err
+	}
+
+	ro, err := newOverlapped()
+	if err != nil {
+		return nil, err
+	}
+	wo, err := newOverlapped()
+	if err != nil {
+		_ = syscall.CloseHandle(ro.HEvent)
+		return nil, err
+	}
+
+	pt.ro = ro
+	pt.wo = wo
+
+	p = pt
+
+	return
+}
+
+// SetReadDeadline sets the timeout for subsequent Read calls. A zero
+// duration (or MaxTimeout) blocks until data arrives, a negative one
+// (NoWait) makes Read return the data already received or ErrTimeout
+// without waiting.
+func (p *impl) SetReadDeadline(t time.Duration) error {
+	p.tm.Lock()
+	defer p.tm.Unlock()
+
+	p.c.ReadTimeout, p.readAt = t, time.Time{}
+
+	return p.setReadTimeout(p.c.ReadTimeout)
+}
+
+// SetWriteDeadline sets the timeout for subsequent Write calls. A zero
+// duration (or MaxTimeout) blocks until all data is written.
+func (p *impl) SetWriteDeadline(t time.Duration) error {
+	p.tm.Lock()
+	defer p.tm.Unlock()
+
+	p.c.writeTimeout, p.writeAt = t, time.Time{}
+
+	return p.setWriteTimeout(p.c.writeTimeout)
+}
+
+// SetReadDeadlineTime sets an absolute deadline for all subsequent Read
+// calls, like net.Conn, in place of the timeout of SetReadDeadline. Once
+// it has passed, reads return the data already received or ErrTimeout.
+// The zero time removes the deadline, making reads block.
+func (p *impl) SetReadDeadlineTime(t time.Time) error {
+	p.tm.Lock()
+	defer p.tm.Unlock()
+
+	p.c.ReadTimeout, p.readAt = 0, t
+
+	return p.setReadTimeout(p.c.ReadTimeout)
+}
+
+// SetWriteDeadlineTime sets an absolute deadline for all subsequent
+// Write calls like SetReadDeadlineTime does for reads. The write timeout
+// is narrowed to the time left for the duration of each Write.
+func (p *impl) SetWriteDeadlineTime(t time.Time) error {
+	p.tm.Lock()
+	defer p.tm.Unlock()
+
+	p.c.writeTimeout, p.writeAt = 0, t
+
+	return p.setWriteTimeout(p.c.writeTimeout)
+}
+
+func (p *impl) SetParity(val Parity) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := p.config()
+	c.Parity = val
+
+	if err := p.setCommState(&c); err != nil {
+		return err
+	}
+
+	p.c.Parity = val
+
+	return nil
+}
+
+// SetStopBits changes the number of stop bits of the open port.
+func (p *impl) SetStopBits(val StopBits) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := p.config()
+	c.StopBits = val
+
+	if err := p.setCommState(&c); err != nil {
+		return err
+	}
+
+	p.c.StopBits = val
+
+	return nil
+}
+
+// SetSize changes the number of data bits of the open port.
+func (p *impl) SetSize(val DataSize) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := p.config()
+	c.Size = val
+
+	if err := p.setCommState(&c); err != nil {
+		return err
+	}
+
+	p.c.Size = val
+
+	return nil
+}
+
+// SetBaud changes the speed of the open port. Other settings and the
+// DTR/RTS lines are left untouched, and data already received but not
+// yet read stays in the input queue.
+func (p *impl) SetBaud(baud int) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if baud <= 0 {
+		return fmt.Errorf("%w: baud rate %v", ErrInvalidArg, baud)
+	}
+
+	params, err := p.getCommState()
+	if err != nil {
+		return err
+	}
+
+	params.BaudRate = uint32(baud)
+
+	if err = p.setDCB(&params); err != nil {
+		return err
+	}
+
+	p.c.Baud = baud
+
+	return nil
+}
+
+// Reconfigure applies the baud rate, data size, parity, stop bits and
+// flow control of c, including the XON/XOFF characters, with a single
+// SetCommState. The port stays open and the control lines keep their
+// levels; the other fields of c are ignored. Zero fields take the
+// defaults of OpenPort. If the settings cannot be applied the port keeps
+// its previous ones.
+func (p *impl) Reconfigure(c Config) error {
+	c.setDefaults()
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	nc := p.config()
+	nc.Baud, nc.Size, nc.Parity, nc.StopBits = c.Baud, c.Size, c.Parity, c.StopBits
+	nc.FlowControl, nc.XonChar, nc.XoffChar = c.FlowControl, c.XonChar, c.XoffChar
+
+	params, err := newDCB(&nc)
+	if err != nil {
+		return err
+	}
+
+	p.cl.Lock()
+	defer p.cl.Unlock()
+
+	// keep the lines where they are rather than where the open left them
+	if nc.FlowControl != FlowDSRDTR {
+		params.flags[0] &^= dcbDtrControlEnable
+		if p.dtr {
+			params.flags[0] |= dcbDtrControlEnable
+		}
+	}
+	if nc.FlowControl != FlowHardware {
+		params.flags[1] &^= dcbRtsControlEnable
+		if p.rts {
+			params.flags[1] |= dcbRtsControlEnable
+		}
+	}
+
+	if err = p.setDCB(&params); err != nil {
+		return err
+	}
+
+	p.c.Baud, p.c.Size, p.c.Parity, p.c.StopBits = nc.Baud, nc.Size, nc.Parity, nc.StopBits
+	p.c.FlowControl, p.c.XonChar, p.c.XoffChar = nc.FlowControl, nc.XonChar, nc.XoffChar
+	if nc.FlowControl == FlowHardware {
+		p.rts = true
+	}
+	if nc.FlowControl == FlowDSRDTR {
+		p.dtr = true
+	}
+
+	return nil
+}
+
+// FlowControl reports the flow control mode currently active on the port.
+func (p *impl) FlowControl() (FlowControl, error) {
+	params, err := p.getCommState()
+	if err != nil {
+		return FlowNone, err
+	}
+
+	if params.flags[0]&dcbOutxCtsFlow != 0 {
+		return FlowHardware, nil
+	}
+
+	if params.flags[1]&(dcbOutX|dcbInX) != 0 {
+		return FlowSoftware, nil
+	}
+
+	if params.flags[0]&dcbOutxDsrFlow != 0 {
+		return FlowDSRDTR, nil
+	}
+
+	return FlowNone, nil
+}
+
+// ActualBaud reads back the rate the driver applied, which differs from
+// Config.Baud when the hardware cannot produce it exactly.
+func (p *impl) ActualBaud() (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	params, err := p.getCommState()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(params.BaudRate), nil
+}
+
+// GetConfig reads the live DCB and reconstructs the effective
+// configuration of the port.
+func (p *impl) GetConfig() (Config, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := p.config()
+
+	params, err := p.getCommState()
+	if err != nil {
+		return c, err
+	}
+
+	c.Baud = int(params.BaudRate)
+	c.Size = DataSize(params.ByteSize)
+
+	switch params.Parity {
+	case 0:
+		c.Parity = ParityNone
+	case 1:
+		c.Parity = ParityOdd
+	case 2:
+		c.Parity = ParityEven
+	case 3:
+		c.Parity = ParityMark
+	case 4:
+		c.Parity = ParitySpace
+	}
+
+	switch params.StopBits {
+	case 0:
+		c.StopBits = Stop1
+	case 1:
+		c.StopBits = Stop1Half
+	case 2:
+		c.StopBits = Stop2
+	}
+
+	switch {
+	case params.flags[0]&dcbOutxCtsFlow != 0:
+		c.FlowControl = FlowHardware
+	case params.flags[1]&(dcbOutX|dcbInX) != 0:
+		c.FlowControl = FlowSoftware
+	case params.flags[0]&dcbOutxDsrFlow != 0:
+		c.FlowControl = FlowDSRDTR
+	default:
+		c.FlowControl = FlowNone
+	}
+
+	c.XonChar = params.XonChar
+	c.XoffChar = params.XoffChar
+
+	return c, nil
+}
+
+// SendXON transmits the XON character, asking the remote end to resume
+// sending.
+func (p *impl) SendXON() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.transmitCommChar(p.c.XonChar)
+}
+
+// SendXOFF transmits the XOFF character, asking the remote end to stop
+// sending.
+func (p *impl) SendXOFF() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.transmitCommChar(p.c.XoffChar)
+}
+
+// Modem status lines as reported by Status and accepted by
+// WaitForStatusChange. These are the MS_*_ON bits of GetCommModemStatus.
+// The output lines StatusDTR and StatusRTS, which it does not report, are
+// only used by GetModemControl and SetModemControl.
+const (
+	StatusCTS uint = 0x0010
+	StatusDSR uint = 0x0020
+	StatusRI  uint = 0x0040
+	StatusDCD uint = 0x0080
+	StatusDTR uint = 0x0001
+	StatusRTS uint = 0x0002
+)
+
+// Status returns the modem line bitmask reported by GetCommModemStatus,
+// see the Status* constants.
+func (p *impl) Status() (uint, error) {
+	status, err := p.getCommModemStatus()
+	return uint(status), err
+}
+
+// ModemStatus returns the state of all modem lines. The inputs are read
+// with a single GetCommModemStatus; DTR and RTS are the levels last set
+// through the port.
+func (p *impl) ModemStatus() (ModemStatus, error) {
+	status, err := p.Status()
+	if err != nil {
+		return ModemStatus{}, err
+	}
+
+	p.cl.Lock()
+	defer p.cl.Unlock()
+
+	return ModemStatus{
+		CTS: status&StatusCTS != 0,
+		DSR: status&StatusDSR != 0,
+		DCD: status&StatusDCD != 0,
+		RI:  status&StatusRI != 0,
+		DTR: p.dtr,
+		RTS: p.rts,
+	}, nil
+}
+
+// CTS reports whether Clear To Send is asserted.
+func (p *impl) CTS() (bool, error) {
+	return p.modemLine(StatusCTS)
+}
+
+// DSR reports whether Data Set Ready is asserted.
+func (p *impl) DSR() (bool, error) {
+	return p.modemLine(StatusDSR)
+}
+
+// DCD reports whether Data Carrier Detect (RLSD) is asserted.
+func (p *impl) DCD() (bool, error) {
+	return p.modemLine(StatusDCD)
+}
+
+// RI reports whether Ring Indicator is asserted.
+func (p *impl) RI() (bool, error) {
+	return p.modemLine(StatusRI)
+}
+
+func (p *impl) modemLine(mask uint) (bool, error) {
+	status, err := p.Status()
+	if err != nil {
+		return false, err
+	}
+
+	return status&mask != 0, nil
+}
+
+// Comm events used by WaitForStatusChange
+const (
+	evRxChar = 0x0001
+	evCTS    = 0x0008
+	evDSR    = 0x0010
+	evRLSD   = 0x0020
+	evRing   = 0x0100
+)
+
+// WaitForStatusChange blocks until one of the given Status* lines changes
+// state or the timeout passes, and returns the new status. A zero
+// timeout waits forever.
+func (p *impl) WaitForStatusChange(lines uint, timeout time.Duration) (uint, error) {
+	var mask uint32
+	if lines&StatusCTS != 0 {
+		mask |= evCTS
+	}
+	if lines&StatusDSR != 0 {
+		mask |= evDSR
+	}
+	if lines&StatusDCD != 0 {
+		mask |= evRLSD
+	}
+	if lines&StatusRI != 0 {
+		mask |= evRing
+	}
+
+	if err := p.setCommMask(mask); err != nil {
+		return 0, err
+	}
+	defer func() {
+		_ = p.setCommMask(evRxChar)
+	}()
+
+	// the event mask and overlapped live on the heap, where a cancelled
+	// wait can still write them until cancel has collected it
+	w := &commWait{p: p}
+	pending, err := w.start()
+	if err != nil {
+		return 0, err
+	}
+	defer w.cancel()
+
+	if pending {
+		ms := uint32(syscall.INFINITE)
+		if deadline := deadlineAfter(timeout); !deadline.IsZero() {
+			ms = 0
+			if remaining := time.Until(deadline); remaining > 0 {
+				ms = uint32((remaining + time.Millisecond - 1) / time.Millisecond)
+			}
+		}
+
+		e, err := syscall.WaitForSingleObject(w.ov.HEvent, ms)
+		switch {
+		case err != nil:
+			return 0, err
+		case e == syscall.WAIT_TIMEOUT:
+			return 0, ErrTimeout
+		}
+
+		if _, err = p.getOverlappedResult(p.fd, w.ov); err != nil {
+			return 0, err
+		}
+	}
+
+	return p.Status()
+}
+
+// SetDTR and SetRTS have no effect while the line is under handshake
+// control.
+func (p *impl) SetDTR(assert bool) error {
+	fn := uintptr(clrDTR)
+	if assert {
+		fn = setDTR
+	}
+
+	return p.setLine(fn, &p.dtr, assert)
+}
+
+func (p *impl) SetRTS(assert bool) error {
+	fn := uintptr(clrRTS)
+	if assert {
+		fn = setRTS
+	}
+
+	return p.setLine(fn, &p.rts, assert)
+}
+
+// setLine changes an output line with EscapeCommFunction and records its
+// new level in line.
+func (p *impl) setLine(fn uintptr, line *bool, assert bool) error {
+	p.cl.Lock()
+	defer p.cl.Unlock()
+
+	if err := p.escapeCommFunction(fn); err != nil {
+		return err
+	}
+
+	*line = assert
+
+	return nil
+}
+
+// PulseDTR deasserts DTR for the given time, as used to reset many
+// microcontroller boards, and then restores its previous level. Other
+// calls are not held up meanwhile; if the port is closed during the
+// pulse, the line is left alone and os.ErrClosed is returned.
+func (p *impl) PulseDTR(low time.Duration) error {
+	return p.pulse(clrDTR, setDTR, &p.dtr, low)
+}
+
+// PulseRTS deasserts RTS for the given time like PulseDTR.
+func (p *impl) PulseRTS(low time.Duration) error {
+	return p.pulse(clrRTS, setRTS, &p.rts, low)
+}
+
+// pulse deasserts an output line with clr for low and restores its
+// previous level, recorded in line, with set. The lock is not held while
+// the line is low, so that Close need not wait for the pulse.
+func (p *impl) pulse(clr, set uintptr, line *bool, low time.Duration) error {
+	p.cl.Lock()
+	prev := *line
+	err := p.escapeCommFunction(clr)
+	if err == nil {
+		*line = false
+	}
+	p.cl.Unlock()
+	if err != nil {
+		return err
+	}
+
+	time.Sleep(low)
+
+	p.cl.Lock()
+	defer p.cl.Unlock()
+
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return os.ErrClosed
+	}
+
+	if prev {
+		if err := p.escapeCommFunction(set); err != nil {
+			return err
+		}
+		*line = true
+	}
+
+	return nil
+}
+
+// SetDTRRTS sets DTR and RTS back to back. Windows has no call changing
+// both lines at once, so a device may briefly see the new DTR level
+// with the old RTS level.
+func (p *impl) SetDTRRTS(dtr, rts bool) error {
+	if err := p.SetDTR(dtr); err != nil {
+		return err
+	}
+
+	return p.SetRTS(rts)
+}
+
+// GetModemControl returns the input lines read with GetCommModemStatus
+// together with the levels last set on DTR and RTS; see the Status*
+// constants.
+func (p *impl) GetModemControl() (uint, error) {
+	status, err := p.Status()
+	if err != nil {
+		return 0, err
+	}
+
+	p.cl.Lock()
+	defer p.cl.Unlock()
+
+	if p.dtr {
+		status |= StatusDTR
+	}
+	if p.rts {
+		status |= StatusRTS
+	}
+
+	return status, nil
+}
+
+// SetModemControl sets the lines in mask to their state in bits, leaving
+// the others alone. Windows changes one line per EscapeCommFunction, so
+// only StatusDTR and StatusRTS are accepted, other bits in mask fail with
+// ErrNotSupported.
+func (p *impl) SetModemControl(bits, mask uint) error {
+	if mask&^(StatusDTR|StatusRTS) != 0 {
+		return ErrNotSupported
+	}
+
+	if mask&StatusDTR != 0 {
+		if err := p.SetDTR(bits&StatusDTR != 0); err != nil {
+			return err
+		}
+	}
+
+	if mask&StatusRTS != 0 {
+		return p.SetRTS(bits&StatusRTS != 0)
+	}
+
+	return nil
+}
+
+// SetRS485 is not supported on Windows, where RS-485 direction control
+// is a property of the adapter's driver.
+func (p *impl) SetRS485(RS485Config) error {
+	return ErrNotSupported
+}
+
+// SetLowLatency is not supported on Windows, where the latency timer of
+// USB adapters is configured in the driver's registry settings.
+func (p *impl) SetLowLatency(bool) error {
+	return ErrNotSupported
+}
+
+// SetFIFOTrigger is not supported on Windows, where the FIFO of a UART is
+// configured in the driver's advanced port settings.
+func (p *impl) SetFIFOTrigger(int) error {
+	return ErrNotSupported
+}
+
+// FIFOTrigger is not supported on Windows, see SetFIFOTrigger.
+func (p *impl) FIFOTrigger() (int, error) {
+	return 0, ErrNotSupported
+}
+
+// defaultBufferSize is the queue size used by SetBufferSizes for 0.
+const defaultBufferSize = 64
+
+// SetBufferSizes asks the driver to resize its receive and transmit
+// queues, in bytes. A size of 0 selects the default. The driver may
+// round the sizes or ignore the request.
+func (p *impl) SetBufferSizes(rx, tx int) error {
+	if rx < 0 || tx < 0 {
+		return ErrInvalidArg
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	c := p.config()
+	c.RxBufferSize, c.TxBufferSize = rx, tx
+
+	if rx == 0 {
+		rx = defaultBufferSize
+	}
+	if tx == 0 {
+		tx = defaultBufferSize
+	}
+
+	if err := p.setupComm(rx, tx); err != nil {
+		return err
+	}
+
+	p.c.RxBufferSize, p.c.TxBufferSize = c.RxBufferSize, c.TxBufferSize
+
+	return nil
+}
+
+// defaultBreakDuration is used by SendBreak when no duration is given.
+const defaultBreakDuration = 250 * time.Millisecond
+
+// SendBreak transmits a break condition for the given duration and then
+// restores the normal line state. A zero duration uses a 250ms break.
+func (p *impl) SendBreak(d time.Duration) error {
+	if d == 0 {
+		d = defaultBreakDuration
+	}
+
+	if err := p.SetBreak(true); err != nil {
+		return err
+	}
+
+	time.Sleep(d)
+
+	return p.SetBreak(false)
+}
+
+// Close closes the port. Closing it again fails with os.ErrClosed.
+func (p *impl) Close() error {
+	p.cl.Lock()
+	defer p.cl.Unlock()
+
+	if atomic.SwapInt32(&p.closed, 1) != 0 {
+		return os.ErrClosed
+	}
+
+	err := p.f.Close()
+	p.closeEvents()
+
+	return err
+}
+
+// closeEvents closes the events of the overlapped reads and writes once
+// the handle is closed, which aborts those in flight; taking rl and wl
+// waits for them to be done with the events. p.cl has to be held.
+func (p *impl) closeEvents() {
+	p.rl.Lock()
+	p.wl.Lock()
+	_ = syscall.CloseHandle(p.ro.HEvent)
+	_ = syscall.CloseHandle(p.wo.HEvent)
+	p.wl.Unlock()
+	p.rl.Unlock()
+}
+
+// Stats returns the number of bytes transferred since the port was
+// opened or ResetStats was called. Reopen does not reset them.
+func (p *impl) Stats() Stats {
+	return p.stats.get()
+}
+
+// ResetStats restarts the counts returned by Stats from zero.
+func (p *impl) ResetStats() {
+	p.stats.reset()
+}
+
+// Name returns the port name it was opened with, Config.Name.
+func (p *impl) Name() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.c.Name
+}
+
+// Fd returns the HANDLE of the port, for calls the package does not wrap.
+// The handle was opened for overlapped I/O. Using it bypasses the locking
+// and timeout handling of the port, and it changes with Reopen.
+func (p *impl) Fd() uintptr {
+	return uintptr(p.fd)
+}
+
+// SyscallConn is not supported on Windows, where the handle is used with
+// overlapped I/O that a syscall.RawConn cannot express; see Fd.
+func (p *impl) SyscallConn() (syscall.RawConn, error) {
+	return nil, ErrNotSupported
+}
+
+// WaitForDCD blocks until Data Carrier Detect is asserted, as when a
+// modem has established a connection, and returns ErrTimeout if this
+// does not happen within timeout. Zero waits as long as it takes.
+func (p *impl) WaitForDCD(timeout time.Duration) error {
+	return waitForDCD(p, timeout)
+}
+
+// Loopback checks the port and its wiring with TX and RX jumpered: it
+// discards pending input, writes pattern and reads it back within
+// timeout. A difference is reported with ErrLoopbackMismatch and the
+// position of the first wrong byte.
+func (p *impl) Loopback(pattern []byte, timeout time.Duration) error {
+	return loopback(p, pattern, timeout)
+}
+
+// CloseGraceful closes the port after waiting up to timeout for the
+// data written to it to be transmitted, unlike Close which may discard
+// it. Zero waits as long as it takes.
+func (p *impl) CloseGraceful(timeout time.Duration) error {
+	return closeGraceful(p, timeout)
+}
+
+// Reopen closes the device and opens it again with the current settings,
+// to recover from a disconnect such as a USB adapter re-enumerating. The
+// port itself stays valid. If the device cannot be opened, for example
+// because it has not reappeared yet, the error is returned and Reopen
+// may be retried. Pending reads and writes fail when the old handle is
+// closed.
+func (p *impl) Reopen() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cl.Lock()
+	defer p.cl.Unlock()
+
+	// COM ports are opened without sharing, so close first, unless Close
+	// or a failed Reopen did already
+	if atomic.SwapInt32(&p.closed, 1) == 0 {
+		_ = p.f.Close()
+		p.closeEvents()
+	}
+
+	np, err := openPort(p.config())
+	if err != nil {
+		return err
+	}
+	n := np.(*impl)
+
+	p.rl.Lock()
+	p.wl.Lock()
+	p.tm.Lock()
+	p.c, p.f, p.fd, p.ro, p.wo = n.c, n.f, n.fd, n.ro, n.wo
+	p.readSet, p.writeSet = n.readSet, n.writeSet
+	p.tm.Unlock()
+	p.wl.Unlock()
+	p.rl.Unlock()
+	p.dtr, p.rts = n.dtr, n.rts
+	atomic.StoreInt32(&p.closed, 0)
+
+	p.la.reset()
+
+	p.em.Lock()
+	p.errs, p.brk = ErrorCounts{}, false
+	p.em.Unlock()
+
+	return nil
+}
+
+// Write returns the number of bytes of buf transferred and ErrTimeout if
+// the write deadline passes before all of buf is written.
+func (p *impl) Write(buf []byte) (int, error) {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	restore, err := p.narrowWrite()
+	if err != nil {
+		return 0, err
+	}
+	defer restore()
+
+	out := translateOutput(p.c.OutputCRLF, buf)
+
+	var n int
+	if p.c.MaxWriteChunk <= 0 {
+		n, err = p.write(out)
+	} else {
+		n, err = p.writeChunks(out, p.c.MaxWriteChunk)
+	}
+
+	return outputWritten(p.c.OutputCRLF, buf, n), err
+}
+
+// writeChunks writes buf with a WriteFile for every max bytes, failing
+// with ErrTimeout if the write deadline passes in between. p.wl has to
+// be held.
+func (p *impl) writeChunks(buf []byte, max int) (n int, err error) {
+	p.tm.Lock()
+	deadline := deadlineFor(p.writeAt, p.c.writeTimeout)
+	p.tm.Unlock()
+
+	for n < len(buf) {
+		if n > 0 && !deadline.IsZero() && !time.Now().Before(deadline) {
+			return n, ErrTimeout
+		}
+
+		end := n + max
+		if end > len(buf) {
+			end = len(buf)
+		}
+
+		var nn int
+		nn, err = p.write(buf[n:end])
+		n += nn
+		if err != nil {
+			return n, err
+		}
+	}
+
+	return n, nil
+}
+
+// narrowWrite narrows the write timeout to the time left until the
+// deadline set by SetWriteDeadlineTime, if any, and returns the function
+// restoring it.
+func (p *impl) narrowWrite() (func(), error) {
+	p.tm.Lock()
+	at := p.writeAt
+	p.tm.Unlock()
+
+	return p.narrowWriteUntil(at)
+}
+
+// narrowWriteUntil narrows the write timeout to the time left until the
+// deadline, unless it is zero, and returns the function restoring it.
+func (p *impl) narrowWriteUntil(deadline time.Time) (func(), error) {
+	if deadline.IsZero() {
+		return func() {}, nil
+	}
+
+	p.tm.Lock()
+	defer p.tm.Unlock()
+
+	left := time.Until(deadline)
+	if left <= 0 {
+		return nil, ErrTimeout
+	}
+
+	if err := p.setWriteTimeout(left); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		p.tm.Lock()
+		defer p.tm.Unlock()
+
+		_ = p.setWriteTimeout(p.c.writeTimeout)
+	}, nil
+}
+
+// WriteAll writes all of b within the write deadline. Unlike Write, whose
+// count is easily overlooked, it fails with a *ShortWriteError telling
+// how much was left if it cannot; it is the method to send whole frames.
+func (p *impl) WriteAll(b []byte) error {
+	return writeAll(b, p.Write)
+}
+
+// WriteProgress writes b like Write, in chunks of a few KB, calling cb
+// with the number of bytes written so far after each chunk. The write
+// deadline bounds the whole call; on error the count written until then
+// is returned.
+func (p *impl) WriteProgress(b []byte, cb func(written int)) (int, error) {
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	p.tm.Lock()
+	deadline := deadlineFor(p.writeAt, p.c.writeTimeout)
+	p.tm.Unlock()
+
+	return writeProgress(b, p.c.MaxWriteChunk, cb, func(chunk []byte) (int, error) {
+		restore, err := p.narrowWriteUntil(deadline)
+		if err != nil {
+			return 0, err
+		}
+		defer restore()
+
+		n, err := p.write(translateOutput(p.c.OutputCRLF, chunk))
+		return outputWritten(p.c.OutputCRLF, chunk, n), err
+	})
+}
+
+// WriteString writes s like Write, within the write deadline.
+func (p *impl) WriteString(s string) (int, error) {
+	return p.Write([]byte(s))
+}
+
+// WriteByte writes the single byte c like Write, within the write
+// deadline.
+func (p *impl) WriteByte(c byte) error {
+	b := [1]byte{c}
+	_, err := p.Write(b[:])
+	return err
+}
+
+// WriteWithAddress sends addr with mark parity followed by data with
+// space parity, the 9-bit addressing of multi-drop buses where the
+// parity bit tells addresses from data. Each part is flushed before the
+// parity is switched, and the previous DCB is restored afterwards. Other
+// writes wait until the sequence is done.
+func (p *impl) WriteWithAddress(addr byte, data []byte) (err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.wl.Lock()
+	defer p.wl.Unlock()
+
+	params, err := p.getCommState()
+	if err != nil {
+		return err
+	}
+	prev := params
+
+	defer func() {
+		if rerr := p.setDCB(&prev); err == nil {
+			err = rerr
+		}
+	}()
+
+	for _, part := range []struct {
+		parity byte
+		b      []byte
+	}{
+		{3, []byte{addr}}, // MARKPARITY
+		{4, data},         // SPACEPARITY
+	} {
+		params.Parity = part.parity
+		if err = p.setDCB(&params); err != nil {
+			return
+		}
+
+		if _, err = p.write(part.b); err != nil {
+			return
+		}
+
+		if err = p.Drain(); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// write writes buf, with p.wl held.
+func (p *impl) write(buf []byte) (int, error) {
+	if p.c.Mode == ReadOnly {
+		return 0, ErrReadOnly
+	}
+
+	if p.c.DumpTx != nil {
+		p.c.DumpTx(buf)
+	}
+
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return 0, os.ErrClosed
+	}
+
+	if err := p.resetEvent(p.wo.HEvent); err != nil {
+		return 0, err
+	}
+	var n uint32
+	err := syscall.WriteFile(p.fd, buf, &n, p.wo)
+	if err != nil && err != syscall.ERROR_IO_PENDING {
+		return int(n), p.ioError("write", err)
+	}
+
+	written, err := p.getOverlappedResult(p.fd, p.wo)
+	p.stats.wrote(written)
+	if err == nil && written < len(buf) {
+		err = ErrTimeout
+	}
+
+	return written, p.ioError("write", err)
+}
+
+// Errors reported by a handle whose device has been removed.
+const (
+	errorGenFailure         syscall.Errno = 31
+	errorBadCommand         syscall.Errno = 22
+	errorDeviceNotConnected syscall.Errno = 1167
+)
+
+// ioError maps the error of a read or write: the operations aborted by
+// Close fail with os.ErrClosed, the others are returned as a *PortError
+// for op, see also disconnected.
+func (p *impl) ioError(op string, err error) error {
+	if err != nil && atomic.LoadInt32(&p.closed) != 0 {
+		return os.ErrClosed
+	}
+
+	return portError(op, p.c.Name, disconnected(err))
+}
+
+// disconnected wraps the errors reported once the device behind the
+// port has been removed in ErrPortDisconnected.
+func disconnected(err error) error {
+	switch err {
+	case syscall.ERROR_ACCESS_DENIED, errorBadCommand, errorGenFailure, errorDeviceNotConnected:
+		return fmt.Errorf("%w: %v", ErrPortDisconnected, err)
+	}
+
+	return err
+}
+
+func (p *impl) Read(buf []byte) (int, error) {
+	if p == nil || p.f == nil {
+		return 0, fmt.Errorf("serial: invalid port on read")
+	}
+
+	if !p.readDirect() {
+		return p.read(buf, p.readDeadline())
+	}
+
+	if n := p.la.take(buf); n > 0 {
+		return n, nil
+	}
+
+	p.rl.Lock()
+	defer p.rl.Unlock()
+
+	return p.readFile(buf)
+}
+
+// ReadUntil reads until the first occurrence of delim and returns the
+// data up to and including it. If the read deadline passes first, the
+// bytes read so far are returned along with ErrTimeout. Bytes read past
+// delim are kept for subsequent reads.
+func (p *impl) ReadUntil(delim byte) ([]byte, error) {
+	return p.la.until(delim, p.readDeadline(), p.readRaw)
+}
+
+// ReadFull reads exactly len(buf) bytes. The read deadline bounds the
+// whole call rather than each underlying read.
+func (p *impl) ReadFull(buf []byte) (int, error) {
+	return readFull(p, buf)
+}
+
+// ReadAtLeast reads into buf until at least min bytes have arrived, like
+// io.ReadAtLeast. The read deadline bounds the whole call; on a shortfall
+// the partial count is returned with ErrTimeout.
+func (p *impl) ReadAtLeast(buf []byte, min int) (int, error) {
+	return readAtLeast(p, buf, min)
+}
+
+// ReadChan reads the port from a goroutine, delivering the data in
+// chunks of up to bufSize bytes as it arrives. Each chunk has to be
+// received before the next read; read timeouts are skipped. The first
+// other error is sent on the error channel and both channels are then
+// closed, as they are without an error when the port is closed.
+func (p *impl) ReadChan(bufSize int) (<-chan []byte, <-chan error) {
+	return readChan(p, bufSize)
+}
+
+// ReadChanPool is ReadChan reading into buffers from pool. The chunks
+// received belong to the receiver, which should hand each back with
+// pool.Release once done with it.
+func (p *impl) ReadChanPool(pool *BufferPool) (<-chan []byte, <-chan error) {
+	return readChanFrom(p, pool.Get)
+}
+
+// ReadTimeout reads like Read, but waits at most d instead of the read
+// deadline, which is left untouched. Zero or MaxTimeout waits forever.
+func (p *impl) ReadTimeout(buf []byte, d time.Duration) (int, error) {
+	return p.read(buf, deadlineAfter(d))
+}
+
+// ReadContext reads into buf like Read, but returns ctx.Err() as soon as
+// ctx is canceled or its deadline passes, canceling the pending
+// overlapped read.
+func (p *impl) ReadContext(ctx context.Context, buf []byte) (int, error) {
+	return readContext(ctx, p, buf, func() {
+		_ = syscall.CancelIoEx(p.fd, p.ro)
+	})
+}
+
+// CancelRead makes a pending read return ErrReadCanceled, from another
+// goroutine, without closing the port. If no read is pending, the next
+// one that does not complete at once is canceled instead, so that a
+// reader about to block is not missed. Later reads work as usual.
+func (p *impl) CancelRead() error {
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return os.ErrClosed
+	}
+
+	atomic.StoreInt32(&p.cancel, 1)
+	_ = syscall.CancelIoEx(p.fd, p.ro)
+
+	return nil
+}
+
+// takeCancel reports and clears a cancel requested by CancelRead.
+func (p *impl) takeCancel() bool {
+	return atomic.CompareAndSwapInt32(&p.cancel, 1, 0)
+}
+
+// readDeadline returns the deadline for a read starting now.
+func (p *impl) readDeadline() time.Time {
+	p.tm.Lock()
+	defer p.tm.Unlock()
+
+	return deadlineFor(p.readAt, p.c.ReadTimeout)
+}
+
+// readDirect reports whether Read can go straight to ReadFile with the
+// COMMTIMEOUTS as set, rather than through read: not for line mode, CR/LF
+// translation or a deadline set with SetReadDeadlineTime.
+func (p *impl) readDirect() bool {
+	return !p.c.Canonical && p.c.InputCRLF == CRLFNone && !p.readAtSet()
+}
+
+// readAtSet reports whether SetReadDeadlineTime set a deadline, which
+// reads have to narrow the COMMTIMEOUTS to.
+func (p *impl) readAtSet() bool {
+	p.tm.Lock()
+	defer p.tm.Unlock()
+
+	return !p.readAt.IsZero()
 }
 
-func openPort(name string, baud int, databits byte, parity Parity, stopbits StopBits) (p Port, err error) {
-	if len(name) > 0 && name[0] != '\\' {
-		name = "\\\\.\\" + name
+// read reads into buf, serving bytes buffered by ReadUntil first.
+func (p *impl) read(buf []byte, deadline time.Time) (int, error) {
+	if p.c.Canonical {
+		return p.la.line(buf, deadline, p.readRaw)
 	}
 
-	var utfName *uint16
-	if utfName, err = syscall.UTF16PtrFromString(name); err != nil {
-		return
+	if n := p.la.take(buf); n > 0 {
+		return n, nil
 	}
 
-	pt := &impl{}
+	return p.readRaw(buf, deadline)
+}
 
-	pt.fd, err = syscall.CreateFile(utfName,
-		syscall.GENERIC_READ|syscall.GENERIC_WRITE,
-		0,
-		nil,
-		syscall.OPEN_EXISTING,
-		syscall.FILE_ATTRIBUTE_NORMAL|syscall.FILE_FLAG_OVERLAPPED,
-		0)
+// readRaw reads whatever is available into buf, waiting until the
+// deadline passes, and translates the line endings for InputCRLF.
+func (p *impl) readRaw(buf []byte, deadline time.Time) (int, error) {
+	for {
+		n, err := p.readTimed(buf, deadline)
+		if n == 0 || p.c.InputCRLF == CRLFNone {
+			return n, err
+		}
 
-	if err != nil {
-		return nil, err
+		if n = translateInput(p.c.InputCRLF, buf[:n]); n > 0 || err != nil {
+			return n, err
+		}
+
+		// only ignored CRs came in
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return 0, ErrTimeout
+		}
 	}
+}
 
-	pt.f = os.NewFile(uintptr(pt.fd), name)
-	defer func() {
-		if err != nil {
-			_ = pt.f.Close()
+// readTimed reads whatever is available into buf, waiting until the
+// deadline passes. The COMMTIMEOUTS are narrowed to the remaining time
+// for the duration of the call.
+func (p *impl) readTimed(buf []byte, deadline time.Time) (int, error) {
+	p.rl.Lock()
+	defer p.rl.Unlock()
+
+	timeout := MaxTimeout
+	if !deadline.IsZero() {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			// only collect what has been received already
+			timeout = NoWait
 		}
-	}()
+	}
 
-	if err = pt.setCommState(baud, databits, parity, stopbits); err != nil {
-		return nil, err
+	p.tm.Lock()
+	narrow := timeout != p.c.ReadTimeout
+	var err error
+	if narrow {
+		err = p.setReadTimeout(timeout)
 	}
-	if err = pt.setupComm(64, 64); err != nil {
-		return nil, err
+	p.tm.Unlock()
+	if err != nil {
+		return 0, err
 	}
 
-	if err = pt.SetReadDeadline(time.Time{}); err != nil {
-		return nil, err
+	if narrow {
+		defer func() {
+			p.tm.Lock()
+			defer p.tm.Unlock()
+
+			_ = p.setReadTimeout(p.c.ReadTimeout)
+		}()
 	}
 
-	if err = pt.setCommMask(); err != nil {
-		return nil, err
+	return p.readFile(buf)
+}
+
+func (p *impl) readFile(buf []byte) (int, error) {
+	if p.c.Mode == WriteOnly {
+		return 0, ErrWriteOnly
 	}
 
-	ro, err := newOverlapped()
-	if err != nil {
-		return nil, err
+	if p.takeBreak() {
+		return 0, ErrBreak
 	}
-	wo, err := newOverlapped()
-	if err != nil {
-		return nil, err
+
+	if atomic.LoadInt32(&p.closed) != 0 {
+		return 0, os.ErrClosed
 	}
 
-	pt.ro = ro
-	pt.wo = wo
+	if err := p.resetEvent(p.ro.HEvent); err != nil {
+		return 0, err
+	}
+	var done uint32
+	err := syscall.ReadFile(p.fd, buf, &done, p.ro)
+	if err != nil && err != syscall.ERROR_IO_PENDING {
+		return int(done), p.ioError("read", err)
+	}
 
-	p = pt
+	if atomic.LoadInt32(&p.cancel) != 0 {
+		// CancelRead came before the read was pending
+		_ = syscall.CancelIoEx(p.fd, p.ro)
+	}
 
-	return
+	n, err := p.getOverlappedResult(p.fd, p.ro)
+	if err != nil && p.takeCancel() {
+		return n, ErrReadCanceled
+	}
+
+	if p.c.ReportErrors || p.c.DetectBreak {
+		// collect the error flags raised while receiving
+		_, _, _ = p.clearCommError()
+	}
+
+	if err == nil && n == 0 && len(buf) > 0 {
+		if p.takeBreak() {
+			return 0, ErrBreak
+		} else if atomic.LoadInt32(&p.closed) != 0 {
+			// closing ended the read, not its deadline
+			return 0, os.ErrClosed
+		}
+
+		// ReadTotalTimeoutConstant elapsed without any data
+		return 0, ErrTimeout
+	}
+
+	p.stats.read(n)
+	if p.c.DumpRx != nil && n > 0 {
+		p.c.DumpRx(buf[:n])
+	}
+
+	return n, p.ioError("read", err)
 }
 
-func (p *impl) SetReadDeadline(t time.Time) error {
-	var duration time.Duration
+// takeBreak reports and clears a break seen by clearCommError. Windows
+// does not tell where in the input the break occurred, so ErrBreak is
+// returned by the read after the one during which it was detected.
+func (p *impl) takeBreak() bool {
+	p.em.Lock()
+	defer p.em.Unlock()
+
+	brk := p.brk
+	p.brk = false
+
+	return brk
+}
 
-	if !t.IsZero() {
-		duration = t.Sub(time.Now())
+// ErrorCounts returns the receive errors seen since the port was opened
+// or ResetErrorCounts was called. Windows only reports whether an error
+// occurred since the last check, so each count is the number of reads
+// ending with that error pending rather than the number of bad
+// characters.
+func (p *impl) ErrorCounts() (ErrorCounts, error) {
+	if _, _, err := p.clearCommError(); err != nil {
+		return ErrorCounts{}, err
 	}
 
-	return p.setCommTimeouts(duration)
+	p.em.Lock()
+	defer p.em.Unlock()
+
+	return p.errs, nil
 }
 
-func (p *impl) Status() (uint, error) {
-	return 0, ErrNotSupported
+// InterruptCounts is only supported on Linux.
+func (p *impl) InterruptCounts() (InterruptCounts, error) {
+	return InterruptCounts{}, ErrNotSupported
 }
 
-func (p *impl) SetDTR(bool) error {
-	return ErrNotSupported
+// ErrorReader is not supported on Windows, which does not report errors
+// in the data; reads from the returned reader fail with ErrNotSupported.
+func (p *impl) ErrorReader() io.Reader {
+	return failReader{ErrNotSupported}
 }
 
-func (p *impl) SetRTS(bool) error {
-	return ErrNotSupported
+// failReader is an io.Reader failing every read with err.
+type failReader struct {
+	err error
 }
 
-func (p *impl) Close() error {
-	return p.f.Close()
+func (r failReader) Read([]byte) (int, error) {
+	return 0, r.err
 }
 
-func (p *impl) Write(buf []byte) (int, error) {
-	p.wl.Lock()
-	defer p.wl.Unlock()
+// ResetErrorCounts restarts the counts returned by ErrorCounts from zero.
+func (p *impl) ResetErrorCounts() error {
+	if _, _, err := p.clearCommError(); err != nil {
+		return err
+	}
 
-	if err := p.resetEvent(p.wo.HEvent); err != nil {
+	p.em.Lock()
+	defer p.em.Unlock()
+
+	p.errs = ErrorCounts{}
+
+	return nil
+}
+
+// Available returns the number of bytes received and waiting in the
+// input queue, including those buffered by ReadUntil.
+func (p *impl) Available() (int, error) {
+	_, stat, err := p.clearCommError()
+	if err != nil {
 		return 0, err
 	}
-	var n uint32
-	err := syscall.WriteFile(p.fd, buf, &n, p.wo)
-	if err != nil && err != syscall.ERROR_IO_PENDING {
-		return int(n), err
-	}
-	return p.getOverlappedResult(p.fd, p.wo)
+
+	return int(stat.cbInQue) + p.la.len(), nil
 }
 
-func (p *impl) Read(buf []byte) (int, error) {
-	if p == nil || p.f == nil {
-		return 0, fmt.Errorf("serial: invalid port on read")
+// Discards data written to the port but not transmitted,
+// or data received but not read. Use Drain to wait for pending
+// output to be sent instead.
+func (p *impl) Flush() error {
+	p.la.reset()
+
+	return p.purgeComm(purgeTxAbort | purgeRxAbort | purgeTxClear | purgeRxClear)
+}
+
+// FlushInput discards data received but not read.
+func (p *impl) FlushInput() error {
+	p.la.reset()
+
+	return p.purgeComm(purgeRxAbort | purgeRxClear)
+}
+
+// FlushOutput discards data written to the port but not transmitted.
+func (p *impl) FlushOutput() error {
+	return p.purgeComm(purgeTxAbort | purgeTxClear)
+}
+
+// FlushInputN discards data received but not read like FlushInput and
+// returns roughly how many bytes that was; data arriving meanwhile may
+// be discarded without being counted.
+func (p *impl) FlushInputN() (int, error) {
+	n, err := p.Available()
+	if err != nil {
+		return 0, err
 	}
 
-	p.rl.Lock()
-	defer p.rl.Unlock()
+	return n, p.FlushInput()
+}
 
-	if err := p.resetEvent(p.ro.HEvent); err != nil {
+// FlushOutputN discards data written but not transmitted like
+// FlushOutput and returns roughly how many bytes that was.
+func (p *impl) FlushOutputN() (int, error) {
+	_, stat, err := p.clearCommError()
+	if err != nil {
 		return 0, err
 	}
-	var done uint32
-	err := syscall.ReadFile(p.fd, buf, &done, p.ro)
-	if err != nil && err != syscall.ERROR_IO_PENDING {
-		return int(done), err
+
+	return int(stat.cbOutQue), p.FlushOutput()
+}
+
+// Drain blocks until all data written to the port has been transmitted.
+// Unlike Flush it does not discard anything.
+func (p *impl) Drain() error {
+	r, _, err := syscall.Syscall(nFlushFileBuffers, 1, uintptr(p.fd), 0, 0)
+	if r == 0 {
+		return err
 	}
-	return p.getOverlappedResult(p.fd, p.ro)
+	return nil
 }
 
-// Discards data written to the port but not transmitted,
-// or data received but not read
-func (p *impl) Flush() error {
-	return p.purgeComm()
+// Sync waits for written data to be transmitted, like Drain, so that
+// the port can stand in for an *os.File. It may be called concurrently
+// with Write.
+func (p *impl) Sync() error {
+	return p.Drain()
 }
 
 var (
+	nGetCommState,
 	nSetCommState,
 	nSetCommTimeouts,
 	nSetCommMask,
@@ -187,6 +1618,14 @@ var (
 	nCreateEvent,
 	nResetEvent,
 	nPurgeComm,
+	nTransmitCommChar,
+	nSetCommBreak,
+	nGetCommModemStatus,
+	nClearCommError,
+	nWaitCommEvent,
+	nClearCommBreak,
+	nEscapeCommFunction,
+	nWaitForMultipleObjects,
 	nFlushFileBuffers uintptr
 )
 
@@ -199,6 +1638,7 @@ func init() {
 		_ = syscall.FreeLibrary(k32)
 	}()
 
+	nGetCommState = getProcAddr(k32, "GetCommState")
 	nSetCommState = getProcAddr(k32, "SetCommState")
 	nSetCommTimeouts = getProcAddr(k32, "SetCommTimeouts")
 	nSetCommMask = getProcAddr(k32, "SetCommMask")
@@ -207,7 +1647,15 @@ func init() {
 	nCreateEvent = getProcAddr(k32, "CreateEventW")
 	nResetEvent = getProcAddr(k32, "ResetEvent")
 	nPurgeComm = getProcAddr(k32, "PurgeComm")
+	nTransmitCommChar = getProcAddr(k32, "TransmitCommChar")
+	nSetCommBreak = getProcAddr(k32, "SetCommBreak")
+	nGetCommModemStatus = getProcAddr(k32, "GetCommModemStatus")
+	nClearCommError = getProcAddr(k32, "ClearCommError")
+	nWaitCommEvent = getProcAddr(k32, "WaitCommEvent")
+	nClearCommBreak = getProcAddr(k32, "ClearCommBreak")
 	nFlushFileBuffers = getProcAddr(k32, "FlushFileBuffers")
+	nEscapeCommFunction = getProcAddr(k32, "EscapeCommFunction")
+	nWaitForMultipleObjects = getProcAddr(k32, "WaitForMultipleObjects")
 }
 
 func getProcAddr(lib syscall.Handle, name string) uintptr {
@@ -218,18 +1666,106 @@ func getProcAddr(lib syscall.Handle, name string) uintptr {
 	return addr
 }
 
-func (p *impl) setCommState(baud int, databits byte, parity Parity, stopbits StopBits) error {
-	var params structDCB
+// DCB flag bits, see
+// https://docs.microsoft.com/en-us/windows/win32/api/winbase/ns-winbase-dcb
+const (
+	dcbParity            = 0x02 // flags[0]
+	dcbOutxCtsFlow       = 0x04 // flags[0]
+	dcbOutxDsrFlow       = 0x08 // flags[0]
+	dcbDtrControlHandshk = 0x20 // flags[0], fDtrControl = DTR_CONTROL_HANDSHAKE
+	dcbDtrControlEnable  = 0x10 // flags[0], fDtrControl = DTR_CONTROL_ENABLE
+	dcbRtsControlEnable  = 0x10 // flags[1], fRtsControl = RTS_CONTROL_ENABLE
+	dcbOutX              = 0x01 // flags[1]
+	dcbInX               = 0x02 // flags[1]
+	dcbErrorChar         = 0x04 // flags[1]
+	dcbRtsControlHandshk = 0x20 // flags[1], fRtsControl = RTS_CONTROL_HANDSHAKE
+)
+
+func (p *impl) getCommState() (params structDCB, err error) {
 	params.DCBlength = uint32(unsafe.Sizeof(params))
 
-	params.flags[0] = 0x01  // fBinary
-	params.flags[0] |= 0x10 // Assert DSR
+	r, _, e := syscall.Syscall(nGetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&params)), 0)
+	if r == 0 {
+		err = e
+	}
 
-	params.BaudRate = uint32(baud)
+	return
+}
+
+func (p *impl) setCommState(c *Config) error {
+	params, err := newDCB(c)
+	if err != nil {
+		return err
+	}
+
+	p.cl.Lock()
+	defer p.cl.Unlock()
+
+	if err = p.setDCB(&params); err != nil {
+		return err
+	}
+
+	// SetCommState drives the lines as the DCB says
+	p.dtr = params.flags[0]&(dcbDtrControlEnable|dcbDtrControlHandshk) != 0
+	p.rts = params.flags[1]&(dcbRtsControlEnable|dcbRtsControlHandshk) != 0
+
+	return nil
+}
+
+// config returns a copy of the settings. p.mu has to be held.
+func (p *impl) config() Config {
+	p.tm.Lock()
+	defer p.tm.Unlock()
+
+	return *p.c
+}
 
-	params.ByteSize = databits
+// newDCB builds the device control block for c.
+func newDCB(c *Config) (params structDCB, err error) {
+	params.DCBlength = uint32(unsafe.Sizeof(params))
+
+	params.flags[0] = 0x01 // fBinary
+
+	switch c.InitialDTR {
+	case LineLeave:
+		if !c.NoResetOnOpen && c.FlowControl != FlowDSRDTR {
+			params.flags[0] |= dcbDtrControlEnable
+		}
+	case LineAssert:
+		if c.FlowControl != FlowDSRDTR {
+			params.flags[0] |= dcbDtrControlEnable
+		}
+	case LineDeassert:
+	default:
+		err = ErrInvalidArg
+		return
+	}
+
+	switch c.InitialRTS {
+	case LineLeave, LineDeassert:
+	case LineAssert:
+		if c.FlowControl != FlowHardware {
+			params.flags[1] |= dcbRtsControlEnable
+		}
+	default:
+		err = ErrInvalidArg
+		return
+	}
+
+	params.BaudRate = uint32(c.Baud)
+
+	switch c.Size {
+	case 5, 6, 7, 8:
+		params.ByteSize = byte(c.Size)
+	default:
+		err = ErrBadSize
+		return
+	}
 
-	switch parity {
+	params.XonChar = c.XonChar
+	params.XoffChar = c.XoffChar
+
+	switch c.Parity {
 	case ParityNone:
 		params.Parity = 0
 	case ParityOdd:
@@ -241,10 +1777,29 @@ func (p *impl) setCommState(baud int, databits byte, parity Parity, stopbits Sto
 	case ParitySpace:
 		params.Parity = 4
 	default:
-		return ErrBadParity
+		err = ErrBadParity
+		return
+	}
+
+	switch c.ParityErrorMode {
+	case ParityErrorPassThrough:
+		if c.ReportErrors {
+			params.flags[0] |= dcbParity
+		}
+	case ParityErrorMark:
+		params.flags[0] |= dcbParity
+		params.flags[1] |= dcbErrorChar
+		params.ErrorChar = 0xFF
+	default:
+		err = ErrNotSupported
+		return
+	}
+
+	if err = checkStopBits(c.Size, c.StopBits); err != nil {
+		return
 	}
 
-	switch stopbits {
+	switch c.StopBits {
 	case Stop1:
 		params.StopBits = 0
 	case Stop1Half:
@@ -252,23 +1807,56 @@ func (p *impl) setCommState(baud int, databits byte, parity Parity, stopbits Sto
 	case Stop2:
 		params.StopBits = 2
 	default:
-		return ErrBadStopBits
+		err = ErrBadStopBits
+		return
+	}
+
+	switch c.FlowControl {
+	case FlowNone:
+	case FlowHardware:
+		params.flags[0] |= dcbOutxCtsFlow
+		params.flags[1] |= dcbRtsControlHandshk
+	case FlowSoftware:
+		params.flags[1] |= dcbOutX | dcbInX
+		params.XonLim = 2048
+		params.XoffLim = 512
+	case FlowDSRDTR:
+		params.flags[0] |= dcbOutxDsrFlow | dcbDtrControlHandshk
+	default:
+		err = ErrBadFlowControl
+		return
 	}
 
-	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&params)), 0)
+	return
+}
+
+func (p *impl) setDCB(params *structDCB) error {
+	r, _, err := syscall.Syscall(nSetCommState, 2, uintptr(p.fd), uintptr(unsafe.Pointer(params)), 0)
 	if r == 0 {
 		return err
 	}
 	return nil
 }
 
-func (p *impl) setCommTimeouts(readTimeout time.Duration) error {
+// setReadTimeout applies the read timeout t, keeping the write timeout
+// as currently applied. p.tm has to be held.
+func (p *impl) setReadTimeout(t time.Duration) error {
+	return p.setCommTimeouts(t, p.writeSet)
+}
+
+// setWriteTimeout applies the write timeout t, keeping the read timeout
+// as currently applied. p.tm has to be held.
+func (p *impl) setWriteTimeout(t time.Duration) error {
+	return p.setCommTimeouts(p.readSet, t)
+}
+
+func (p *impl) setCommTimeouts(readTimeout, writeTimeout time.Duration) error {
 	var timeouts structTimeouts
 
 	// blocking read by default
 	var timeoutMs int64 = math.MaxUint32 - 1
 
-	if readTimeout > 0 {
+	if readTimeout > 0 && readTimeout != MaxTimeout {
 		// non-blocking read
 		timeoutMs = readTimeout.Nanoseconds() / 1e6
 		if timeoutMs < 1 {
@@ -304,14 +1892,56 @@ func (p *impl) setCommTimeouts(readTimeout time.Duration) error {
 	timeouts.ReadTotalTimeoutMultiplier = math.MaxUint32
 	timeouts.ReadTotalTimeoutConstant = uint32(timeoutMs)
 
+	// Or the timeouts configured by the caller, where zero disables the
+	// total timeout of a blocking read
+	if readTimeout < 0 {
+		// ReadFile returns at once with whatever is in the buffer
+		timeouts.ReadTotalTimeoutMultiplier = 0
+		timeouts.ReadTotalTimeoutConstant = 0
+	} else if p.c.ReadIntervalTimeout > 0 || p.c.ReadTotalTimeoutMultiplier > 0 {
+		timeouts.ReadIntervalTimeout = commTimeout(p.c.ReadIntervalTimeout)
+		timeouts.ReadTotalTimeoutMultiplier = commTimeout(p.c.ReadTotalTimeoutMultiplier)
+		if readTimeout <= 0 || readTimeout == MaxTimeout {
+			timeouts.ReadTotalTimeoutConstant = 0
+		}
+	}
+
+	// Zero write timeouts mean a Write blocks until all data is sent
+	if writeTimeout > 0 && writeTimeout != MaxTimeout {
+		timeoutMs = writeTimeout.Nanoseconds() / 1e6
+		if timeoutMs < 1 {
+			timeoutMs = 1
+		} else if timeoutMs > math.MaxUint32-1 {
+			timeoutMs = math.MaxUint32 - 1
+		}
+		timeouts.WriteTotalTimeoutConstant = uint32(timeoutMs)
+	}
+
 	r, _, err := syscall.Syscall(nSetCommTimeouts, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&timeouts)), 0)
 	if r == 0 {
 		return err
 	}
+	p.readSet, p.writeSet = readTimeout, writeTimeout
 
 	return nil
 }
 
+// commTimeout converts d to a COMMTIMEOUTS value in milliseconds, where
+// zero leaves the timeout unused.
+func commTimeout(d time.Duration) uint32 {
+	ms := d.Nanoseconds() / 1e6
+	switch {
+	case d <= 0:
+		return 0
+	case ms < 1:
+		return 1
+	case ms > math.MaxUint32-1:
+		return math.MaxUint32 - 1
+	}
+
+	return uint32(ms)
+}
+
 func (p *impl) setupComm(in, out int) error {
 	r, _, err := syscall.Syscall(nSetupComm, 3, uintptr(p.fd), uintptr(in), uintptr(out))
 	if r == 0 {
@@ -320,9 +1950,8 @@ func (p *impl) setupComm(in, out int) error {
 	return nil
 }
 
-func (p *impl) setCommMask() error {
-	const EV_RXCHAR = 0x0001
-	r, _, err := syscall.Syscall(nSetCommMask, 2, uintptr(p.fd), EV_RXCHAR, 0)
+func (p *impl) setCommMask(mask uint32) error {
+	r, _, err := syscall.Syscall(nSetCommMask, 2, uintptr(p.fd), uintptr(mask), 0)
 	if r == 0 {
 		return err
 	}
@@ -337,19 +1966,112 @@ func (p *impl) resetEvent(h syscall.Handle) error {
 	return nil
 }
 
-func (p *impl) purgeComm() error {
-	const PURGE_TXABORT = 0x0001
-	const PURGE_RXABORT = 0x0002
-	const PURGE_TXCLEAR = 0x0004
-	const PURGE_RXCLEAR = 0x0008
-	r, _, err := syscall.Syscall(nPurgeComm, 2, uintptr(p.fd),
-		PURGE_TXABORT|PURGE_RXABORT|PURGE_TXCLEAR|PURGE_RXCLEAR, 0)
+// PurgeComm flags
+const (
+	purgeTxAbort = 0x0001
+	purgeRxAbort = 0x0002
+	purgeTxClear = 0x0004
+	purgeRxClear = 0x0008
+)
+
+func (p *impl) purgeComm(flags uintptr) error {
+	r, _, err := syscall.Syscall(nPurgeComm, 2, uintptr(p.fd), flags, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// Functions of EscapeCommFunction
+const (
+	setRTS = 3
+	clrRTS = 4
+	setDTR = 5
+	clrDTR = 6
+)
+
+func (p *impl) escapeCommFunction(fn uintptr) error {
+	r, _, err := syscall.Syscall(nEscapeCommFunction, 2, uintptr(p.fd), fn, 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+func (p *impl) transmitCommChar(ch byte) error {
+	r, _, err := syscall.Syscall(nTransmitCommChar, 2, uintptr(p.fd), uintptr(ch), 0)
+	if r == 0 {
+		return err
+	}
+	return nil
+}
+
+// SetBreak asserts the break condition on the transmit line, or clears
+// it, leaving the line in that state until the next call. SendBreak
+// sends a break of fixed length instead.
+func (p *impl) SetBreak(on bool) error {
+	proc := nSetCommBreak
+	if !on {
+		proc = nClearCommBreak
+	}
+
+	r, _, err := syscall.Syscall(proc, 1, uintptr(p.fd), 0, 0)
 	if r == 0 {
 		return err
 	}
 	return nil
 }
 
+func (p *impl) getCommModemStatus() (uint32, error) {
+	var status uint32
+	r, _, err := syscall.Syscall(nGetCommModemStatus, 2, uintptr(p.fd), uintptr(unsafe.Pointer(&status)), 0)
+	if r == 0 {
+		return 0, err
+	}
+	return status, nil
+}
+
+// Error flags returned by ClearCommError
+const (
+	ceRxOver   = 0x0001
+	ceOverrun  = 0x0002
+	ceRxParity = 0x0004
+	ceFrame    = 0x0008
+	ceBreak    = 0x0010
+)
+
+func (p *impl) clearCommError() (uint32, structComstat, error) {
+	var errors uint32
+	var stat structComstat
+	r, _, err := syscall.Syscall(nClearCommError, 3, uintptr(p.fd),
+		uintptr(unsafe.Pointer(&errors)), uintptr(unsafe.Pointer(&stat)))
+	if r == 0 {
+		return 0, stat, err
+	}
+
+	// the flags are cleared by this call, so count them here whoever
+	// the caller is
+	p.em.Lock()
+	if errors&ceFrame != 0 {
+		p.errs.Frame++
+	}
+	if errors&ceRxParity != 0 {
+		p.errs.Parity++
+	}
+	if errors&ceOverrun != 0 {
+		p.errs.Overrun++
+	}
+	if errors&ceRxOver != 0 {
+		p.errs.BufferOverrun++
+	}
+	if errors&ceBreak != 0 && p.c.DetectBreak {
+		p.brk = true
+	}
+	p.em.Unlock()
+
+	return errors, stat, nil
+}
+
 func newOverlapped() (*syscall.Overlapped, error) {
 	var overlapped syscall.Overlapped
 	r, _, err := syscall.Syscall6(nCreateEvent, 4, 0, 1, 0, 0, 0, 0)
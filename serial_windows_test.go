@@ -0,0 +1,133 @@
+// +build windows
+
+package serial
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewDCB(t *testing.T) {
+	c := Config{Baud: 9600, Parity: ParityEven, StopBits: Stop1}
+
+	for _, size := range []DataSize{5, 6, 7, 8} {
+		c.Size = size
+		params, err := newDCB(&c)
+		require.NoError(t, err)
+		require.Equal(t, byte(size), params.ByteSize)
+		require.Equal(t, byte(2), params.Parity)
+	}
+
+	for _, size := range []DataSize{0, 4, 9} {
+		c.Size = size
+		_, err := newDCB(&c)
+		require.Equal(t, ErrBadSize, err)
+	}
+}
+
+func TestNewDCBStopBits(t *testing.T) {
+	c := Config{Baud: 9600, Size: 5, Parity: ParityNone, StopBits: Stop1Half}
+
+	params, err := newDCB(&c)
+	require.NoError(t, err)
+	require.Equal(t, byte(1), params.StopBits) // ONE5STOPBITS
+
+	c.Size = 8
+	_, err = newDCB(&c)
+	require.Equal(t, ErrBadStopBits, err)
+}
+
+func TestNewDCBInitialLines(t *testing.T) {
+	c := Config{Baud: 9600, Size: 8, Parity: ParityNone, StopBits: Stop1}
+
+	params, err := newDCB(&c)
+	require.NoError(t, err)
+	require.NotZero(t, params.flags[0]&dcbDtrControlEnable)
+
+	c.NoResetOnOpen = true
+	c.InitialRTS = LineAssert
+	params, err = newDCB(&c)
+	require.NoError(t, err)
+	require.Zero(t, params.flags[0]&dcbDtrControlEnable)
+	require.NotZero(t, params.flags[1]&dcbRtsControlEnable)
+}
+
+func TestNewDCBFlowDSRDTR(t *testing.T) {
+	c := Config{Baud: 9600, Size: 8, Parity: ParityNone, StopBits: Stop1, FlowControl: FlowDSRDTR}
+
+	params, err := newDCB(&c)
+	require.NoError(t, err)
+	require.Equal(t, byte(dcbOutxDsrFlow|dcbDtrControlHandshk), params.flags[0]&(dcbOutxDsrFlow|dcbDtrControlHandshk|dcbDtrControlEnable|dcbOutxCtsFlow))
+	require.Zero(t, params.flags[1]&(dcbRtsControlHandshk|dcbOutX|dcbInX))
+}
+
+func TestCRLF(t *testing.T) {
+	p := &impl{c: &Config{}}
+	require.True(t, p.readDirect())
+
+	// translating reads have to go through readRaw
+	p.c.InputCRLF = CRToLF
+	require.False(t, p.readDirect())
+
+	p.c.InputCRLF = CRLFNone
+	p.readAt = time.Now().Add(time.Second)
+	require.False(t, p.readDirect())
+}
+
+func TestSetBaudInvalid(t *testing.T) {
+	p := &impl{c: &Config{}}
+	require.True(t, errors.Is(p.SetBaud(0), ErrInvalidArg))
+	require.True(t, errors.Is(p.SetBaud(-9600), ErrInvalidArg))
+}
+
+func TestCheckSupported(t *testing.T) {
+	require.NoError(t, checkSupported(&Config{Name: "COM1", Baud: 9600}))
+
+	for _, c := range []Config{
+		{RS485: RS485Config{Enabled: true}},
+		{LowLatency: true},
+		{VMin: 1},
+		{VTime: 1},
+		{NoHangupOnClose: true},
+		{SuppressTxEcho: true},
+		{UseModemControl: true},
+	} {
+		require.Equal(t, ErrNotSupported, checkSupported(&c))
+	}
+}
+
+func TestDevicePath(t *testing.T) {
+	require.Equal(t, `\\.\COM3`, devicePath("COM3"))
+	require.Equal(t, `\\.\COM12`, devicePath("COM12"))
+	require.Equal(t, `\\.\COM12`, devicePath(`\\.\COM12`))
+	require.Equal(t, `\\?\COM12`, devicePath(`\\?\COM12`))
+}
+
+func TestCommTimeout(t *testing.T) {
+	require.Equal(t, uint32(0), commTimeout(0))
+	require.Equal(t, uint32(1), commTimeout(time.Microsecond))
+	require.Equal(t, uint32(20), commTimeout(20*time.Millisecond))
+}
+
+func TestParseInstanceID(t *testing.T) {
+	for _, tc := range []struct {
+		id       string
+		vid, pid uint16
+		serial   string
+		usb      bool
+	}{
+		{`USB\VID_0403&PID_6001\A9XK3L2Q`, 0x0403, 0x6001, "A9XK3L2Q", true},
+		{`USB\VID_2341&PID_0043\5&2B4A0D7&0&2`, 0x2341, 0x0043, "", true},
+		{`FTDIBUS\VID_0403+PID_6001+A9XK3L2QA\0000`, 0x0403, 0x6001, "A9XK3L2Q", true},
+		{`ACPI\PNP0501\1`, 0, 0, "", false},
+	} {
+		vid, pid, serial, usb := parseInstanceID(tc.id)
+		require.Equal(t, tc.vid, vid, tc.id)
+		require.Equal(t, tc.pid, pid, tc.id)
+		require.Equal(t, tc.serial, serial, tc.id)
+		require.Equal(t, tc.usb, usb, tc.id)
+	}
+}
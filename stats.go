@@ -0,0 +1,64 @@
+package serial
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats counts the data transferred by a port, see Port.Stats.
+type Stats struct {
+	// BytesRead and BytesWritten are the bytes received from and sent
+	// to the device.
+	BytesRead    uint64
+	BytesWritten uint64
+	// LastRead and LastWrite are the times of the last transfer in each
+	// direction, zero if there was none.
+	LastRead  time.Time
+	LastWrite time.Time
+	// Since is when counting started, at open or the last ResetStats.
+	Since time.Time
+}
+
+// counters accumulates the Stats of a port.
+type counters struct {
+	mu sync.Mutex
+	s  Stats
+}
+
+func (c *counters) read(n int) {
+	if n <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.s.BytesRead += uint64(n)
+	c.s.LastRead = time.Now()
+}
+
+func (c *counters) wrote(n int) {
+	if n <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.s.BytesWritten += uint64(n)
+	c.s.LastWrite = time.Now()
+}
+
+func (c *counters) get() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.s
+}
+
+func (c *counters) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.s = Stats{Since: time.Now()}
+}
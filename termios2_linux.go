@@ -0,0 +1,10 @@
+// +build linux,!ppc64,!ppc64le
+
+package serial
+
+import "golang.org/x/sys/unix"
+
+const (
+	ioctlGetTermios2 = unix.TCGETS2
+	ioctlSetTermios2 = unix.TCSETS2
+)
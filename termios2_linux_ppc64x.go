@@ -0,0 +1,13 @@
+// +build linux
+// +build ppc64 ppc64le
+
+package serial
+
+import "golang.org/x/sys/unix"
+
+// The powerpc struct termios already carries the speed fields, so
+// TCGETS/TCSETS accept BOTHER directly.
+const (
+	ioctlGetTermios2 = unix.TCGETS
+	ioctlSetTermios2 = unix.TCSETS
+)
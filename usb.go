@@ -0,0 +1,63 @@
+package serial
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPortNotFound is returned by OpenByUSB if no port matches.
+var ErrPortNotFound = errors.New("serial: no matching port")
+
+// PortInfo describes a serial port found by DetailedPorts. The USB
+// fields are only set for ports of USB adapters, and may be empty if the
+// device does not report them; not every adapter has a serial number.
+type PortInfo struct {
+	Name         string // the name to open the port with
+	Description  string // the product name, or the driver of other ports
+	IsUSB        bool
+	VID, PID     uint16 // USB vendor and product ID
+	SerialNumber string
+	Manufacturer string
+}
+
+// OpenByUSB opens the port of the USB adapter with the given vendor and
+// product ID, and serial number unless empty, with the settings of c but
+// for the name. This picks the same adapter whichever device name it got
+// assigned. ErrPortNotFound is returned if no port matches, an error
+// wrapping ErrInvalidArg if several do, as with adapters having more
+// than one port; their names can be found with DetailedPorts.
+func OpenByUSB(vid, pid uint16, serial string, c Config) (Port, error) {
+	ports, err := DetailedPorts()
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := findUSB(ports, vid, pid, serial)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Name = info.Name
+
+	return OpenPort(c)
+}
+
+// findUSB returns the single port of ports matching vid, pid and serial,
+// as for OpenByUSB.
+func findUSB(ports []PortInfo, vid, pid uint16, serial string) (PortInfo, error) {
+	var found []PortInfo
+	for _, info := range ports {
+		if info.IsUSB && info.VID == vid && info.PID == pid && (serial == "" || info.SerialNumber == serial) {
+			found = append(found, info)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return PortInfo{}, fmt.Errorf("%w: USB %04x:%04x", ErrPortNotFound, vid, pid)
+	case 1:
+		return found[0], nil
+	}
+
+	return PortInfo{}, fmt.Errorf("%w: %d ports match USB %04x:%04x", ErrInvalidArg, len(found), vid, pid)
+}
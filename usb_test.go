@@ -0,0 +1,31 @@
+package serial
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFindUSB(t *testing.T) {
+	ports := []PortInfo{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB0", IsUSB: true, VID: 0x0403, PID: 0x6001, SerialNumber: "A1"},
+		{Name: "/dev/ttyUSB1", IsUSB: true, VID: 0x0403, PID: 0x6001, SerialNumber: "B2"},
+		{Name: "/dev/ttyACM0", IsUSB: true, VID: 0x2341, PID: 0x0043},
+	}
+
+	info, err := findUSB(ports, 0x0403, 0x6001, "B2")
+	require.NoError(t, err)
+	require.Equal(t, "/dev/ttyUSB1", info.Name)
+
+	info, err = findUSB(ports, 0x2341, 0x0043, "")
+	require.NoError(t, err)
+	require.Equal(t, "/dev/ttyACM0", info.Name)
+
+	_, err = findUSB(ports, 0x0403, 0x6001, "")
+	require.True(t, errors.Is(err, ErrInvalidArg), "got %v", err)
+
+	_, err = findUSB(ports, 0x0403, 0x6001, "C3")
+	require.True(t, errors.Is(err, ErrPortNotFound), "got %v", err)
+}